@@ -1,17 +1,22 @@
 package main
 
 import (
+	"archive/tar"
 	"archive/zip"
+	"compress/flate"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/kjk/u"
+	"github.com/klauspost/compress/zstd"
 )
 
 var (
@@ -55,6 +60,9 @@ func getFileNamesWithPrefix(prefix string) [][]string {
 		{"SumatraPDF.pdb.zip", fmt.Sprintf("%s.pdb.zip", prefix)},
 		{"SumatraPDF.pdb.lzsa", fmt.Sprintf("%s.pdb.lzsa", prefix)},
 	}
+	if flgZstd {
+		files = append(files, []string{"SumatraPDF.tar.zst", fmt.Sprintf("%s.tar.zst", prefix)})
+	}
 	return files
 }
 
@@ -74,11 +82,35 @@ func copyBuiltFiles(dstDir string, srcDir string, prefix string) {
 	}
 }
 
-func copyBuiltManifest(dstDir string, prefix string) {
+// copies manifest to dstDir as manifestName(), adding size and checksums
+// (of -checksums algorithms) of files in dstDir (see parseManifestHashes())
+// so that we can tell if uploaded files were later replaced or corrupted
+func copyBuiltManifest(dstDir string, buildType string, ver string) {
 	srcPath := filepath.Join(artifactsDir, "manifest.txt")
-	dstName := prefix + "-manifest.txt"
+	dstName := manifestName(buildType, ver)
 	dstPath := filepath.Join(dstDir, dstName)
-	u.CopyFileMust(dstPath, srcPath)
+	d := u.ReadFileMust(srcPath)
+	lines := []string{strings.TrimRight(string(d), "\n")}
+	algos, err := parseChecksumAlgos(flgChecksums)
+	must(err)
+	files, err := ioutil.ReadDir(dstDir)
+	must(err)
+	for _, f := range files {
+		if _, ok := checksumFileAlgo(f.Name()); ok || f.IsDir() || isManifestFile(f.Name()) || isBuildDescFile(f.Name()) {
+			continue
+		}
+		sums, err := computeChecksums(filepath.Join(dstDir, f.Name()), algos)
+		must(err)
+		for _, algo := range algos {
+			lines = append(lines, fmt.Sprintf("%s %s: %d %s", checksumAlgoName(algo), f.Name(), f.Size(), sums[algo]))
+		}
+		if flgZipSizes && strings.HasSuffix(f.Name(), ".zip") {
+			size, err := zipUncompressedSize(filepath.Join(dstDir, f.Name()))
+			must(err)
+			lines = append(lines, fmt.Sprintf("%s%s: %d", manifestUnzippedPrefix, f.Name(), size))
+		}
+	}
+	u.WriteFileMust(dstPath, []byte(strings.Join(lines, "\n")))
 }
 
 func build(dir, config, platform string) {
@@ -253,39 +285,152 @@ func revertBuildConfig() {
 	runExeMust("git", "checkout", buildConfigPath())
 }
 
-func addZipFileWithNameMust(w *zip.Writer, path, nameInZip string) {
-	fi, err := os.Stat(path)
-	panicIfErr(err)
-	fih, err := zip.FileInfoHeader(fi)
-	panicIfErr(err)
-	fih.Name = nameInZip
-	fih.Method = zip.Deflate
-	d, err := ioutil.ReadFile(path)
-	panicIfErr(err)
-	fw, err := w.CreateHeader(fih)
-	panicIfErr(err)
-	_, err = fw.Write(d)
-	panicIfErr(err)
-	// fw is just a io.Writer so we can't Close() it. It's not necessary as
-	// it's implicitly closed by the next Create(), CreateHeader()
-	// or Close() call on zip.Writer
+// time of all files in zips created with createReproducibleZip(). It's
+// the earliest time that dos time in zip headers can represent
+var reproducibleZipTime = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// returns total size of files in zip, from its central directory
+func zipUncompressedSize(path string) (int64, error) {
+	r, err := zip.OpenReader(path)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+	var res uint64
+	for _, f := range r.File {
+		res += f.UncompressedSize64
+	}
+	return int64(res), nil
 }
 
-func addZipFileMust(w *zip.Writer, path string) {
-	nameInZip := filepath.Base(path)
-	addZipFileWithNameMust(w, path, nameInZip)
+// like createReproducibleZip() but files are stored in zip as namesInZip
+// (same order as paths)
+func createReproducibleZipWithNames(paths []string, namesInZip []string, out string) error {
+	panicIf(len(paths) != len(namesInZip), "%d paths but %d names", len(paths), len(namesInZip))
+	idx := make([]int, len(paths))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.Slice(idx, func(i, j int) bool {
+		return namesInZip[idx[i]] < namesInZip[idx[j]]
+	})
+
+	f, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := zip.NewWriter(f)
+	w.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
+		return flate.NewWriter(out, flate.BestCompression)
+	})
+	for _, i := range idx {
+		d, err := ioutil.ReadFile(paths[i])
+		if err != nil {
+			return err
+		}
+		fih := &zip.FileHeader{
+			Name:     namesInZip[i],
+			Method:   zip.Deflate,
+			Modified: reproducibleZipTime,
+		}
+		fih.SetMode(0644)
+		fw, err := w.CreateHeader(fih)
+		if err != nil {
+			return err
+		}
+		// fw is just a io.Writer so we can't Close() it. It's not necessary as
+		// it's implicitly closed by the next Create(), CreateHeader()
+		// or Close() call on zip.Writer
+		_, err = fw.Write(d)
+		if err != nil {
+			return err
+		}
+	}
+	err = w.Close()
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// creates zip file out with files stored under their base names. The zip
+// only depends on names and content of files: entries are sorted by name,
+// have the same time and are always compressed with the same level.
+// That way the same build has the same checksums
+func createReproducibleZip(files []string, out string) error {
+	var names []string
+	for _, path := range files {
+		names = append(names, filepath.Base(path))
+	}
+	return createReproducibleZipWithNames(files, names, out)
 }
 
 func createExeZipWithGoWithNameMust(dir, nameInZip string) {
 	zipPath := filepath.Join(dir, "SumatraPDF.zip")
-	os.Remove(zipPath) // called multiple times during upload
-	f, err := os.Create(zipPath)
-	panicIfErr(err)
-	defer f.Close()
-	zw := zip.NewWriter(f)
 	path := filepath.Join(dir, "SumatraPDF.exe")
-	addZipFileWithNameMust(zw, path, nameInZip)
-	err = zw.Close()
+	err := createReproducibleZipWithNames([]string{path}, []string{nameInZip}, zipPath)
+	panicIfErr(err)
+
+	if flgZstd {
+		createExeTarZstdMust(dir, nameInZip)
+	}
+}
+
+// zstd settings for .tar.zst. Compressing with one goroutine makes
+// the output depend only on the input
+const (
+	zstdLevel       = zstd.SpeedBestCompression
+	zstdConcurrency = 1
+)
+
+// writes d as a file nameInTar in a .tar compressed with zstd to dstPath.
+// The result only depends on d and nameInTar: tar header doesn't have
+// time or owner information and zstd always uses the same settings
+func createReproducibleTarZstd(d []byte, nameInTar string, dstPath string) error {
+	f, err := os.Create(dstPath)
+	if err != nil {
+		return err
+	}
+	zw, err := zstd.NewWriter(f, zstd.WithEncoderLevel(zstdLevel), zstd.WithEncoderConcurrency(zstdConcurrency))
+	if err != nil {
+		f.Close()
+		return err
+	}
+	tw := tar.NewWriter(zw)
+	hdr := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     nameInTar,
+		Mode:     0644,
+		Size:     int64(len(d)),
+		ModTime:  time.Unix(0, 0),
+		Format:   tar.FormatUSTAR,
+	}
+	err = tw.WriteHeader(hdr)
+	if err == nil {
+		_, err = tw.Write(d)
+	}
+	if err == nil {
+		err = tw.Close()
+	}
+	if err == nil {
+		err = zw.Close()
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(dstPath)
+		return err
+	}
+	return f.Close()
+}
+
+// creates SumatraPDF.tar.zst with SumatraPDF.exe named nameInTar
+func createExeTarZstdMust(dir, nameInTar string) {
+	srcPath := filepath.Join(dir, "SumatraPDF.exe")
+	d, err := ioutil.ReadFile(srcPath)
+	panicIfErr(err)
+	zstPath := filepath.Join(dir, "SumatraPDF.tar.zst")
+	err = createReproducibleTarZstd(d, nameInTar, zstPath)
 	panicIfErr(err)
 }
 
@@ -322,16 +467,11 @@ func createExeZipWithPigz(dir string) {
 
 func createPdbZipMust(dir string) {
 	path := filepath.Join(dir, "SumatraPDF.pdb.zip")
-	f, err := os.Create(path)
-	panicIfErr(err)
-	defer f.Close()
-	w := zip.NewWriter(f)
-
+	var files []string
 	for _, file := range pdbFiles {
-		addZipFileMust(w, filepath.Join(dir, file))
+		files = append(files, filepath.Join(dir, file))
 	}
-
-	err = w.Close()
+	err := createReproducibleZip(files, path)
 	panicIfErr(err)
 }
 
@@ -347,8 +487,18 @@ func createPdbLzsaMust(dir string) {
 }
 
 // manifest is build for pre-release builds and contains information about file sizes
-func createManifestMust() {
-	var lines []string
+// first line is "ver: ${ver}" so that version can be recovered from
+// manifest content, see extractVersionFromManifest()
+// second line is "sha1: ${sha1}" so that we can tell if a build
+// is from the same source as the previous one
+// third line is "date: ${date}", the day of the build, for sumatralatest.js
+// with -min-os-version, it's followed by "min-os-version: ${ver}" line for
+// update.txt (see manifestInfo)
+func createManifestMust(ver string) {
+	lines := []string{"ver: " + ver, "sha1: " + getGitSha1(), "date: " + time.Now().UTC().Format(buildDateFormat)}
+	if flgMinOSVersion != "" {
+		lines = append(lines, "min-os-version: "+flgMinOSVersion)
+	}
 	files := []string{
 		"SumatraPDF.exe",
 		"SumatraPDF.zip",
@@ -21,10 +21,11 @@ func buildDaily() {
 	build(rel64Dir, "Release", "x64")
 	nameInZip := fmt.Sprintf("SumatraPDF-prerel-%s-64.exe", ver)
 	createExeZipWithGoWithNameMust(rel64Dir, nameInZip)
-	createManifestMust()
+	createManifestMust(ver)
 
 	dstDir := filepath.Join("out", "final-daily")
 	prefix := fmt.Sprintf("SumatraPDF-prerel-%s", ver)
 	copyBuiltFiles(dstDir, rel64Dir, prefix+"-64")
-	copyBuiltManifest(dstDir, prefix)
+	copyBuiltManifest(dstDir, buildTypeDaily, ver)
+	writeBuildDescMust(dstDir, buildTypeDaily, ver)
 }
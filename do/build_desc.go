@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/kjk/u"
+)
+
+// name of file in final dir that describes what was built, written by
+// the build step. It's not uploaded
+const buildDescFileName = "build.json"
+
+// describes a build in final dir so that upload can verify it uploads
+// what was actually built (and not e.g. a build from a different checkout)
+type buildDesc struct {
+	BuildType string `json:"buildType"`
+	Ver       string `json:"version"`
+	Sha1      string `json:"sha1"`
+	// "32" and / or "64"
+	Archs []string `json:"archs"`
+}
+
+func isBuildDescFile(name string) bool {
+	return filepath.Base(name) == buildDescFileName
+}
+
+func (a buildArchs) names() []string {
+	var res []string
+	if a.has32 {
+		res = append(res, "32")
+	}
+	if a.has64 {
+		res = append(res, "64")
+	}
+	return res
+}
+
+// writes buildDescFileName to dstDir. Must be called after files
+// were copied to dstDir
+func writeBuildDescMust(dstDir string, buildType string, ver string) {
+	desc := &buildDesc{
+		BuildType: buildType,
+		Ver:       ver,
+		Sha1:      getGitSha1(),
+		Archs:     detectBuildArchsInDir(dstDir).names(),
+	}
+	d, err := json.MarshalIndent(desc, "", "  ")
+	must(err)
+	u.WriteFileMust(filepath.Join(dstDir, buildDescFileName), d)
+}
+
+// returns nil (and no error) if dir doesn't have buildDescFileName
+func loadBuildDesc(dir string) (*buildDesc, error) {
+	path := filepath.Join(dir, buildDescFileName)
+	d, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var desc buildDesc
+	err = json.Unmarshal(d, &desc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse '%s', err: %s", path, err)
+	}
+	return &desc, nil
+}
+
+// if dir has buildDescFileName, verifies that it's the build we're about
+// to upload as buildType: same build type, version, git sha1 and archs
+func verifyBuildDesc(dir string, buildType string) error {
+	desc, err := loadBuildDesc(dir)
+	if err != nil || desc == nil {
+		return err
+	}
+	path := filepath.Join(dir, buildDescFileName)
+	var diffs []string
+	check := func(what string, got string, exp string) {
+		if got != exp {
+			diffs = append(diffs, fmt.Sprintf("%s is '%s' but we're uploading '%s'", what, got, exp))
+		}
+	}
+	check("build type", desc.BuildType, buildType)
+	check("version", desc.Ver, getVerForBuildType(buildType))
+	check("sha1", desc.Sha1, getGitSha1())
+	archs := detectBuildArchsInDir(dir).names()
+	check("archs", strings.Join(desc.Archs, ","), strings.Join(archs, ","))
+	if len(diffs) > 0 {
+		return fmt.Errorf("'%s' doesn't match the build:\n  %s", path, strings.Join(diffs, "\n  "))
+	}
+	logf("Verified build in '%s' matches '%s'\n", dir, path)
+	return nil
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kjk/u"
+	"github.com/minio/minio-go/v6"
+)
+
+// how many most recent builds are in the feed
+const buildFeedMaxEntries = 20
+
+// only pre-release builds have a feed. Daily builds are too frequent
+func hasBuildFeed(buildType string) bool {
+	return buildType == buildTypePreRel
+}
+
+// it's outside of getRemoteDir() so that it's not seen as part of a build
+// (and deleted as a build with unparsable version)
+func getBuildFeedRemotePath(buildType string) string {
+	return remoteJoin(remoteRoot, buildType+"-feed.xml")
+}
+
+type atomLink struct {
+	Href   string `xml:"href,attr"`
+	Rel    string `xml:"rel,attr,omitempty"`
+	Type   string `xml:"type,attr,omitempty"`
+	Title  string `xml:"title,attr,omitempty"`
+	Length int64  `xml:"length,attr,omitempty"`
+}
+
+type atomText struct {
+	Type string `xml:"type,attr"`
+	Text string `xml:",chardata"`
+}
+
+type atomEntry struct {
+	Title   string     `xml:"title"`
+	ID      string     `xml:"id"`
+	Updated string     `xml:"updated"`
+	Links   []atomLink `xml:"link"`
+	Content atomText   `xml:"content"`
+}
+
+type atomFeed struct {
+	XMLName    xml.Name     `xml:"http://www.w3.org/2005/Atom feed"`
+	Title      string       `xml:"title"`
+	ID         string       `xml:"id"`
+	Updated    string       `xml:"updated"`
+	AuthorName string       `xml:"author>name"`
+	Links      []atomLink   `xml:"link"`
+	Entries    []*atomEntry `xml:"entry"`
+}
+
+// returns Atom feed with up to maxEntries most recent builds in byVer.
+// Date of a build is the date of its most recently modified file.
+// sha1s maps version to git sha1 of the build, if known
+func genBuildFeed(buildType string, byVer []*filesByVer, infos map[string]*minio.ObjectInfo, sha1s map[int]string, maxEntries int) ([]byte, error) {
+	feedURL := spacesURLBase + escapeURLPath(getBuildFeedRemotePath(buildType))
+	feed := &atomFeed{
+		Title:      fmt.Sprintf("SumatraPDF %s builds", buildType),
+		ID:         feedURL,
+		AuthorName: "SumatraPDF",
+		Links: []atomLink{
+			{Href: feedURL, Rel: "self", Type: "application/atom+xml"},
+		},
+	}
+	var feedUpdated time.Time
+	for _, v := range byVer {
+		// versions 0 and 1 are files with names we couldn't parse
+		if v.ver <= 1 {
+			continue
+		}
+		if len(feed.Entries) >= maxEntries {
+			break
+		}
+		ver := strconv.Itoa(v.ver)
+		e := &atomEntry{
+			Title: fmt.Sprintf("SumatraPDF %s %s", buildType, ver),
+			ID:    fmt.Sprintf("tag:sumatrapdfreader.org,2020:%s-%s", buildType, ver),
+		}
+		var updated time.Time
+		var desc []string
+		if sha1 := sha1s[v.ver]; sha1 != "" {
+			desc = append(desc, "sha1: "+sha1)
+		}
+		for _, remotePath := range v.files {
+			if oi := infos[remotePath]; oi != nil && oi.LastModified.After(updated) {
+				updated = oi.LastModified
+			}
+			name := path.Base(remotePath)
+			uri := spacesURLBase + escapeURLPath(remotePath)
+			if name == "notes.txt" {
+				e.Links = append(e.Links, atomLink{Href: uri, Rel: "alternate", Type: "text/plain"})
+				continue
+			}
+			if isBuildMetadataFile(remotePath) {
+				continue
+			}
+			var size int64
+			if oi := infos[remotePath]; oi != nil {
+				size = oi.Size
+			}
+			l := atomLink{
+				Href:   uri,
+				Rel:    "enclosure",
+				Type:   getUploadPolicy(remotePath).ContentType,
+				Title:  name,
+				Length: size,
+			}
+			e.Links = append(e.Links, l)
+			desc = append(desc, fmt.Sprintf("%s: %s", name, u.FmtSizeHuman(size)))
+		}
+		if updated.IsZero() {
+			return nil, fmt.Errorf("no modification time for files of version %s", ver)
+		}
+		e.Updated = updated.UTC().Format(time.RFC3339)
+		e.Content = atomText{Type: "text", Text: strings.Join(desc, "\n")}
+		if updated.After(feedUpdated) {
+			feedUpdated = updated
+		}
+		feed.Entries = append(feed.Entries, e)
+	}
+	if len(feed.Entries) == 0 {
+		return nil, fmt.Errorf("no builds of '%s' for the feed", buildType)
+	}
+	feed.Updated = feedUpdated.UTC().Format(time.RFC3339)
+	d, err := xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), d...), nil
+}
+
+// re-generates getBuildFeedRemotePath() from builds currently in storage.
+// Called after upload and after deleting old builds so that the feed
+// only links to builds that exist
+func minioUploadBuildFeed(c minioStorage, buildType string) error {
+	if !hasBuildFeed(buildType) {
+		return nil
+	}
+	byVer, infos := minioListBuildsMust(c, buildType)
+	sha1s := map[int]string{}
+	n := 0
+	for _, v := range byVer {
+		if v.ver <= 1 {
+			continue
+		}
+		if n >= buildFeedMaxEntries {
+			break
+		}
+		n++
+		for _, remotePath := range v.files {
+			if !isManifestFile(remotePath) {
+				continue
+			}
+			d, err := c.DownloadFileAsData(remotePath)
+			if err != nil {
+				return err
+			}
+			sha1s[v.ver] = getManifestValue(d, "sha1")
+		}
+	}
+	d, err := genBuildFeed(buildType, byVer, infos, sha1s, buildFeedMaxEntries)
+	if err != nil {
+		return err
+	}
+	return minioUploadVersionFile(c, getBuildFeedRemotePath(buildType), d)
+}
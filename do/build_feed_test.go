@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/minio/minio-go/v6"
+)
+
+func testFeedBuilds() ([]*filesByVer, map[string]*minio.ObjectInfo) {
+	dir := "software/sumatrapdf/prerel/"
+	var files []string
+	infos := map[string]*minio.ObjectInfo{}
+	add := func(name string, size int64, day int) {
+		remotePath := dir + name
+		files = append(files, remotePath)
+		infos[remotePath] = &minio.ObjectInfo{
+			Key:          remotePath,
+			Size:         size,
+			LastModified: time.Date(2020, 3, day, 10, 0, 0, 0, time.UTC),
+		}
+	}
+	for i, ver := range []string{"12300", "12301", "12302"} {
+		day := i + 1
+		add("SumatraPDF-prerel-"+ver+"-64.exe", 1000, day)
+		add("SumatraPDF-prerel-"+ver+"-64.exe.sha256", 64, day)
+		add("SumatraPDF-prerel-"+ver+"-64.zip", 500, day)
+		add("SumatraPDF-prerel-"+ver+"-manifest.txt", 100, day)
+		add(ver+"/notes.txt", 10, day)
+	}
+	// not a build, must be skipped
+	add("foo.exe", 1, 10)
+	return groupFilesByVersion(files), infos
+}
+
+func TestGenBuildFeed(t *testing.T) {
+	byVer, infos := testFeedBuilds()
+	sha1s := map[int]string{12302: "0123456789abcdef0123456789abcdef01234567"}
+	d, err := genBuildFeed(buildTypePreRel, byVer, infos, sha1s, 2)
+	must(err)
+	if !strings.HasPrefix(string(d), xml.Header) {
+		t.Errorf("feed doesn't start with xml header")
+	}
+	var feed atomFeed
+	must(xml.Unmarshal(d, &feed))
+	if feed.Updated != "2020-03-03T10:00:00Z" {
+		t.Errorf("feed updated is '%s'", feed.Updated)
+	}
+	if len(feed.Links) != 1 || feed.Links[0].Href != spacesURLBase+"software/sumatrapdf/prerel-feed.xml" {
+		t.Errorf("unexpected feed links: %v", feed.Links)
+	}
+	// most recent first and no more than maxEntries
+	if len(feed.Entries) != 2 {
+		t.Fatalf("feed has %d entries, expected 2", len(feed.Entries))
+	}
+	expTitles := []string{"SumatraPDF prerel 12302", "SumatraPDF prerel 12301"}
+	for i, e := range feed.Entries {
+		if e.Title != expTitles[i] {
+			t.Errorf("entry %d is '%s', expected '%s'", i, e.Title, expTitles[i])
+		}
+	}
+
+	e := feed.Entries[0]
+	if e.ID != "tag:sumatrapdfreader.org,2020:prerel-12302" || e.Updated != "2020-03-03T10:00:00Z" {
+		t.Errorf("unexpected entry id '%s' or updated '%s'", e.ID, e.Updated)
+	}
+	if !strings.Contains(e.Content.Text, "sha1: "+sha1s[12302]) {
+		t.Errorf("entry content doesn't have sha1: '%s'", e.Content.Text)
+	}
+	if strings.Contains(feed.Entries[1].Content.Text, "sha1:") {
+		t.Errorf("entry without known sha1 has sha1: '%s'", feed.Entries[1].Content.Text)
+	}
+	// checksums and manifest are not linked
+	links := map[string]atomLink{}
+	for _, l := range e.Links {
+		links[l.Href] = l
+	}
+	if len(links) != 3 {
+		t.Errorf("entry has %d links, expected 3: %v", len(links), e.Links)
+	}
+	exe := links[spacesURLBase+"software/sumatrapdf/prerel/SumatraPDF-prerel-12302-64.exe"]
+	if exe.Rel != "enclosure" || exe.Length != 1000 || exe.Type != "application/octet-stream" || exe.Title != "SumatraPDF-prerel-12302-64.exe" {
+		t.Errorf("unexpected link of .exe: %+v", exe)
+	}
+	notes := links[spacesURLBase+"software/sumatrapdf/prerel/12302/notes.txt"]
+	if notes.Rel != "alternate" || notes.Type != "text/plain" {
+		t.Errorf("unexpected link of notes: %+v", notes)
+	}
+}
+
+func TestGenBuildFeedErrors(t *testing.T) {
+	_, err := genBuildFeed(buildTypePreRel, nil, nil, nil, 10)
+	if err == nil {
+		t.Errorf("expected an error for no builds")
+	}
+	byVer, _ := testFeedBuilds()
+	_, err = genBuildFeed(buildTypePreRel, byVer, map[string]*minio.ObjectInfo{}, nil, 10)
+	if err == nil {
+		t.Errorf("expected an error for builds without modification time")
+	}
+}
+
+// uploading a build updates the feed with sha1 from the manifest
+func TestMinioUploadBuildFeed(t *testing.T) {
+	setTestGlobals(t)
+	c := newFakeStorage()
+	minioUploadBuild(c, buildTypePreRel, writeTestBuild(t, buildTypePreRel), nil)
+	d, err := c.DownloadFileAsData(getBuildFeedRemotePath(buildTypePreRel))
+	must(err)
+	var feed atomFeed
+	must(xml.Unmarshal(d, &feed))
+	if len(feed.Entries) != 1 || feed.Entries[0].Title != "SumatraPDF prerel 12345" {
+		t.Fatalf("unexpected feed entries: %v", feed.Entries)
+	}
+	if !strings.Contains(feed.Entries[0].Content.Text, "sha1: "+getGitSha1()) {
+		t.Errorf("feed entry doesn't have sha1: '%s'", feed.Entries[0].Content.Text)
+	}
+	// daily builds don't have a feed
+	if hasBuildFeed(buildTypeDaily) {
+		t.Errorf("daily builds should not have a feed")
+	}
+}
@@ -32,17 +32,19 @@ func buildPreRelease() {
 	nameInZip = fmt.Sprintf("RAMicroPDFViewer-prerel-%s.exe", ver)
 	createExeZipWithGoWithNameMust(rel64RaDir, nameInZip)
 
-	createManifestMust()
+	createManifestMust(ver)
 
 	dstDir := filepath.Join("out", "final-prerel")
 	prefix := fmt.Sprintf("SumatraPDF-prerel-%s", ver)
 	copyBuiltFiles(dstDir, rel32Dir, prefix)
 	copyBuiltFiles(dstDir, rel64Dir, prefix+"-64")
-	copyBuiltManifest(dstDir, prefix)
+	copyBuiltManifest(dstDir, buildTypePreRel, ver)
+	writeBuildDescMust(dstDir, buildTypePreRel, ver)
 
 	// note: manifest won't be for the right files but we don't care
 	dstDir = filepath.Join("out", "final-ramicro")
 	prefix = fmt.Sprintf("RAMicroPDFViewer-prerel-%s", ver)
 	copyBuiltFiles(dstDir, rel64RaDir, prefix+"-64")
-	copyBuiltManifest(dstDir, prefix)
+	copyBuiltManifest(dstDir, buildTypeRaMicro, ver)
+	writeBuildDescMust(dstDir, buildTypeRaMicro, ver)
 }
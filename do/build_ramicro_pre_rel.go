@@ -24,11 +24,11 @@ func buildRaMicroPreRelease() {
 	nameInZip := fmt.Sprintf("RAMicroPDFViewer-prerel-%s.exe", ver)
 	createExeZipWithGoWithNameMust(rel64RaDir, nameInZip)
 
-	//createManifestMust()
+	//createManifestMust(ver)
 
 	// note: manifest won't be for the right files but we don't care
 	dstDir := filepath.Join("out", "final-ramicro")
 	prefix := fmt.Sprintf("RAMicroPDFViewer-prerel-%s", ver)
 	copyBuiltFiles(dstDir, rel64RaDir, prefix+"-64")
-	//copyBuiltManifest(dstDir, prefix)
+	//copyBuiltManifest(dstDir, buildTypeRaMicro, ver)
 }
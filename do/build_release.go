@@ -31,13 +31,14 @@ func buildRelease() {
 	nameInZip = fmt.Sprintf("SumatraPDF-%s-64.exe", ver)
 	createExeZipWithGoWithNameMust(rel64Dir, nameInZip)
 
-	createManifestMust()
+	createManifestMust(ver)
 
 	dstDir := filepath.Join("out", "final-rel")
 	prefix := fmt.Sprintf("SumatraPDF-%s", ver)
 	copyBuiltFiles(dstDir, rel32Dir, prefix)
 	copyBuiltFiles(dstDir, rel64Dir, prefix+"-64")
-	copyBuiltManifest(dstDir, prefix)
+	copyBuiltManifest(dstDir, buildTypeRel, ver)
+	writeBuildDescMust(dstDir, buildTypeRel, ver)
 }
 
 // a faster release build for testing that only does 64-bit installer
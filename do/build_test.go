@@ -0,0 +1,58 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestCreateReproducibleTarZstd(t *testing.T) {
+	dir := t.TempDir()
+	d := bytes.Repeat([]byte("SumatraPDF.exe content "), 10000)
+	var results [][]byte
+	for i := 0; i < 3; i++ {
+		path := filepath.Join(dir, "SumatraPDF.tar.zst")
+		err := createReproducibleTarZstd(d, "SumatraPDF-prerel-12345-64.exe", path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		results = append(results, res)
+	}
+	for i := 1; i < len(results); i++ {
+		if !bytes.Equal(results[0], results[i]) {
+			t.Fatalf("run %d created different .tar.zst than run 0", i)
+		}
+	}
+	if len(results[0]) >= len(d) {
+		t.Errorf(".tar.zst is %d bytes, not smaller than %d bytes of input", len(results[0]), len(d))
+	}
+
+	zr, err := zstd.NewReader(bytes.NewReader(results[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+	tr := tar.NewReader(zr)
+	hdr, err := tr.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hdr.Name != "SumatraPDF-prerel-12345-64.exe" {
+		t.Errorf("name in tar is '%s'", hdr.Name)
+	}
+	got, err := ioutil.ReadAll(tr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, d) {
+		t.Errorf("content in tar differs from input")
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"path/filepath"
+)
+
+const (
+	buildTypeDaily   = "daily"
+	buildTypePreRel  = "prerel"
+	buildTypeRel     = "rel"
+	buildTypeRaMicro = "ramicro"
+)
+
+// builds to retain
+const nBuildsToRetainPreRel = 16
+const nBuildsToRetainDaily = 64
+const nBuildsToRetaininMicro = 32
+
+// describes a build type (channel). Adding a channel is adding
+// an entry to buildTypes
+type buildTypeInfo struct {
+	// prefix of names of uploaded files, without the version
+	appName string
+	// directory under remoteRoot where the builds are uploaded
+	remoteDir string
+	// directory under out/ with files to upload
+	finalDir string
+	// names of version info files under remoteRoot: .js file used by
+	// the website, ${ver} in *-latest.txt and the file read by the updater
+	versionInfoFiles []string
+	// returns version used in uploaded file names
+	getVer func() string
+	// default number of builds kept when deleting old builds
+	nRetain int
+}
+
+// pre-release, daily and ramicro builds are named with linear build
+// number like "12223", release builds with program version like "3.2"
+func getReleaseVer() string {
+	return sumatraVersion
+}
+
+var buildTypes = map[string]*buildTypeInfo{
+	buildTypePreRel: {
+		appName:          "SumatraPDF-prerel",
+		remoteDir:        "prerel",
+		finalDir:         "final-prerel",
+		versionInfoFiles: []string{"sumatralatest.js", "sumpdf-prerelease-latest.txt", "sumpdf-prerelease-update.txt"},
+		getVer:           getPreReleaseVer,
+		nRetain:          nBuildsToRetainPreRel,
+	},
+	buildTypeDaily: {
+		appName:          "SumatraPDF-prerel",
+		remoteDir:        "daily",
+		finalDir:         "final-daily",
+		versionInfoFiles: []string{"sumadaily.js", "sumpdf-daily-latest.txt", "sumpdf-daily-update.txt"},
+		getVer:           getPreReleaseVer,
+		nRetain:          nBuildsToRetainDaily,
+	},
+	buildTypeRaMicro: {
+		// must match name in buildRaMicroPreRelease
+		appName:          "RAMicroPDFViewer-prerel",
+		remoteDir:        "ramicro",
+		finalDir:         "final-ramicro",
+		versionInfoFiles: []string{"ramicrolatest.js", "ramicro-daily-latest.txt", "ramicro-daily-update.txt"},
+		getVer:           getPreReleaseVer,
+		nRetain:          nBuildsToRetaininMicro,
+	},
+	buildTypeRel: {
+		appName:          "SumatraPDF",
+		remoteDir:        "rel",
+		finalDir:         "final-rel",
+		versionInfoFiles: []string{"sumarellatest.js", "release-latest.txt", "release-update.txt"},
+		getVer:           getReleaseVer,
+		// release builds are never deleted
+	},
+}
+
+func isValidBuildType(buildType string) bool {
+	return buildTypes[buildType] != nil
+}
+
+func getBuildTypeInfo(buildType string) *buildTypeInfo {
+	info := buildTypes[buildType]
+	panicIf(info == nil, "invalid build type: '%s'", buildType)
+	return info
+}
+
+func getRemotePaths(buildType string) []string {
+	var res []string
+	for _, name := range getBuildTypeInfo(buildType).versionInfoFiles {
+		res = append(res, remoteJoin(remoteRoot, name))
+	}
+	return res
+}
+
+func getRemoteDir(buildType string) string {
+	// trailing "/" so that it can be used as a prefix for listing
+	return remoteJoin(remoteRoot, getBuildTypeInfo(buildType).remoteDir) + "/"
+}
+
+// prefix of names of uploaded files, without the version
+func getAppNameForBuildType(buildType string) string {
+	return getBuildTypeInfo(buildType).appName
+}
+
+func getFinalDirForBuildType(buildType string) string {
+	return filepath.Join("out", getBuildTypeInfo(buildType).finalDir)
+}
+
+// this returns version to be used in uploaded file names
+func getVerForBuildType(buildType string) string {
+	return getBuildTypeInfo(buildType).getVer()
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func checkRemotePath(t *testing.T, what string, s string) {
+	if strings.Contains(s, "//") || strings.HasPrefix(s, "/") {
+		t.Errorf("%s '%s' has a double or leading slash", what, s)
+	}
+	if !strings.HasPrefix(s, remoteJoin(remoteRoot)+"/") {
+		t.Errorf("%s '%s' is not under '%s'", what, s, remoteRoot)
+	}
+}
+
+func TestRemotePathsOfBuildTypes(t *testing.T) {
+	prevRemoteRoot := remoteRoot
+	defer func() { remoteRoot = prevRemoteRoot }()
+	prevVer := preReleaseVerCached
+	preReleaseVerCached = "12345"
+	defer func() { preReleaseVerCached = prevVer }()
+	prevSumatraVer := sumatraVersion
+	sumatraVersion = "3.2"
+	defer func() { sumatraVersion = prevSumatraVer }()
+
+	// -remote-root can be given with or without trailing slash
+	for _, root := range []string{"software/sumatrapdf/", "staging/software/sumatrapdf", "/staging/"} {
+		remoteRoot = root
+		for buildType, info := range buildTypes {
+			dir := getRemoteDir(buildType)
+			checkRemotePath(t, "remote dir", dir)
+			expDir := remoteJoin(root, info.remoteDir) + "/"
+			if dir != expDir {
+				t.Errorf("getRemoteDir('%s') = '%s', expected '%s'", buildType, dir, expDir)
+			}
+
+			paths := getRemotePaths(buildType)
+			if len(paths) != len(info.versionInfoFiles) {
+				t.Fatalf("getRemotePaths('%s') returned %d paths, expected %d", buildType, len(paths), len(info.versionInfoFiles))
+			}
+			for i, p := range paths {
+				checkRemotePath(t, "version info file", p)
+				// version info files are directly under remote root,
+				// not in the directory of the build type
+				exp := remoteJoin(root) + "/" + info.versionInfoFiles[i]
+				if p != exp {
+					t.Errorf("getRemotePaths('%s')[%d] = '%s', expected '%s'", buildType, i, p, exp)
+				}
+			}
+
+			ver := getVerForBuildType(buildType)
+			manifestPath := remoteJoin(dir, manifestName(buildType, ver))
+			checkRemotePath(t, "manifest", manifestPath)
+			expManifest := dir + info.appName + "-" + ver + "-manifest.txt"
+			if manifestPath != expManifest {
+				t.Errorf("manifest of '%s' is '%s', expected '%s'", buildType, manifestPath, expManifest)
+			}
+			checkRemotePath(t, "notes", getNotesRemotePath(buildType, ver))
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/goamz/goamz/aws"
+)
+
+// operations that -check-config knows about
+const (
+	configOpUpload = "upload"
+	configOpTrans  = "trans"
+)
+
+// returns a problem with env variable name or "" if it's set and
+// looks valid
+func checkEnvVar(name string) string {
+	v, ok := os.LookupEnv(name)
+	if !ok || v == "" {
+		return fmt.Sprintf("%s env variable is not set", name)
+	}
+	// usually a result of copy & paste into ci secrets
+	if strings.TrimSpace(v) != v {
+		return fmt.Sprintf("%s env variable has leading or trailing whitespace", name)
+	}
+	return ""
+}
+
+// returns problems with configuration needed to upload builds to
+// spaces and s3, including whether the buckets can be accessed
+func checkUploadConfig() []string {
+	var res []string
+	add := func(problem string) {
+		if problem != "" {
+			res = append(res, problem)
+		}
+	}
+
+	nBefore := len(res)
+	add(checkEnvVar("SPACES_KEY"))
+	add(checkEnvVar("SPACES_SECRET"))
+	if len(res) == nBefore {
+		mc := newMinioClient()
+		client, err := mc.GetClient()
+		var exists bool
+		if err == nil {
+			exists, err = client.BucketExists(mc.Bucket)
+		}
+		if err != nil {
+			add(fmt.Sprintf("spaces bucket '%s' is not reachable, err: %s", mc.Bucket, err))
+		} else if !exists {
+			add(fmt.Sprintf("spaces bucket '%s' doesn't exist", mc.Bucket))
+		}
+	}
+
+	nBefore = len(res)
+	add(checkEnvVar("AWS_ACCESS"))
+	add(checkEnvVar("AWS_SECRET"))
+	if region := os.Getenv("AWS_REGION"); region != "" && region != aws.USEast.Name {
+		if !isValidS3RegionName(region) {
+			add(fmt.Sprintf("AWS_REGION env variable is '%s' which is not a valid s3 region name", region))
+		}
+	}
+	if len(res) == nBefore {
+		c := newS3Client()
+		if c.Access == c.Secret {
+			add("AWS_ACCESS and AWS_SECRET env variables are the same")
+		} else if _, err := c.GetBucket().List("", "", "", 1); err != nil {
+			add(fmt.Sprintf("s3 bucket '%s' is not reachable, err: %s", c.Bucket, err))
+		}
+	}
+	return res
+}
+
+// returns problems with configuration needed to upload strings to
+// apptranslator.org
+func checkTransConfig() []string {
+	if problem := checkEnvVar("TRANS_UPLOAD_SECRET"); problem != "" {
+		return []string{problem}
+	}
+	return nil
+}
+
+// checks that env variables and storage needed for operations (comma
+// separated, "upload", "trans" or "all") are configured so that we
+// don't find out about a missing secret in the middle of a release.
+// Reports all problems and returns false if there were any
+func checkConfig(ops string) bool {
+	var problems []string
+	for _, op := range strings.Split(ops, ",") {
+		op = strings.TrimSpace(op)
+		switch op {
+		case configOpUpload:
+			problems = append(problems, checkUploadConfig()...)
+		case configOpTrans:
+			problems = append(problems, checkTransConfig()...)
+		case "all":
+			problems = append(problems, checkUploadConfig()...)
+			problems = append(problems, checkTransConfig()...)
+		default:
+			problems = append(problems, fmt.Sprintf("unknown operation '%s', must be %s, %s or all", op, configOpUpload, configOpTrans))
+		}
+	}
+	if len(problems) == 0 {
+		fmt.Printf("Configuration for '%s' is ok\n", ops)
+		return true
+	}
+	for _, p := range problems {
+		fmt.Printf("FAIL: %s\n", p)
+	}
+	fmt.Printf("%d configuration problems for '%s'\n", len(problems), ops)
+	return false
+}
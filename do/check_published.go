@@ -0,0 +1,386 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// matches lines like: var sumLatestVer = 12345;
+var jsVarRx = regexp.MustCompile(`^var\s+([A-Za-z_][A-Za-z0-9_]*)\s*=\s*(.+);$`)
+
+// parses sumatralatest.js (and similar) into a map of variable name to
+// value. String values are unquoted. Returns an error if there's a line
+// that isn't a simple variable assignment
+func parseLatestJs(s string) (map[string]string, error) {
+	res := map[string]string{}
+	for i, l := range toTrimmedLines([]byte(s)) {
+		if l == "" {
+			continue
+		}
+		m := jsVarRx.FindStringSubmatch(l)
+		if m == nil {
+			return nil, fmt.Errorf("line %d: '%s' is not a variable assignment", i+1, l)
+		}
+		v := m[2]
+		if strings.HasPrefix(v, `"`) {
+			uq, err := strconv.Unquote(v)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: bad string '%s': %s", i+1, v, err)
+			}
+			v = uq
+		} else if _, err := strconv.Atoi(v); err != nil {
+			return nil, fmt.Errorf("line %d: '%s' is neither a string nor a number", i+1, v)
+		}
+		res[m[1]] = v
+	}
+	return res, nil
+}
+
+// UpdateInfo is the content of *-update.txt read by the updater, see
+// getVersionFilesForLatestInfoForVer()
+type UpdateInfo struct {
+	Latest         string
+	Zstd64         string
+	MinOSVersion   string
+	MinOSVersion32 string
+	MinOSVersion64 string
+	// keys we don't know about, for forward compatibility
+	Other map[string]string
+}
+
+const updateTxtSection = "[SumatraPDF]"
+
+// parses *-update.txt which is "[SumatraPDF]" line followed by
+// "${key} ${value}" lines. "${key}: ${value}" is also accepted.
+// Unknown keys are put in Other. Latest is required
+func parseUpdateTxt(d []byte) (*UpdateInfo, error) {
+	res := &UpdateInfo{Other: map[string]string{}}
+	known := map[string]*string{
+		"Latest":         &res.Latest,
+		"Zstd64":         &res.Zstd64,
+		"MinOSVersion":   &res.MinOSVersion,
+		"MinOSVersion32": &res.MinOSVersion32,
+		"MinOSVersion64": &res.MinOSVersion64,
+	}
+	seen := map[string]bool{}
+	inSection := false
+	for i, l := range toTrimmedLines(d) {
+		if l == "" {
+			continue
+		}
+		if !inSection {
+			if l != updateTxtSection {
+				return nil, fmt.Errorf("line %d: expected '%s', got '%s'", i+1, updateTxtSection, l)
+			}
+			inSection = true
+			continue
+		}
+		parts := strings.SplitN(l, " ", 2)
+		key := strings.TrimSuffix(parts[0], ":")
+		if len(parts) != 2 || key == "" || strings.TrimSpace(parts[1]) == "" {
+			return nil, fmt.Errorf("line %d: '%s' is not '${key} ${value}'", i+1, l)
+		}
+		if seen[key] {
+			return nil, fmt.Errorf("line %d: duplicate key '%s'", i+1, key)
+		}
+		seen[key] = true
+		v := strings.TrimSpace(parts[1])
+		if p := known[key]; p != nil {
+			*p = v
+		} else {
+			res.Other[key] = v
+		}
+	}
+	if !inSection {
+		return nil, fmt.Errorf("no '%s' section", updateTxtSection)
+	}
+	if res.Latest == "" {
+		return nil, fmt.Errorf("no Latest version")
+	}
+	return res, nil
+}
+
+// returns the version advertised by a version info file (see
+// getRemotePaths()) with content d
+func getAdvertisedVersion(pointerPath string, d []byte) (string, error) {
+	s := string(d)
+	var ver string
+	switch {
+	case strings.HasSuffix(pointerPath, ".js"):
+		vars, err := parseLatestJs(s)
+		if err != nil {
+			return "", err
+		}
+		ver = vars["sumLatestVer"]
+	case strings.HasSuffix(pointerPath, "-update.txt"):
+		info, err := parseUpdateTxt(d)
+		if err != nil {
+			return "", err
+		}
+		ver = info.Latest
+	default:
+		ver = strings.TrimSpace(s)
+	}
+	if ver == "" {
+		return "", fmt.Errorf("no version in '%s'", pointerPath)
+	}
+	return ver, nil
+}
+
+// returns an error if version info files, whose content is in
+// contents (same order as pointerPaths), don't all advertise the same
+// version e.g. because regenerating them partially failed
+func verifyAdvertisedVersionsAgree(pointerPaths []string, contents [][]byte) error {
+	var vers []string
+	firstVer := ""
+	agree := true
+	for i, pointerPath := range pointerPaths {
+		ver, err := getAdvertisedVersion(pointerPath, contents[i])
+		if err != nil {
+			return err
+		}
+		vers = append(vers, fmt.Sprintf("'%s' in '%s'", ver, pointerPath))
+		if i == 0 {
+			firstVer = ver
+		} else if ver != firstVer {
+			agree = false
+		}
+	}
+	if !agree {
+		return fmt.Errorf("versions don't agree: %s", strings.Join(vers, ", "))
+	}
+	return nil
+}
+
+// names of variables in sumatralatest.js with urls of files that must exist.
+// Urls are only there for architectures that the build has
+func getLatestJsURLVars(vars map[string]string) []string {
+	names := []string{
+		"sumLatestExe", "sumLatestExeZip", "sumLatestInstaller", "sumLatestExeZst",
+		"sumLatestExe64", "sumLatestExeZip64", "sumLatestInstaller64", "sumLatestExeZst64",
+	}
+	var res []string
+	for _, name := range names {
+		if _, ok := vars[name]; ok {
+			res = append(res, name)
+		}
+	}
+	return res
+}
+
+func httpHeadOk(uri string) error {
+	rsp, err := getHTTPClient().Head(uri)
+	if err != nil {
+		return err
+	}
+	rsp.Body.Close()
+	if rsp.StatusCode != http.StatusOK {
+		return fmt.Errorf("HEAD returned %s", rsp.Status)
+	}
+	return nil
+}
+
+// checks that the published "latest" files of buildType are consistent
+// with each other and with the builds in storage and that all files they
+// point to can be downloaded. Prints a report and returns false if any
+// check failed
+func checkPublished(c minioStorage, buildType string) bool {
+	// version info files for release builds are not created by us
+	panicIf(!isValidBuildType(buildType) || buildType == buildTypeRel, "invalid build type: '%s'", buildType)
+	nFailed := 0
+	check := func(what string, err error) {
+		if err != nil {
+			nFailed++
+			fmt.Printf("FAIL: %s: %s\n", what, err)
+			return
+		}
+		fmt.Printf("ok:   %s\n", what)
+	}
+	download := func(remotePath string) string {
+		d, err := c.DownloadFileAsData(remotePath)
+		check("download "+remotePath, err)
+		return string(d)
+	}
+
+	remotePaths := getRemotePaths(buildType)
+	js := download(remotePaths[0])
+	latestTxt := download(remotePaths[1])
+	latestVer := strings.TrimSpace(latestTxt)
+	updateTxt := download(remotePaths[2])
+
+	contents := [][]byte{[]byte(js), []byte(latestTxt), []byte(updateTxt)}
+	err := verifyAdvertisedVersionsAgree(remotePaths, contents)
+	check(".js, latest.txt and update.txt versions agree", err)
+
+	vars, err := parseLatestJs(js)
+	check("parse "+remotePaths[0], err)
+	if err == nil {
+		urlVars := getLatestJsURLVars(vars)
+		if len(urlVars) == 0 {
+			check(".js has download urls", fmt.Errorf("no urls in '%s'", remotePaths[0]))
+		}
+		for _, name := range urlVars {
+			uri := vars[name]
+			check(uri, httpHeadOk(absoluteURL(uri)))
+		}
+	}
+
+	remoteDir := getRemoteDir(buildType)
+	err = minioCheckCaseCollisions(c, remoteDir)
+	check("no keys under '"+remoteDir+"' differ only by case", err)
+
+	newestVer, err := minioLatestVersion(c, buildType)
+	if err == nil && strconv.Itoa(newestVer) != latestVer {
+		err = fmt.Errorf("newest build in storage is %d but '%s' is '%s'", newestVer, remotePaths[1], latestVer)
+	}
+	check("latest.txt is the newest build in storage", err)
+
+	if nFailed > 0 {
+		fmt.Printf("%d checks of published '%s' build failed\n", nFailed, buildType)
+		return false
+	}
+	fmt.Printf("Published '%s' build is ok\n", buildType)
+	return true
+}
+
+// returns groups of keys that differ only by case. Some clients
+// (e.g. on Windows) don't distinguish between them
+func findCaseCollisions(keys []string) [][]string {
+	byLower := map[string][]string{}
+	var lowerKeys []string
+	for _, key := range keys {
+		lower := strings.ToLower(key)
+		if _, ok := byLower[lower]; !ok {
+			lowerKeys = append(lowerKeys, lower)
+		}
+		byLower[lower] = append(byLower[lower], key)
+	}
+	var res [][]string
+	for _, lower := range lowerKeys {
+		if a := byLower[lower]; len(a) > 1 {
+			res = append(res, a)
+		}
+	}
+	return res
+}
+
+// returns an error listing keys under prefix that differ only by case
+func minioCheckCaseCollisions(c minioStorage, prefix string) error {
+	files, err := c.ListRemoteFiles(prefix)
+	if err != nil {
+		return err
+	}
+	var keys []string
+	for _, f := range files {
+		keys = append(keys, f.Key)
+	}
+	collisions := findCaseCollisions(keys)
+	if len(collisions) == 0 {
+		return nil
+	}
+	var a []string
+	for _, keys := range collisions {
+		a = append(a, strings.Join(keys, " and "))
+	}
+	return fmt.Errorf("%d groups of keys differ only by case: %s", len(collisions), strings.Join(a, ", "))
+}
+
+// reverse of getDownloadHost() + file name. Also accepts urls converted
+// with urlForMode()
+func remotePathFromURL(uri string) (string, error) {
+	uri = absoluteURL(uri)
+	if !strings.HasPrefix(uri, spacesURLBase) {
+		return "", fmt.Errorf("'%s' is not a spaces url", uri)
+	}
+	return url.PathUnescape(strings.TrimPrefix(uri, spacesURLBase))
+}
+
+// returns remote paths of files referenced by a given version info file
+// (see getRemotePaths())
+func getReferencedRemotePaths(buildType string, pointerPath string, d []byte) ([]string, error) {
+	s := string(d)
+	if strings.HasSuffix(pointerPath, ".js") {
+		vars, err := parseLatestJs(s)
+		if err != nil {
+			return nil, err
+		}
+		urlVars := getLatestJsURLVars(vars)
+		if len(urlVars) == 0 {
+			return nil, fmt.Errorf("no download urls")
+		}
+		var res []string
+		for _, name := range urlVars {
+			remotePath, err := remotePathFromURL(vars[name])
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, remotePath)
+		}
+		return res, nil
+	}
+
+	ver, err := getAdvertisedVersion(pointerPath, d)
+	if err != nil {
+		return nil, err
+	}
+	res := getLatestArtifactRemotePaths(buildType, ver)
+	if strings.HasSuffix(pointerPath, "-update.txt") {
+		info, err := parseUpdateTxt(d)
+		if err != nil {
+			return nil, err
+		}
+		if info.Zstd64 != "" {
+			remotePath, err := remotePathFromURL(info.Zstd64)
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, remotePath)
+		}
+	}
+	return res, nil
+}
+
+// checks that all files referenced by version info files of pre-release
+// and daily builds exist. Pre-release upload also updates daily version
+// info, so retention of one can break the other.
+// Prints missing files grouped by version info file and returns false
+// if there were any
+func auditVersionInfo(c minioStorage) bool {
+	nProblems := 0
+	for _, buildType := range []string{buildTypePreRel, buildTypeDaily} {
+		for _, pointerPath := range getRemotePaths(buildType) {
+			var problems []string
+			d, err := c.DownloadFileAsData(pointerPath)
+			var remotePaths []string
+			if err == nil {
+				remotePaths, err = getReferencedRemotePaths(buildType, pointerPath, d)
+			}
+			if err != nil {
+				problems = append(problems, err.Error())
+			}
+			for _, remotePath := range remotePaths {
+				if !minioExists(c, remotePath) {
+					problems = append(problems, fmt.Sprintf("'%s' doesn't exist", remotePath))
+				}
+			}
+			if len(problems) == 0 {
+				fmt.Printf("ok:   %s (%d files)\n", pointerPath, len(remotePaths))
+				continue
+			}
+			nProblems += len(problems)
+			fmt.Printf("FAIL: %s\n", pointerPath)
+			for _, p := range problems {
+				fmt.Printf("  %s\n", p)
+			}
+		}
+	}
+	if nProblems > 0 {
+		fmt.Printf("%d dangling references in version info files\n", nProblems)
+		return false
+	}
+	return true
+}
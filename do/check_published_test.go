@@ -0,0 +1,107 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFindCaseCollisions(t *testing.T) {
+	tests := []struct {
+		keys []string
+		exp  [][]string
+	}{
+		{nil, nil},
+		{[]string{"a.exe", "b.exe"}, nil},
+		{[]string{"a.exe", "a.exe"}, [][]string{{"a.exe", "a.exe"}}},
+		{
+			[]string{"prerel/SumatraPDF-prerel-64.exe", "prerel/sumatrapdf-prerel-64.exe", "prerel/SumatraPDF-prerel.exe"},
+			[][]string{{"prerel/SumatraPDF-prerel-64.exe", "prerel/sumatrapdf-prerel-64.exe"}},
+		},
+		// groups are in order of the first key in each group
+		{
+			[]string{"B.zip", "a.zip", "b.zip", "A.ZIP", "c.zip"},
+			[][]string{{"B.zip", "b.zip"}, {"a.zip", "A.ZIP"}},
+		},
+	}
+	for _, test := range tests {
+		got := findCaseCollisions(test.keys)
+		if !reflect.DeepEqual(got, test.exp) {
+			t.Errorf("findCaseCollisions(%v) = %v, expected %v", test.keys, got, test.exp)
+		}
+	}
+}
+
+func TestMinioCheckCaseCollisions(t *testing.T) {
+	c := newFakeStorage()
+	c.put("software/sumatrapdf/prerel/SumatraPDF-prerel-1.exe", []byte("a"))
+	c.put("software/sumatrapdf/prerel/SumatraPDF-prerel-2.exe", []byte("b"))
+	if err := minioCheckCaseCollisions(c, "software/sumatrapdf/prerel/"); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	c.put("software/sumatrapdf/prerel/sumatrapdf-prerel-2.exe", []byte("b"))
+	if err := minioCheckCaseCollisions(c, "software/sumatrapdf/prerel/"); err == nil {
+		t.Errorf("expected an error for keys that differ only by case")
+	}
+}
+
+// what genUpdateTxt() writes must parse back to the same values
+func TestParseUpdateTxtRoundTrip(t *testing.T) {
+	prevZstd := flgZstd
+	defer func() { flgZstd = prevZstd }()
+
+	both := buildArchs{has32: true, has64: true}
+	tests := []struct {
+		zstd  bool
+		minOS string
+		archs buildArchs
+		exp   UpdateInfo
+	}{
+		{false, "", both, UpdateInfo{Latest: "12345"}},
+		{true, "", both, UpdateInfo{Latest: "12345", Zstd64: getZstdDownloadURL(buildTypePreRel, "12345")}},
+		{true, "", buildArchs{has32: true}, UpdateInfo{Latest: "12345"}},
+		{false, "6.1", both, UpdateInfo{Latest: "12345", MinOSVersion: "6.1"}},
+		{false, "32=6.1,64=10.0", both, UpdateInfo{Latest: "12345", MinOSVersion32: "6.1", MinOSVersion64: "10.0"}},
+		{false, "32=6.1,64=10.0", buildArchs{has64: true}, UpdateInfo{Latest: "12345", MinOSVersion64: "10.0"}},
+	}
+	for _, test := range tests {
+		flgZstd = test.zstd
+		s := genUpdateTxt(buildTypePreRel, "12345", test.minOS, test.archs)
+		got, err := parseUpdateTxt([]byte(s))
+		if err != nil {
+			t.Errorf("parseUpdateTxt(%q) failed: %s", s, err)
+			continue
+		}
+		exp := test.exp
+		exp.Other = map[string]string{}
+		if !reflect.DeepEqual(*got, exp) {
+			t.Errorf("parseUpdateTxt(%q) = %+v, expected %+v", s, *got, exp)
+		}
+	}
+}
+
+func TestParseUpdateTxt(t *testing.T) {
+	// unknown keys, "key: value" form, empty lines and \r\n are accepted
+	s := "\r\n[SumatraPDF]\r\nLatest: 3.2\r\n\r\nInstaller64 https://example.com/a.exe\r\n"
+	got, err := parseUpdateTxt([]byte(s))
+	must(err)
+	exp := UpdateInfo{Latest: "3.2", Other: map[string]string{"Installer64": "https://example.com/a.exe"}}
+	if !reflect.DeepEqual(*got, exp) {
+		t.Errorf("parseUpdateTxt(%q) = %+v, expected %+v", s, *got, exp)
+	}
+
+	invalid := []string{
+		"",
+		"Latest 3.2\n",
+		"[Other]\nLatest 3.2\n",
+		"[SumatraPDF]\n",
+		"[SumatraPDF]\nZstd64 https://example.com/a.tar.zst\n",
+		"[SumatraPDF]\nLatest\n",
+		"[SumatraPDF]\nLatest \n",
+		"[SumatraPDF]\nLatest 3.2\nLatest 3.3\n",
+	}
+	for _, s := range invalid {
+		if _, err := parseUpdateTxt([]byte(s)); err == nil {
+			t.Errorf("parseUpdateTxt(%q) didn't fail", s)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/kjk/u"
+	"github.com/minio/minio-go/v6"
+)
+
+// size of each file in a storage, by key
+type storageSizes map[string]int64
+
+func minioListSizes(c minioStorage, prefix string) (storageSizes, error) {
+	res := storageSizes{}
+	err := c.ListRemoteFilesFunc(context.Background(), prefix, func(oi *minio.ObjectInfo) error {
+		res[oi.Key] = oi.Size
+		return nil
+	})
+	return res, err
+}
+
+// unlike s3ListPreReleaseFilesMust() this reads all pages of the listing
+func s3ListSizes(c *S3Client, prefix string) (storageSizes, error) {
+	bucket := c.GetBucket()
+	res := storageSizes{}
+	marker := ""
+	for {
+		resp, err := bucket.List(prefix, "", marker, maxS3Results)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range resp.Contents {
+			res[key.Key] = key.Size
+			marker = key.Key
+		}
+		if !resp.IsTruncated || len(resp.Contents) == 0 {
+			return res, nil
+		}
+	}
+}
+
+type sizeMismatch struct {
+	Key   string
+	SizeA int64
+	SizeB int64
+}
+
+// differences between files in 2 storages, sorted by key
+type backendsDiff struct {
+	OnlyInA      []string
+	OnlyInB      []string
+	SizeMismatch []*sizeMismatch
+}
+
+func (d *backendsDiff) isEmpty() bool {
+	return len(d.OnlyInA) == 0 && len(d.OnlyInB) == 0 && len(d.SizeMismatch) == 0
+}
+
+func diffBackends(a storageSizes, b storageSizes) *backendsDiff {
+	res := &backendsDiff{}
+	for key, sizeA := range a {
+		sizeB, ok := b[key]
+		if !ok {
+			res.OnlyInA = append(res.OnlyInA, key)
+		} else if sizeA != sizeB {
+			res.SizeMismatch = append(res.SizeMismatch, &sizeMismatch{key, sizeA, sizeB})
+		}
+	}
+	for key := range b {
+		if _, ok := a[key]; !ok {
+			res.OnlyInB = append(res.OnlyInB, key)
+		}
+	}
+	sort.Strings(res.OnlyInA)
+	sort.Strings(res.OnlyInB)
+	sort.Slice(res.SizeMismatch, func(i, j int) bool {
+		return res.SizeMismatch[i].Key < res.SizeMismatch[j].Key
+	})
+	return res
+}
+
+func printBackendsDiff(d *backendsDiff, nameA string, nameB string) {
+	fmt.Printf("only in %s (%d):\n", nameA, len(d.OnlyInA))
+	for _, key := range d.OnlyInA {
+		fmt.Printf("  %s\n", key)
+	}
+	fmt.Printf("only in %s (%d):\n", nameB, len(d.OnlyInB))
+	for _, key := range d.OnlyInB {
+		fmt.Printf("  %s\n", key)
+	}
+	fmt.Printf("different size (%d):\n", len(d.SizeMismatch))
+	for _, m := range d.SizeMismatch {
+		fmt.Printf("  %s %s: %s, %s: %s\n", m.Key, nameA, u.FmtSizeHuman(m.SizeA), nameB, u.FmtSizeHuman(m.SizeB))
+	}
+}
+
+// compares files under dir (relative to remoteRoot) in spaces and s3.
+// Returns false if they differ
+func diffSpacesAndS3(dir string) bool {
+	fatalIf(!hasSpacesCreds() || !hasS3Creds(), "need credentials for both spaces and s3\n")
+	prefix := remoteJoin(remoteRoot, dir) + "/"
+	spacesSizes, err := minioListSizes(newMinioStorage(), prefix)
+	must(err)
+	s3Sizes, err := s3ListSizes(newS3Client(), prefix)
+	must(err)
+	d := diffBackends(spacesSizes, s3Sizes)
+	logf("%d files in spaces, %d in s3 under '%s'\n", len(spacesSizes), len(s3Sizes), prefix)
+	printBackendsDiff(d, storageSpaces, storageS3)
+	return d.isEmpty()
+}
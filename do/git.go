@@ -1,6 +1,8 @@
 package main
 
 import (
+	"fmt"
+	"os/exec"
 	"strings"
 
 	"github.com/kjk/u"
@@ -16,6 +18,35 @@ func getGitLinearVersionMust() int {
 	return n
 }
 
+// returns sha1 of the commit for which getGitLinearVersionMust() returned
+// ver or empty string if not found (e.g. in a shallow clone)
+func getGitSha1ForLinearVersion(ver int) string {
+	out := runExeMust("git", "rev-list", "--reverse", "HEAD")
+	lines := toTrimmedLines(out)
+	idx := ver - 1000 - 1
+	if idx < 0 || idx >= len(lines) {
+		logf("getGitSha1ForLinearVersion: no commit for version %d\n", ver)
+		return ""
+	}
+	return lines[idx]
+}
+
+// returns one-line summaries of commits after sha1 up to HEAD. If sha1 is
+// empty or not in local history (e.g. in a shallow clone), returns
+// summary of HEAD
+func getGitLogSince(sha1 string) string {
+	if sha1 != "" {
+		cmd := exec.Command("git", "log", "--oneline", sha1+"..HEAD")
+		out, err := cmd.CombinedOutput()
+		if err == nil {
+			return string(out)
+		}
+		logf("getGitLogSince: '%s' failed with '%s'\n", cmd, err)
+	}
+	out := runExeMust("git", "log", "--oneline", "-1")
+	return string(out)
+}
+
 func getGitSha1Must() string {
 	out := runExeMust("git", "rev-parse", "HEAD")
 	s := strings.TrimSpace(string(out))
@@ -39,6 +70,52 @@ func verifyGitCleanMust() {
 	u.PanicIf(!isGitClean(), "git has unsaved changes\n")
 }
 
+// names of tags that mark release ${ver} e.g. "3.2rel" (how we've been
+// tagging releases), "3.2" or "v3.2"
+func getReleaseTagNames(ver string) []string {
+	return []string{ver + "rel", ver, "v" + ver}
+}
+
+// returns tags pointing to commit sha1
+func getGitTagsForSha1Must(sha1 string) []string {
+	out := runExeMust("git", "tag", "--points-at", sha1)
+	var res []string
+	for _, l := range toTrimmedLines(out) {
+		if l != "" {
+			res = append(res, l)
+		}
+	}
+	return res
+}
+
+// a release must be uploaded from a clean checkout of a commit tagged
+// with sumatraVersion. Returns an error if it's not
+func verifyGitStateForRelease() error {
+	if !isGitClean() {
+		return fmt.Errorf("git has unsaved changes")
+	}
+	sha1 := getGitSha1()
+	tags := getGitTagsForSha1Must(sha1)
+	for _, tag := range tags {
+		for _, name := range getReleaseTagNames(sumatraVersion) {
+			if tag == name {
+				logf("Release %s is from commit %s tagged '%s'\n", sumatraVersion, sha1, tag)
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("commit %s is not tagged as release %s (expected one of %s, has tags: [%s])", sha1, sumatraVersion, strings.Join(getReleaseTagNames(sumatraVersion), ", "), strings.Join(tags, ", "))
+}
+
+// verifyGitStateForRelease() unless over-ridden with -no-release-git-check
+func verifyGitStateForReleaseMust(buildType string) {
+	if buildType != buildTypeRel || flgNoReleaseGitCheck {
+		return
+	}
+	err := verifyGitStateForRelease()
+	fatalIf(err != nil, "%s. Use -no-release-git-check to upload anyway\n", err)
+}
+
 /*
 Given result of git btranch that looks like:
 
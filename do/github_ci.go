@@ -23,7 +23,7 @@ func triggerBuildWebHook(typ string) {
 	req.Header.Set("Accept", "application/vnd.github.everest-preview+json")
 	val := fmt.Sprintf("token %s", ghtoken)
 	req.Header.Set("Authorization", val)
-	rsp, err := http.DefaultClient.Do(req)
+	rsp, err := getHTTPClient().Do(req)
 	u.Must(err)
 	u.PanicIf(rsp.StatusCode >= 400)
 }
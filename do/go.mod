@@ -7,6 +7,7 @@ require (
 	github.com/kjk/fmthtml v0.0.0-20190816041536-39f5e479d32d
 	github.com/kjk/notionapi v0.0.0-20200220221049-45ba7d333957
 	github.com/kjk/u v0.0.0-20191229080709-d1ac8976d53f
+	github.com/klauspost/compress v1.11.13
 	github.com/minio/minio-go/v6 v6.0.44
 	github.com/smartystreets/goconvey v1.6.4 // indirect
 	github.com/vaughan0/go-ini v0.0.0-20130923145212-a98ad7ee00ec // indirect
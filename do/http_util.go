@@ -3,6 +3,8 @@ package main
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -12,10 +14,48 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/kjk/u"
 )
 
+var (
+	httpClient     *http.Client
+	httpClientOnce sync.Once
+)
+
+// returns transport for all outbound http requests. It honors
+// HTTP_PROXY / HTTPS_PROXY env variables and trusts certificates
+// from -ca-certs file in addition to system certificates
+func newHTTPTransport() *http.Transport {
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.Proxy = http.ProxyFromEnvironment
+	if flgCACertsPath == "" {
+		return tr
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		pool = x509.NewCertPool()
+	}
+	d := u.ReadFileMust(flgCACertsPath)
+	ok := pool.AppendCertsFromPEM(d)
+	fatalIf(!ok, "no certificates found in '%s'\n", flgCACertsPath)
+	tr.TLSClientConfig = &tls.Config{
+		RootCAs: pool,
+	}
+	return tr
+}
+
+// returns http client shared by all outbound http requests
+func getHTTPClient() *http.Client {
+	httpClientOnce.Do(func() {
+		httpClient = &http.Client{
+			Transport: newHTTPTransport(),
+		}
+	})
+	return httpClient
+}
+
 func logErrorf(ctx context.Context, format string, args ...interface{}) {
 	msg := u.FmtSmart(format, args...)
 	fmt.Printf(msg)
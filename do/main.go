@@ -16,6 +16,108 @@ var (
 	flgNoCleanCheck          bool
 	flgUpload                bool
 	flgSkipTranslationVerify bool
+	// if given, upload files from this directory instead of out/final-*
+	flgUploadDir string
+	// if given, comma-separated names of files in upload directory that
+	// are the only ones uploaded to spaces
+	flgUploadOnly string
+	// if > 0, -print-download-urls prints presigned urls valid this long
+	flgPresignExpiry time.Duration
+	// over-rides which storages build types are uploaded to,
+	// e.g. "daily=spaces;rel=s3,spaces". See buildTypeStorages
+	flgUploadStorages string
+	// comma-separated patterns of names of files that we don't upload
+	flgUploadIgnore string
+	// how many files to delete in parallel when deleting old builds
+	flgDeleteParallel int
+	// if > 0, max number of delete requests per second
+	flgDeleteRateLimit int
+	// when deleting old builds, refuse to delete more than this fraction
+	// of files unless flgAllowLargeDelete
+	flgMaxDeleteFraction float64
+	flgAllowLargeDelete  bool
+	// deleting old builds from all storages must finish in this time
+	flgDeleteTimeout time.Duration
+	// upload lock older than this is considered stale
+	flgUploadLockMaxAge time.Duration
+	// if > 0, over-rides how many most recent builds -delete-old-builds keeps
+	flgRetainBuilds int
+	// if > 0, -delete-old-builds also keeps builds newer than this
+	flgRetainMinAge time.Duration
+	// if true, when listing builds, read manifests whose names we
+	// can't parse to get their version
+	flgVersionFromManifest bool
+	// if > 0, refuse to upload a build if files of its build type would
+	// take more than this many megabytes in storage
+	flgUploadQuotaMB int
+	// refuse to upload files larger than this many megabytes
+	flgMaxUploadMB int
+	// if true, log more information (e.g. how versions are extracted
+	// from names of uploaded files)
+	flgVerbose bool
+	// if true, also create and upload .tar.zst variant of .zip files
+	flgZstd bool
+	// if given, upload content of this file as notes for the build
+	// instead of git log since previous build
+	flgNotesFile string
+	// if given, a file with PEM certificates to trust in addition to
+	// system certificates for outbound http requests
+	flgCACertsPath string
+	// warn if upload of strings to translation server is bigger than
+	// this many kilobytes
+	flgTransUploadWarnKB int
+	// if true, generating translations also writes strings/langs-meta.txt
+	flgTransLangsMeta bool
+	// how many times we try uploading strings and downloading translations
+	flgTransRetries int
+	// if true, accept downloaded translations with much fewer strings or
+	// translations than strings/translations.txt
+	flgTransAllowShrink bool
+	// if true, -trans-dl only applies changes listed in
+	// strings/translations-approved.txt (see freezeTranslations())
+	flgTransFreeze bool
+	// translations this many times longer than the string are reported
+	// when generating code (see findLongTranslations()). 0 disables
+	flgTransMaxLenRatio float64
+	// if true, translations reported as too long are not in generated code
+	flgTransDropLong bool
+	// -trans-dl fails if a language listed in strings/supported-langs.txt
+	// is less than this percent translated
+	flgTransMinSupported float64
+	// -trans-dl warns if other languages are less than this percent translated
+	flgTransMinCommunity float64
+	// if true, get strings to translate from translations/strings.txt
+	// instead of scanning source code
+	flgStringsFromFile bool
+	// if true, upload even if the latest uploaded build was built
+	// from the same git sha1
+	flgForceUpload bool
+	// if true, don't upload release and pre-release builds with unsigned executables
+	flgVerifySigned bool
+	// comma-separated hash algorithms (see checksumAlgos) used for
+	// checksum files and manifest of builds
+	flgChecksums string
+	// form of download urls in sumatralatest.js (see urlForMode())
+	flgLatestJsURLs string
+	// server-side encryption of uploaded files (see parseSSE())
+	flgSSE string
+	// where to send metrics of release jobs (see newMetricsSink())
+	flgMetrics string
+	// minimum Windows version for the build, written to *-update.txt
+	// (see parseMinOSVersions())
+	flgMinOSVersion string
+	// if true, we also upload .pdb files from *.pdb.zip in symbol server
+	// layout (see minioUploadSymbols())
+	flgUploadSymbols bool
+	// if true, manifest and -list-builds -json have uncompressed sizes of
+	// .zip files (see zipUncompressedSize())
+	flgZipSizes bool
+	// if true, we upload release builds even if they're not from
+	// a clean, tagged commit
+	flgNoReleaseGitCheck bool
+	// comma-separated kinds of version info files to update (see
+	// versionFileKinds)
+	flgVersionFiles string
 )
 
 func regenPremake() {
@@ -122,6 +224,7 @@ func main() {
 		flgDownloadTranslations    bool
 		flgRegenerateTranslattions bool
 		flgUploadTranslations      bool
+		flgTransUploadSpaces       bool
 		flgClean                   bool
 		flgDeleteOldBuilds         bool
 		flgCrashes                 bool
@@ -145,6 +248,39 @@ func main() {
 		flgDiff                    bool
 		flgGenStructs              bool
 		flgUpdateVer               string
+		flgBuildSizeDiff           string
+		flgListBuilds              string
+		flgPruneCandidates         string
+		flgDiffBackends            string
+		flgTranslationsStatus      bool
+		flgLintTranslations        bool
+		flgLintTranslationsReport  string
+		flgJSON                    bool
+		flgDownloadBuild           int
+		flgVerifyBuild             int
+		flgVerifyParity            bool
+		flgBuildType               string
+		flgPreviewRetention        string
+		flgMigrateNames            bool
+		flgMigrateLatest           bool
+		flgDeleteOrphans           bool
+		flgDryRun                  bool
+		flgPurgeChannel            string
+		flgPurgeConfirm            string
+		flgRegenLatestInfo         bool
+		flgRollbackLatest          bool
+		flgRollbackMarkBad         bool
+		flgCheckPublished          bool
+		flgEmitGoTranslations      string
+		flgTranslationsCSV         bool
+		flgTranslationsCoverage    bool
+		flgReconcileTranslations   string
+		flgSaveStringsList         bool
+		flgPrintDownloadURLs       string
+		flgAuditVersionInfo        bool
+		flgCheckConfig             string
+		flgSmokeTestLatest         bool
+		flgSmokeTestInstall        bool
 	)
 
 	{
@@ -160,13 +296,61 @@ func main() {
 		flag.BoolVar(&flgBuildLzsa, "build-lzsa", false, "build MakeLZSA.exe")
 		flag.BoolVar(&flgNoCleanCheck, "no-clean-check", false, "allow running if repo has changes (for testing build script)")
 		flag.BoolVar(&flgUpload, "upload", false, "upload the build to s3 and do spaces")
+		flag.StringVar(&remoteRoot, "remote-root", remoteRoot, "prefix of remote paths of uploaded builds, e.g. staging/software/sumatrapdf/")
+		flag.DurationVar(&flgUploadLockMaxAge, "upload-lock-max-age", time.Hour, "upload lock older than this is considered stale and over-ridden")
+		flag.IntVar(&flgMaxUploadMB, "max-upload-mb", 512, "refuse to upload a file larger than this many MB (0 is no limit)")
+		flag.IntVar(&flgUploadQuotaMB, "upload-quota-mb", 0, "refuse to upload if files of the build type would take more than this many MB in spaces (0 is no limit)")
+		flag.BoolVar(&flgZstd, "zstd", false, "also create and upload .tar.zst of SumatraPDF.exe")
+		flag.BoolVar(&flgVerifySigned, "verify-signed", false, "don't upload release and pre-release builds unless their .exe files have a valid Authenticode signature")
+		flag.BoolVar(&flgForceUpload, "force", false, "upload the build even if latest build in spaces is from the same git sha1")
+		flag.StringVar(&flgNotesFile, "notes-file", "", "file with notes for the uploaded build (default: git log since previous build)")
+		flag.StringVar(&flgUploadStorages, "upload-storages", "", "over-ride storages to upload build types to e.g. daily=spaces;rel=s3,spaces")
+		flag.StringVar(&flgUploadIgnore, "upload-ignore", defaultUploadIgnore, "comma-separated patterns of names of files that are not uploaded")
+		flag.StringVar(&flgUploadOnly, "upload-only", "", "only upload these comma-separated files of the build to spaces e.g. to replace a corrupted file. Doesn't update version info files")
+		flag.StringVar(&flgUploadDir, "upload-dir", "", "upload build files from this directory instead of out/final-${buildType}")
+		flag.StringVar(&flgCACertsPath, "ca-certs", "", "file with additional PEM certificates to trust e.g. of a proxy (proxy is set with HTTPS_PROXY env variable)")
 		flag.BoolVar(&flgClangFormat, "clang-format", false, "format source files with clang-format")
 		flag.BoolVar(&flgWc, "wc", false, "show loc stats (like wc -l)")
 		flag.BoolVar(&flgDownloadTranslations, "trans-dl", false, "download translations and re-generate C code")
 		flag.BoolVar(&flgRegenerateTranslattions, "trans-regen", false, "regenerate .cpp translations files from strings/translations.txt")
 		flag.BoolVar(&flgUploadTranslations, "trans-upload", false, "upload translations to apptranslators.org if changed")
+		flag.BoolVar(&flgTransUploadSpaces, "trans-upload-spaces", false, "upload strings/translations.txt to spaces as translations/${sha1}.txt.gz (also after -trans-dl and -trans-regen)")
+		flag.BoolVar(&flgTransLangsMeta, "trans-langs-meta", false, "when generating translations (-trans-dl, -trans-regen) also write code, names and direction of included languages to strings/langs-meta.txt")
+		flag.StringVar(&flgMetrics, "metrics", "", "send metrics (upload duration, deleted files etc.) to statsd:${host}:${port} or pushgateway:${url}")
+		flag.StringVar(&flgVersionFiles, "version-files", "js,latest,update", "which version info files to update when uploading or re-generating them: js (website), latest (*-latest.txt), update (*-update.txt read by the updater)")
+		flag.BoolVar(&flgNoReleaseGitCheck, "no-release-git-check", false, "upload release build even if git has changes or the commit isn't tagged with the version")
+		flag.StringVar(&flgSSE, "sse", os.Getenv("UPLOAD_SSE"), "server-side encryption of files uploaded to spaces: s3 or kms:${keyID}. Uploads fail if s3 is also a target. Default is UPLOAD_SSE env variable, if set, otherwise no encryption")
+		flag.BoolVar(&flgUploadSymbols, "upload-symbols", false, "when uploading to spaces, also upload .pdb files from *.pdb.zip in symbol server layout under symbols/")
+		flag.BoolVar(&flgZipSizes, "zip-sizes", false, "record uncompressed size of .zip files in manifest and show it in -list-builds -json")
+		flag.StringVar(&flgMinOSVersion, "min-os-version", "", "minimum Windows version (e.g. 6.1) the build runs on, recorded in its manifest for the updater. Per architecture as 32=6.1,64=10.0")
+		flag.StringVar(&flgLatestJsURLs, "latest-js-urls", urlModeAbsolute, "form of download urls in sumatralatest.js: absolute, protocol-relative (//host/path) or path (/path)")
+		flag.StringVar(&flgChecksums, "checksums", "sha256", "comma-separated hash algorithms (sha1, sha256, sha512) for checksum files and manifest of uploaded builds")
+		flag.Float64Var(&flgTransMaxLenRatio, "trans-max-len-ratio", 3, "when generating translations, report those this many times longer than the English string (0 to not check)")
+		flag.BoolVar(&flgTransDropLong, "trans-drop-long", false, "don't include translations reported by -trans-max-len-ratio in generated code")
+		flag.Float64Var(&flgTransMinSupported, "trans-min-supported", 95, "with -trans-dl, fail if a language in strings/supported-langs.txt is less than this percent translated")
+		flag.Float64Var(&flgTransMinCommunity, "trans-min-community", 50, "with -trans-dl, warn if a language not in strings/supported-langs.txt is less than this percent translated")
+		flag.BoolVar(&flgTransFreeze, "trans-freeze", false, "with -trans-dl, report all changes of translations but only apply those in strings/translations-approved.txt (for release branches)")
+		flag.BoolVar(&flgTransAllowShrink, "trans-allow-shrink", false, "accept downloaded translations with much fewer strings or translations than strings/translations.txt")
+		flag.IntVar(&flgTransRetries, "trans-retries", 3, "how many times to try uploading strings to and downloading translations from apptranslator.org")
+		flag.IntVar(&flgTransUploadWarnKB, "trans-upload-warn-kb", 1024, "warn if upload of strings to apptranslator.org is bigger than this many kB (0 is no warning)")
 		flag.BoolVar(&flgClean, "clean", false, "clean the build (remove out/ files except for settings)")
 		flag.BoolVar(&flgDeleteOldBuilds, "delete-old-builds", false, "delete old builds")
+		flag.IntVar(&flgRetainBuilds, "retain-builds", 0, "if > 0, number of most recent builds -delete-old-builds keeps instead of the default for the build type")
+		flag.DurationVar(&flgRetainMinAge, "retain-min-age", 0, "if > 0, -delete-old-builds also keeps builds newer than this e.g. 720h")
+		flag.BoolVar(&flgMigrateNames, "migrate-names", false, "rename files of builds (of type -build-type) in spaces from legacy names (e.g. SumatraPDF-prerelease-) to current names")
+		flag.BoolVar(&flgMigrateLatest, "migrate-latest", false, "with -migrate-names, also rename files of the version in *-latest.txt")
+		flag.BoolVar(&flgDryRun, "dry-run", false, "with -migrate-names, -delete-orphans or -purge-channel, only show what would be renamed or deleted")
+		flag.StringVar(&flgPurgeChannel, "purge-channel", "", "delete all builds and version info files of a given build type we no longer publish (only lists them without -purge-confirm)")
+		flag.StringVar(&flgPurgeConfirm, "purge-confirm", "", "confirms -purge-channel, must be the same build type")
+		flag.BoolVar(&flgDeleteOrphans, "delete-orphans", false, "delete checksum and manifest files of builds (of type -build-type) in spaces whose files no longer exist")
+		flag.StringVar(&flgPreviewRetention, "preview-retention", "", "show what -delete-old-builds would delete from builds (of type -build-type) in a listing saved with -list-builds ${type} -json")
+		flag.BoolVar(&flgVerbose, "verbose", false, "log more information e.g. how versions of builds in spaces are determined")
+		flag.BoolVar(&flgVersionFromManifest, "version-from-manifest", false, "when listing builds, read version from content of manifests with unparseable names (slower)")
+		flag.IntVar(&flgDeleteParallel, "delete-parallel", 8, "number of parallel deletes when deleting old builds")
+		flag.Float64Var(&flgMaxDeleteFraction, "max-delete-fraction", 0.5, "when deleting old builds, refuse to delete more than this fraction of files")
+		flag.DurationVar(&flgDeleteTimeout, "delete-timeout", 30*time.Minute, "give up deleting old builds after this long so that a stuck listing or slow storage can't hang ci")
+		flag.BoolVar(&flgAllowLargeDelete, "allow-large-delete", false, "allow deleting more than -max-delete-fraction of files when deleting old builds")
+		flag.IntVar(&flgDeleteRateLimit, "delete-rate-limit", 0, "max deletes per second when deleting old builds (0 is unlimited)")
 		flag.BoolVar(&flgCrashes, "crashes", false, "see crashes in a web ui")
 		flag.BoolVar(&flgCheckAccessKeys, "check-access-keys", false, "check access keys for menu items")
 		flag.BoolVar(&flgBuildNo, "build-no", false, "print build number")
@@ -187,16 +371,81 @@ func main() {
 		flag.BoolVar(&flgDiff, "diff", false, "preview diff using winmerge")
 		flag.BoolVar(&flgGenStructs, "gen-structs", false, "re-generate src/SettingsStructs.h")
 		flag.StringVar(&flgUpdateVer, "update-auto-update-ver", "", "update version used for auto-update checks")
+		flag.StringVar(&flgDiffBackends, "diff-backends", "", "compare files under a given dir (e.g. prerel) in spaces and s3 and report files missing in one of them or with different sizes")
+		flag.StringVar(&flgPruneCandidates, "prune-candidates", "", "list versions of a given build type (daily, prerel, ramicro) that are neither latest nor pinned, oldest first. Only those are deleted by -delete-old-builds")
+		flag.StringVar(&flgListBuilds, "list-builds", "", "list builds of a given type (daily, prerel, ramicro) in spaces")
+		flag.BoolVar(&flgTranslationsCSV, "trans-csv", false, "write strings/translations.txt as strings/translations.csv for reviewing in a spreadsheet")
+		flag.BoolVar(&flgTranslationsCoverage, "trans-coverage", false, "write percent translated and badge color of each language to strings/coverage.json")
+		flag.StringVar(&flgEmitGoTranslations, "trans-emit-go", "", "write translations of strings used in the code as a Go source file with a given path")
+		flag.StringVar(&flgReconcileTranslations, "trans-reconcile", "", "check that translations in a given file are a subset of strings/translations.txt")
+		flag.BoolVar(&flgStringsFromFile, "trans-strings-from-file", false, "get strings to translate from translations/strings.txt (see -trans-save-strings) instead of scanning source code")
+		flag.BoolVar(&flgSaveStringsList, "trans-save-strings", false, "save strings to translate extracted from source code to translations/strings.txt")
+		flag.BoolVar(&flgTranslationsStatus, "trans-status", false, "show how complete are translations for each language")
+		flag.BoolVar(&flgLintTranslations, "trans-lint", false, "check strings/translations.txt for problems")
+		flag.StringVar(&flgLintTranslationsReport, "trans-lint-report", "", "with -trans-lint, also write issues to this file (JSON if it ends with .json)")
+		flag.BoolVar(&flgJSON, "json", false, "output of -list-builds, -prune-candidates, -trans-status and -trans-dl as json")
+		flag.BoolVar(&flgVerifyParity, "verify-parity", false, "check that the build (of type -build-type) in -upload-dir or out/final-${buildType} is exactly what is in spaces, with no missing or extra files")
+		flag.IntVar(&flgVerifyBuild, "verify-build", 0, "check that files of a given version of a build (of type -build-type) in spaces match sha256 recorded in its manifest")
+		flag.IntVar(&flgDownloadBuild, "download-build", 0, "download a given version of a build (of type -build-type) to out/downloads")
+		flag.StringVar(&flgBuildType, "build-type", buildTypePreRel, "build type (daily, prerel, rel, ramicro) for commands that need it")
+		flag.BoolVar(&flgAuditVersionInfo, "audit-version-info", false, "check that files referenced by version info files of pre-release and daily builds exist")
+		flag.StringVar(&flgPrintDownloadURLs, "print-download-urls", "", "print download urls of a given version of -build-type build")
+		flag.DurationVar(&flgPresignExpiry, "presign-expiry", 0, "if > 0, -print-download-urls prints presigned urls valid for this long e.g. 24h")
+		flag.StringVar(&flgCheckConfig, "check-config", "", "check env variables and storage needed for operations (comma separated: upload, trans or all) before running them")
+		flag.BoolVar(&flgSmokeTestLatest, "smoke-test-latest", false, "download 64-bit installer and zip of latest build of -build-type from spaces and check them against its manifest")
+		flag.BoolVar(&flgSmokeTestInstall, "smoke-test-install", false, "with -smoke-test-latest, also extract files from the installer (windows only)")
+		flag.BoolVar(&flgCheckPublished, "check-published", false, "check that latest build of -build-type in spaces is correctly published")
+		flag.BoolVar(&flgRollbackLatest, "rollback-latest", false, "point version info files of -build-type in spaces to the version before the one they point to")
+		flag.BoolVar(&flgRollbackMarkBad, "rollback-mark-bad", false, "with -rollback-latest, never point version info files to the rolled back version again")
+		flag.BoolVar(&flgRegenLatestInfo, "regen-latest-info", false, "re-upload version info files (sumatralatest.js etc.) for latest build of -build-type in spaces")
+		flag.StringVar(&flgBuildSizeDiff, "build-size-diff", "", "show size changes between 2 pre-release builds e.g. 12200,12230")
 		flag.Parse()
 	}
 
+	{
+		var err error
+		metrics, err = newMetricsSink(flgMetrics)
+		panicIfErr(err)
+		defer metrics.Flush()
+		uploadSSE, err = parseSSE(flgSSE)
+		panicIfErr(err)
+	}
+
+	if flgCheckConfig != "" {
+		ok := checkConfig(flgCheckConfig)
+		if !ok {
+			os.Exit(1)
+		}
+		return
+	}
+
 	// early check so we don't find it out only after 20 minutes of building
 	if flgUpload || flgUploadCiBuild {
 		if shouldSignAndUpload() {
-			panicIf(!hasSpacesCreds())
-			panicIf(!hasS3Creds())
+			// with credentials for only one storage we upload only there
+			panicIf(len(availableStorages()) == 0, "no credentials for spaces or s3")
 		}
 	}
+	if flgUploadStorages != "" {
+		_, err := parseBuildTypeStorages(flgUploadStorages)
+		panicIfErr(err)
+	}
+	{
+		_, err := parseChecksumAlgos(flgChecksums)
+		panicIfErr(err)
+	}
+	panicIf(!isValidURLMode(flgLatestJsURLs), "invalid -latest-js-urls '%s'", flgLatestJsURLs)
+	// time.Second / rate is the interval between deletes so it must not be 0
+	panicIf(flgDeleteRateLimit < 0 || time.Duration(flgDeleteRateLimit) > time.Second, "-delete-rate-limit must be between 0 and %d, got %d", int64(time.Second), flgDeleteRateLimit)
+	panicIf(flgTransRetries < 1, "-trans-retries must be at least 1, got %d", flgTransRetries)
+	// fail before -trans-dl or -trans-regen do their work
+	fatalIf(flgTransUploadSpaces && !hasSpacesCreds(), "-trans-upload-spaces needs SPACES_KEY and SPACES_SECRET env variables\n")
+	{
+		_, err := parseMinOSVersions(flgMinOSVersion)
+		panicIfErr(err)
+		_, err = parseVersionFileKinds(flgVersionFiles)
+		panicIfErr(err)
+	}
 
 	if flgWebsiteRun {
 		websiteRunLocally()
@@ -302,12 +551,48 @@ func main() {
 	}
 
 	if flgDownloadTranslations {
-		downloadTranslationsMain()
+		downloadTranslationsMain(flgJSON)
+		if flgTransUploadSpaces {
+			minioUploadTranslationsMust()
+		}
+		return
+	}
+
+	if flgSaveStringsList {
+		saveStringsList()
+		return
+	}
+
+	if flgReconcileTranslations != "" {
+		reconcileTranslationsMain(flgReconcileTranslations)
+		return
+	}
+
+	if flgTranslationsCSV {
+		emitTranslationsCSV()
+		return
+	}
+
+	if flgTranslationsCoverage {
+		emitTranslationsCoverage()
+		return
+	}
+
+	if flgEmitGoTranslations != "" {
+		emitGoTranslations(flgEmitGoTranslations)
 		return
 	}
 
 	if flgRegenerateTranslattions {
 		regenerateLangs()
+		if flgTransUploadSpaces {
+			minioUploadTranslationsMust()
+		}
+		return
+	}
+
+	if flgTransUploadSpaces {
+		minioUploadTranslationsMust()
 		return
 	}
 
@@ -357,10 +642,130 @@ func main() {
 		return
 	}
 
+	if flgListBuilds != "" {
+		minioListBuilds(flgListBuilds, flgJSON)
+		return
+	}
+
+	if flgPruneCandidates != "" {
+		printPruneCandidates(flgPruneCandidates, flgJSON)
+		return
+	}
+
+	if flgDiffBackends != "" {
+		if !diffSpacesAndS3(flgDiffBackends) {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if flgLintTranslations {
+		lintTranslationsMain(flgLintTranslationsReport)
+		return
+	}
+
+	if flgTranslationsStatus {
+		printTranslationsStatus(flgJSON)
+		return
+	}
+
+	if flgMigrateNames {
+		err := minioMigrateLegacyNames(newMinioStorage(), flgBuildType, flgDryRun, flgMigrateLatest)
+		must(err)
+		return
+	}
+
+	if flgPurgeChannel != "" {
+		confirmed := flgPurgeConfirm == flgPurgeChannel
+		fatalIf(flgPurgeConfirm != "" && !confirmed, "-purge-confirm '%s' doesn't match -purge-channel '%s'\n", flgPurgeConfirm, flgPurgeChannel)
+		err := minioPurgeChannel(newMinioStorage(), flgPurgeChannel, confirmed, flgDryRun)
+		must(err)
+		return
+	}
+
+	if flgDeleteOrphans {
+		err := minioDeleteOrphanedFiles(newMinioStorage(), flgBuildType, flgDryRun)
+		must(err)
+		return
+	}
+
+	if flgPreviewRetention != "" {
+		previewRetention(flgBuildType, flgPreviewRetention)
+		return
+	}
+
+	if flgVerifyBuild != 0 {
+		err := verifyBuildIntegrity(newMinioStorage(), flgBuildType, flgVerifyBuild)
+		must(err)
+		fmt.Printf("Version %d of '%s' matches its manifest\n", flgVerifyBuild, flgBuildType)
+		return
+	}
+
+	if flgVerifyParity {
+		dirLocal := flgUploadDir
+		if dirLocal == "" {
+			dirLocal = getFinalDirForBuildType(flgBuildType)
+		}
+		err := verifyReleaseParity(newMinioStorage(), flgBuildType, dirLocal)
+		if err != nil {
+			fmt.Printf("%s\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if flgDownloadBuild != 0 {
+		downloadBuild(flgBuildType, flgDownloadBuild)
+		return
+	}
+
+	if flgAuditVersionInfo {
+		ok := auditVersionInfo(newMinioStorage())
+		if !ok {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if flgPrintDownloadURLs != "" {
+		printDownloadURLs(flgBuildType, flgPrintDownloadURLs)
+		return
+	}
+
+	if flgSmokeTestLatest {
+		ok := smokeTestLatest(newMinioStorage(), flgBuildType, flgSmokeTestInstall)
+		if !ok {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if flgCheckPublished {
+		ok := checkPublished(newMinioStorage(), flgBuildType)
+		if !ok {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if flgRollbackLatest {
+		minioRollbackLatest(newMinioStorage(), flgBuildType, flgRollbackMarkBad)
+		return
+	}
+
+	if flgRegenLatestInfo {
+		minioRegenerateLatestInfo(newMinioStorage(), flgBuildType)
+		return
+	}
+
+	if flgBuildSizeDiff != "" {
+		buildSizeDiff(flgBuildSizeDiff)
+		return
+	}
+
 	if flgDeleteOldBuilds {
 		fmt.Printf("delete old builds\n")
-		minioDeleteOldBuilds()
-		s3DeleteOldBuilds()
+		deleteOldBuildsFromAll()
 		return
 	}
 
@@ -377,21 +782,18 @@ func main() {
 		switch gev {
 		case githubEventNone:
 			// daily build on push
-			s3UploadBuildMust(buildTypeDaily)
-			spacesUploadBuildMust(buildTypeDaily)
+			uploadBuildToAll(buildTypeDaily)
 		case githubEventTypeBuildPreRel:
-			s3UploadBuildMust(buildTypePreRel)
-			spacesUploadBuildMust(buildTypePreRel)
+			uploadBuildToAll(buildTypePreRel)
 		case githubEventTypeBuildRaMicroPreRel:
-			spacesUploadBuildMust(buildTypeRaMicro)
+			uploadBuildToAll(buildTypeRaMicro)
 		case githubEventTypeCodeQL:
 			// do nothing
 		default:
 			panic("unkown value from getGitHubEventType()")
 		}
 
-		minioDeleteOldBuilds()
-		s3DeleteOldBuilds()
+		deleteOldBuildsFromAll()
 		return
 	}
 
@@ -400,8 +802,7 @@ func main() {
 		detectVersions()
 		buildRelease()
 		if flgUpload {
-			s3UploadBuildMust(buildTypeRel)
-			spacesUploadBuildMust(buildTypeRel)
+			uploadBuildToAll(buildTypeRel)
 		}
 		return
 	}
@@ -425,8 +826,7 @@ func main() {
 		failIfNoCertPwd()
 		detectVersions()
 		buildPreRelease()
-		s3UploadBuildMust(buildTypePreRel)
-		spacesUploadBuildMust(buildTypePreRel)
+		uploadBuildToAll(buildTypePreRel)
 		return
 	}
 
@@ -435,8 +835,7 @@ func main() {
 		failIfNoCertPwd()
 		detectVersions()
 		buildRaMicroPreRelease()
-		//s3UploadBuildMust(buildTypeRaMicro)
-		//spacesUploadBuildMust(buildTypeRaMicro)
+		//uploadBuildToAll(buildTypeRaMicro)
 		return
 	}
 
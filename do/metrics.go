@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// metricsSink receives metrics of release jobs (upload duration, files
+// deleted by retention etc.) so that we can graph them over time.
+// Names are like "upload_bytes_prerel". Failing to send metrics
+// must not fail the job so implementations only log errors
+type metricsSink interface {
+	Count(name string, n int64)
+	Gauge(name string, v float64)
+	// called before we exit, for sinks that send metrics in batches
+	Flush()
+}
+
+// used when -metrics is not given
+type nopMetrics struct{}
+
+func (nopMetrics) Count(name string, n int64)   {}
+func (nopMetrics) Gauge(name string, v float64) {}
+func (nopMetrics) Flush()                       {}
+
+// all metrics go through this, set with -metrics
+var metrics metricsSink = nopMetrics{}
+
+// statsdMetrics sends each metric as a udp packet in StatsD format
+type statsdMetrics struct {
+	addr string
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+func (m *statsdMetrics) send(line string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.conn == nil {
+		conn, err := net.Dial("udp", m.addr)
+		if err != nil {
+			logf("Failed to connect to statsd '%s', err: %s\n", m.addr, err)
+			return
+		}
+		m.conn = conn
+	}
+	_, err := m.conn.Write([]byte(line))
+	if err != nil {
+		logf("Failed to send '%s' to statsd '%s', err: %s\n", line, m.addr, err)
+	}
+}
+
+func (m *statsdMetrics) Count(name string, n int64) {
+	m.send(fmt.Sprintf("sumatrapdf.%s:%d|c", name, n))
+}
+
+func (m *statsdMetrics) Gauge(name string, v float64) {
+	m.send(fmt.Sprintf("sumatrapdf.%s:%g|g", name, v))
+}
+
+func (m *statsdMetrics) Flush() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.conn != nil {
+		m.conn.Close()
+		m.conn = nil
+	}
+}
+
+// pushgatewayMetrics collects metrics and pushes them to prometheus
+// pushgateway in Flush()
+type pushgatewayMetrics struct {
+	uri    string
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+func (m *pushgatewayMetrics) Count(name string, n int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.values[name] += float64(n)
+}
+
+func (m *pushgatewayMetrics) Gauge(name string, v float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.values[name] = v
+}
+
+func (m *pushgatewayMetrics) Flush() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.values) == 0 {
+		return
+	}
+	var names []string
+	for name := range m.values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var buf bytes.Buffer
+	for _, name := range names {
+		fmt.Fprintf(&buf, "sumatrapdf_%s %g\n", name, m.values[name])
+	}
+	uri := strings.TrimSuffix(m.uri, "/") + "/metrics/job/sumatrapdf_do"
+	rsp, err := getHTTPClient().Post(uri, "text/plain; version=0.0.4", &buf)
+	if err != nil {
+		logf("Failed to push metrics to '%s', err: %s\n", uri, err)
+		return
+	}
+	rsp.Body.Close()
+	if rsp.StatusCode != http.StatusOK && rsp.StatusCode != http.StatusAccepted {
+		logf("Failed to push metrics to '%s', status: %s\n", uri, rsp.Status)
+		return
+	}
+	m.values = map[string]float64{}
+}
+
+// creates a sink from -metrics which is "statsd:${host}:${port}" or
+// "pushgateway:${url}". Empty s means no metrics
+func newMetricsSink(s string) (metricsSink, error) {
+	if s == "" {
+		return nopMetrics{}, nil
+	}
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) == 2 && parts[1] != "" {
+		switch parts[0] {
+		case "statsd":
+			return &statsdMetrics{addr: parts[1]}, nil
+		case "pushgateway":
+			return &pushgatewayMetrics{uri: parts[1], values: map[string]float64{}}, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid metrics sink '%s', must be statsd:${host}:${port} or pushgateway:${url}", s)
+}
+
+// returns name of metric for e.g. a given build type or language, like
+// "upload_bytes_prerel". '-' is not valid in prometheus names
+func metricName(name string, suffix string) string {
+	return name + "_" + strings.Replace(suffix, "-", "_", -1)
+}
+
+func gaugeDuration(name string, d time.Duration) {
+	metrics.Gauge(name, d.Seconds())
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// itemError is a failure of a single item (a file, a storage) of
+// a batch operation
+type itemError struct {
+	// storage (storageS3 or storageSpaces) of the item, if known
+	backend string
+	// e.g. remote path of a file. Empty if the item is the whole backend
+	item string
+	err  error
+}
+
+func (e *itemError) Error() string {
+	switch {
+	case e.backend == "":
+		return fmt.Sprintf("'%s': %s", e.item, e.err)
+	case e.item == "":
+		return fmt.Sprintf("%s: %s", e.backend, e.err)
+	}
+	return fmt.Sprintf("%s: '%s': %s", e.backend, e.item, e.err)
+}
+
+func (e *itemError) Unwrap() error {
+	return e.err
+}
+
+// MultiError collects failures of a batch operation (e.g. deleting many
+// files) so that we can report all of them instead of only the first.
+// It's safe to use from multiple goroutines
+type MultiError struct {
+	// what the operation does e.g. "delete files", for Error()
+	what string
+	// number of items in the operation, 0 if not known
+	nItems int
+
+	mu   sync.Mutex
+	errs []error
+}
+
+func newMultiError(what string, nItems int) *MultiError {
+	return &MultiError{what: what, nItems: nItems}
+}
+
+// records failure of item. Does nothing if err is nil
+func (e *MultiError) Add(item string, err error) {
+	e.AddForBackend("", item, err)
+}
+
+// like Add() but also records the storage backend of item
+func (e *MultiError) AddForBackend(backend string, item string, err error) {
+	if err == nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.errs = append(e.errs, &itemError{backend: backend, item: item, err: err})
+}
+
+func (e *MultiError) Errors() []error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]error(nil), e.errs...)
+}
+
+func (e *MultiError) Error() string {
+	errs := e.Errors()
+	s := fmt.Sprintf("failed to %s (%d", e.what, len(errs))
+	if e.nItems > 0 {
+		s += fmt.Sprintf(" out of %d", e.nItems)
+	}
+	s += "):"
+	var lines []string
+	for _, err := range errs {
+		lines = append(lines, "  "+err.Error())
+	}
+	return s + "\n" + strings.Join(lines, "\n")
+}
+
+// returns nil if there were no failures so that callers can return
+// the result as error
+func (e *MultiError) ErrorOrNil() error {
+	if len(e.Errors()) == 0 {
+		return nil
+	}
+	return e
+}
+
+// calls fn and returns its panic, if any, as an error. Lets us collect
+// failures of *Must() functions, which panic, in MultiError
+func catchPanic(fn func()) (err error) {
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		if e, ok := r.(error); ok {
+			err = e
+			return
+		}
+		err = fmt.Errorf("%v", r)
+	}()
+	fn()
+	return nil
+}
@@ -9,6 +9,7 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"regexp"
 	"time"
 
 	"github.com/goamz/goamz/aws"
@@ -20,6 +21,8 @@ type S3Client struct {
 	Access string
 	Secret string
 	Bucket string
+	// e.g. "eu-west-1". If empty, we use "us-east-1"
+	Region string
 }
 
 func md5B64OfBytes(d []byte) string {
@@ -45,7 +48,7 @@ func (c *S3Client) VerifyHasSecrets() {
 // (which fails for me with a timeout for large files e.g. ~6MB)
 func (c *S3Client) GetClient() *http.Client {
 	// return aws.RetryingClient
-	return http.DefaultClient
+	return getHTTPClient()
 }
 
 // GetBucket returns a bucket
@@ -55,13 +58,64 @@ func (c *S3Client) GetBucket() *s3.Bucket {
 		AccessKey: c.Access,
 		SecretKey: c.Secret,
 	}
-	// Note: it's important that region is aws.USEast. This is where my bucket
-	// is and giving a different region will fail
-	// TODO: make aws.USEast a variable s3BucketRegion, to allow over-ride
-	s3Obj := s3.New(auth, aws.USEast, c.GetClient())
+	// Note: region must match the region of the bucket or we'll get
+	// PermanentRedirect errors
+	s3Obj := s3.New(auth, c.GetRegion(), c.GetClient())
 	return s3Obj.Bucket(c.Bucket)
 }
 
+// GetRegion returns aws region for c.Region
+func (c *S3Client) GetRegion() aws.Region {
+	if c.Region == "" || c.Region == aws.USEast.Name {
+		return aws.USEast
+	}
+	region, ok := aws.Regions[c.Region]
+	if !ok {
+		// goamz only knows regions that existed in 2018. Newer regions
+		// work the same way as e.g. eu-west-1 so we construct them
+		region = aws.Region{
+			Name:                 c.Region,
+			S3LocationConstraint: true,
+			S3LowercaseBucket:    true,
+		}
+	}
+	region.S3Endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", c.Region)
+	return region
+}
+
+var s3RegionRx = regexp.MustCompile(`^[a-z]{2}(-[a-z]+)+-[0-9]+$`)
+
+// isValidS3RegionName returns true if s looks like an s3 region name
+// e.g. us-east-1, ap-southeast-3 or us-gov-west-1
+func isValidS3RegionName(s string) bool {
+	return s3RegionRx.MatchString(s)
+}
+
+// returns region of the bucket, as reported by s3 in x-amz-bucket-region
+// header. s3 sends it even for requests without credentials
+func s3GetBucketRegion(bucket string) (string, error) {
+	uri := fmt.Sprintf("https://%s.s3.amazonaws.com", bucket)
+	rsp, err := getHTTPClient().Head(uri)
+	if err != nil {
+		return "", err
+	}
+	rsp.Body.Close()
+	return rsp.Header.Get("x-amz-bucket-region"), nil
+}
+
+// VerifyRegion warns if c.Region doesn't match the region of the bucket
+func (c *S3Client) VerifyRegion() {
+	bucketRegion, err := s3GetBucketRegion(c.Bucket)
+	if err != nil || bucketRegion == "" {
+		logf("Couldn't get region of s3 bucket '%s', err: %v\n", c.Bucket, err)
+		return
+	}
+	region := c.GetRegion().Name
+	if bucketRegion != region {
+		logf("Warning: s3 bucket '%s' is in region '%s' but we use '%s'. Set AWS_REGION=%s\n", c.Bucket, bucketRegion, region, bucketRegion)
+	}
+}
+
 // UploadFileReader uploads file from a reader
 func (c *S3Client) UploadFileReader(pathRemote, pathLocal string, public bool) error {
 	logf("Uploading '%s' as '%s'. ", pathLocal, pathRemote)
@@ -2,9 +2,11 @@ package main
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"runtime"
 	"strings"
 	"time"
 
@@ -89,3 +91,41 @@ func signMust(path string) {
 	}
 	u.Must(err)
 }
+
+// returns an error if path doesn't have a valid Authenticode signature.
+// Uses signtool on Windows and osslsigncode elsewhere
+func verifySigned(path string) error {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command(detectSigntoolPath(), "verify", "/pa", "/q", path)
+	} else {
+		cmd = exec.Command("osslsigncode", "verify", "-in", path)
+	}
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s\n%s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// checks that all .exe files in dir are signed. Reports all unsigned files,
+// not just the first one
+func verifyDirSignedMust(dir string) {
+	files, err := ioutil.ReadDir(dir)
+	must(err)
+	var failed []string
+	for _, f := range files {
+		if !strings.EqualFold(filepath.Ext(f.Name()), ".exe") {
+			continue
+		}
+		path := filepath.Join(dir, f.Name())
+		err = verifySigned(path)
+		if err != nil {
+			logf("'%s' is not signed: %s\n", path, err)
+			failed = append(failed, path)
+			continue
+		}
+		logf("'%s' is signed\n", path)
+	}
+	fatalIf(len(failed) > 0, "%d files in '%s' are not signed: %s\n", len(failed), dir, strings.Join(failed, ", "))
+}
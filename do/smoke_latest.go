@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+)
+
+// extracts files from installer to dir without installing, to check
+// that it's not corrupt. -x implies silent
+func extractInstaller(installerPath string, dir string) error {
+	if runtime.GOOS != "windows" {
+		return fmt.Errorf("can only run installer on windows")
+	}
+	installerPath, err := filepath.Abs(installerPath)
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(installerPath, "-x", "-d", dir)
+	return runCmdLogged(cmd)
+}
+
+// smoke test of the latest build of buildType before announcing it:
+// downloads 64-bit installer and portable zip of version in
+// *-latest.txt and checks that they match the manifest. With
+// runInstaller, also extracts the installer. Prints pass/fail for each
+// artifact and returns false if any failed
+func smokeTestLatest(c minioStorage, buildType string, runInstaller bool) bool {
+	panicIf(!isValidBuildType(buildType), "invalid build type: '%s'", buildType)
+	latestPath := getRemotePaths(buildType)[1]
+	n, err := minioReadLatestVersion(c, buildType)
+	if err == nil && n == 0 {
+		err = fmt.Errorf("doesn't exist")
+	}
+	if err != nil {
+		fmt.Printf("FAIL: %s: %s\n", latestPath, err)
+		return false
+	}
+	ver := strconv.Itoa(n)
+	dirRemote := getRemoteDir(buildType)
+	prefix := getAppNameForBuildType(buildType) + "-" + ver
+	manifestPath := getManifestRemotePath(buildType, ver)
+	d, err := c.DownloadFileAsData(manifestPath)
+	var hashes map[string]manifestFileHash
+	if err == nil {
+		hashes, err = parseManifestHashes(d)
+	}
+	if err == nil && len(hashes) == 0 {
+		err = fmt.Errorf("no hashes, the build was uploaded before they were recorded")
+	}
+	if err != nil {
+		fmt.Printf("FAIL: %s: %s\n", manifestPath, err)
+		return false
+	}
+
+	// downloaded files are kept for inspection if something failed
+	workDirName := fmt.Sprintf("smoke-%s-%s", buildType, ver)
+	localDir := workDir(workDirName)
+	nFailed := 0
+	check := func(what string, err error) {
+		if err != nil {
+			nFailed++
+			fmt.Printf("FAIL: %s: %s\n", what, err)
+			return
+		}
+		fmt.Printf("ok:   %s\n", what)
+	}
+	installerName := prefix + "-64-install.exe"
+	for _, name := range []string{installerName, prefix + "-64.zip"} {
+		pathLocal := filepath.Join(localDir, name)
+		err = c.DownloadFileAtomically(pathLocal, remoteJoin(dirRemote, name))
+		if err == nil {
+			exp, ok := hashes[name]
+			if !ok {
+				err = fmt.Errorf("not in '%s'", manifestPath)
+			} else {
+				err = verifyFileMatchesManifest(pathLocal, exp)
+			}
+		}
+		check(name, err)
+		if name != installerName || !runInstaller {
+			continue
+		}
+		if err != nil {
+			check("extract "+name, fmt.Errorf("skipped because download failed"))
+			continue
+		}
+		extractDir, err := filepath.Abs(filepath.Join(localDir, "extracted"))
+		if err == nil {
+			err = extractInstaller(pathLocal, extractDir)
+		}
+		check("extract "+name, err)
+	}
+
+	if nFailed > 0 {
+		fmt.Printf("%d checks of version %s of '%s' failed. Downloaded files are in '%s'\n", nFailed, ver, buildType, localDir)
+		return false
+	}
+	removeWorkDir(workDirName)
+	fmt.Printf("Version %s of '%s' is ok\n", ver, buildType)
+	return true
+}
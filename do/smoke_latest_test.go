@@ -0,0 +1,84 @@
+package main
+
+import (
+	"crypto"
+	"crypto/sha512"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseManifestHashes(t *testing.T) {
+	manifest := `ver: 12345
+sha1: 0123456789abcdef0123456789abcdef01234567
+rel32/SumatraPDF.exe: 1000
+sha256 SumatraPDF-prerel-12345.exe: 3 aaaa
+sha512 SumatraPDF-prerel-12345.exe: 3 bbbb
+sha1 SumatraPDF-prerel-12345-64.exe: 4 cccc
+unzipped SumatraPDF-prerel-12345.zip: 100
+`
+	got, err := parseManifestHashes([]byte(manifest))
+	if err != nil {
+		t.Fatalf("parseManifestHashes() failed with %s", err)
+	}
+	exp := map[string]manifestFileHash{
+		"SumatraPDF-prerel-12345.exe":    {3, map[crypto.Hash]string{crypto.SHA256: "aaaa", crypto.SHA512: "bbbb"}},
+		"SumatraPDF-prerel-12345-64.exe": {4, map[crypto.Hash]string{crypto.SHA1: "cccc"}},
+	}
+	if !reflect.DeepEqual(got, exp) {
+		t.Errorf("parseManifestHashes() = %v, expected %v", got, exp)
+	}
+
+	invalid := []string{
+		"sha256 SumatraPDF.exe 3 aaaa",
+		"sha256 SumatraPDF.exe: 3",
+		"sha256 SumatraPDF.exe: x aaaa",
+		"sha256 SumatraPDF.exe: 3 aaaa\nsha512 SumatraPDF.exe: 4 bbbb",
+	}
+	for _, s := range invalid {
+		if _, err := parseManifestHashes([]byte(s)); err == nil {
+			t.Errorf("no error parsing '%s'", s)
+		}
+	}
+}
+
+// builds uploaded with e.g. -checksums sha512 have no sha256 lines
+// in the manifest
+func TestSmokeTestLatestSha512(t *testing.T) {
+	wd, err := os.Getwd()
+	must(err)
+	must(os.Chdir(t.TempDir()))
+	defer func() { must(os.Chdir(wd)) }()
+
+	c := newFakeStorage()
+	if smokeTestLatest(c, buildTypePreRel, false) {
+		t.Errorf("smoke test passed without '%s'", getRemotePaths(buildTypePreRel)[1])
+	}
+	ver := "12345"
+	prefix := getAppNameForBuildType(buildTypePreRel) + "-" + ver
+	lines := []string{"ver: " + ver}
+	for _, name := range []string{prefix + "-64-install.exe", prefix + "-64.zip"} {
+		d := []byte("content of " + name)
+		c.put(remoteJoin(getRemoteDir(buildTypePreRel), name), d)
+		lines = append(lines, fmt.Sprintf("sha512 %s: %d %x", name, len(d), sha512.Sum512(d)))
+	}
+	manifestPath := getManifestRemotePath(buildTypePreRel, ver)
+	c.put(manifestPath, []byte(strings.Join(lines, "\n")))
+	c.put(getRemotePaths(buildTypePreRel)[1], []byte(ver))
+	if !smokeTestLatest(c, buildTypePreRel, false) {
+		t.Errorf("smoke test failed for build with sha512 hashes")
+	}
+
+	// a corrupted file must be detected with sha512 only
+	name := prefix + "-64.zip"
+	c.put(remoteJoin(getRemoteDir(buildTypePreRel), name), []byte("content of "+name[:len(name)-1]+"Z"))
+	if smokeTestLatest(c, buildTypePreRel, false) {
+		t.Errorf("smoke test passed with corrupted '%s'", name)
+	}
+	if _, err := os.Stat(filepath.Join("out", "work")); err != nil {
+		t.Errorf("downloaded files of failed smoke test were not kept")
+	}
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// with -upload-symbols, .pdb files from *.pdb.zip are also uploaded in
+// symbol server layout: symbols/${name}.pdb/${key}/${name}.pdb where
+// key is pdb guid and age (see getPdbSymbolKey()). That way debuggers
+// and crash symbolication tools can fetch them by guid
+func getSymbolsRemoteDir() string {
+	return remoteJoin(remoteRoot, "symbols")
+}
+
+func getSymbolRemotePath(name string, key string) string {
+	return remoteJoin(getSymbolsRemoteDir(), name, key, name)
+}
+
+// pdb files are msf 7.0 files, see
+// https://llvm.org/docs/PDB/MsfFile.html
+var msfMagic = []byte("Microsoft C/C++ MSF 7.00\r\n\x1aDS\x00\x00\x00")
+
+const (
+	pdbStreamInfo = 1
+	pdbStreamDbi  = 3
+	// size of nil streams in stream directory
+	msfNilStreamSize = 0xffffffff
+)
+
+// msfFile gives access to streams of a pdb file
+type msfFile struct {
+	d         []byte
+	blockSize uint32
+	// sizes and blocks of each stream
+	streamSizes  []uint32
+	streamBlocks [][]uint32
+}
+
+func (f *msfFile) block(n uint32) ([]byte, error) {
+	start := uint64(n) * uint64(f.blockSize)
+	end := start + uint64(f.blockSize)
+	if end > uint64(len(f.d)) {
+		return nil, fmt.Errorf("block %d is past the end of file", n)
+	}
+	return f.d[start:end], nil
+}
+
+// returns content of blocks, truncated to size
+func (f *msfFile) readBlocks(blocks []uint32, size uint32) ([]byte, error) {
+	var res []byte
+	for _, n := range blocks {
+		b, err := f.block(n)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, b...)
+	}
+	if uint32(len(res)) < size {
+		return nil, fmt.Errorf("%d blocks have less than %d bytes", len(blocks), size)
+	}
+	return res[:size], nil
+}
+
+func msfNumBlocks(size uint32, blockSize uint32) uint32 {
+	return (size + blockSize - 1) / blockSize
+}
+
+func parseMsf(d []byte) (*msfFile, error) {
+	if len(d) < len(msfMagic)+24 || !bytes.Equal(d[:len(msfMagic)], msfMagic) {
+		return nil, fmt.Errorf("not a pdb file")
+	}
+	le := binary.LittleEndian
+	hdr := d[len(msfMagic):]
+	f := &msfFile{
+		d:         d,
+		blockSize: le.Uint32(hdr[0:]),
+	}
+	switch f.blockSize {
+	case 512, 1024, 2048, 4096:
+	default:
+		return nil, fmt.Errorf("invalid block size %d", f.blockSize)
+	}
+	nDirBytes := le.Uint32(hdr[12:])
+	blockMapAddr := le.Uint32(hdr[20:])
+
+	// block map is a list of blocks of stream directory
+	blockMap, err := f.block(blockMapAddr)
+	if err != nil {
+		return nil, err
+	}
+	nDirBlocks := msfNumBlocks(nDirBytes, f.blockSize)
+	if uint64(nDirBlocks)*4 > uint64(len(blockMap)) {
+		return nil, fmt.Errorf("stream directory of %d bytes is too big", nDirBytes)
+	}
+	var dirBlocks []uint32
+	for i := uint32(0); i < nDirBlocks; i++ {
+		dirBlocks = append(dirBlocks, le.Uint32(blockMap[i*4:]))
+	}
+	dir, err := f.readBlocks(dirBlocks, nDirBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	// stream directory is: number of streams, size of each stream,
+	// blocks of each stream
+	readU32 := func() (uint32, error) {
+		if len(dir) < 4 {
+			return 0, fmt.Errorf("stream directory is truncated")
+		}
+		v := le.Uint32(dir)
+		dir = dir[4:]
+		return v, nil
+	}
+	nStreams, err := readU32()
+	if err != nil {
+		return nil, err
+	}
+	if uint64(nStreams)*4 > uint64(len(dir)) {
+		return nil, fmt.Errorf("invalid number of streams %d", nStreams)
+	}
+	for i := uint32(0); i < nStreams; i++ {
+		size, _ := readU32()
+		if size == msfNilStreamSize {
+			size = 0
+		}
+		f.streamSizes = append(f.streamSizes, size)
+	}
+	for _, size := range f.streamSizes {
+		var blocks []uint32
+		for i := uint32(0); i < msfNumBlocks(size, f.blockSize); i++ {
+			n, err := readU32()
+			if err != nil {
+				return nil, err
+			}
+			blocks = append(blocks, n)
+		}
+		f.streamBlocks = append(f.streamBlocks, blocks)
+	}
+	return f, nil
+}
+
+func (f *msfFile) stream(n int) ([]byte, error) {
+	if n >= len(f.streamSizes) {
+		return nil, fmt.Errorf("no stream %d", n)
+	}
+	return f.readBlocks(f.streamBlocks[n], f.streamSizes[n])
+}
+
+// returns key of pdb file in symbol server layout: guid (from pdb info
+// stream) followed by age, in hex e.g. "5F7E0B1A8C2D4E6F9A0B1C2D3E4F5A6B1".
+// Age is from dbi stream, if present, as that's the age debuggers match
+// with the age in the executable
+func getPdbSymbolKey(d []byte) (string, error) {
+	f, err := parseMsf(d)
+	if err != nil {
+		return "", err
+	}
+	info, err := f.stream(pdbStreamInfo)
+	if err != nil {
+		return "", err
+	}
+	// version, signature, age, guid
+	if len(info) < 28 {
+		return "", fmt.Errorf("pdb info stream is too small (%d bytes)", len(info))
+	}
+	le := binary.LittleEndian
+	age := le.Uint32(info[8:])
+	guid := info[12:28]
+	// dbi stream starts with version signature, version and age. If it's
+	// there but we can't read it, we'd silently use a wrong age
+	if pdbStreamDbi < len(f.streamSizes) && f.streamSizes[pdbStreamDbi] > 0 {
+		dbi, err := f.stream(pdbStreamDbi)
+		if err != nil {
+			return "", err
+		}
+		if len(dbi) < 12 {
+			return "", fmt.Errorf("pdb dbi stream is too small (%d bytes)", len(dbi))
+		}
+		age = le.Uint32(dbi[8:])
+	}
+	key := fmt.Sprintf("%08X%04X%04X%X%X", le.Uint32(guid[0:]), le.Uint16(guid[4:]), le.Uint16(guid[6:]), guid[8:10], guid[10:16])
+	return key + fmt.Sprintf("%X", age), nil
+}
+
+// uploads .pdb files from *.pdb.zip files in dirLocal in symbol server
+// layout (see getSymbolsRemoteDir()). Symbols already in storage are
+// skipped: the same key means the same pdb
+func minioUploadSymbols(c minioStorage, dirLocal string) error {
+	files, err := ioutil.ReadDir(dirLocal)
+	if err != nil {
+		return err
+	}
+	nUploaded := 0
+	for _, f := range files {
+		name := f.Name()
+		if f.IsDir() || isIgnoredUploadFile(name) || !strings.HasSuffix(name, ".pdb.zip") {
+			continue
+		}
+		zipPath := filepath.Join(dirLocal, name)
+		zr, err := zip.OpenReader(zipPath)
+		if err != nil {
+			return err
+		}
+		n, err := minioUploadSymbolsFromZip(c, &zr.Reader)
+		zr.Close()
+		if err != nil {
+			return fmt.Errorf("failed to upload symbols from '%s', err: %s", zipPath, err)
+		}
+		nUploaded += n
+	}
+	logf("Uploaded %d symbol files to '%s'\n", nUploaded, getSymbolsRemoteDir())
+	return nil
+}
+
+func minioUploadSymbolsFromZip(c minioStorage, zr *zip.Reader) (int, error) {
+	nUploaded := 0
+	for _, zf := range zr.File {
+		name := filepath.Base(zf.Name)
+		if !strings.HasSuffix(strings.ToLower(name), ".pdb") {
+			continue
+		}
+		r, err := zf.Open()
+		if err != nil {
+			return nUploaded, err
+		}
+		d, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil {
+			return nUploaded, err
+		}
+		key, err := getPdbSymbolKey(d)
+		if err != nil {
+			return nUploaded, fmt.Errorf("'%s': %s", zf.Name, err)
+		}
+		remotePath := getSymbolRemotePath(name, key)
+		if _, err := c.StatObject(remotePath); err == nil {
+			logf("Skipping uploading '%s', already exists\n", remotePath)
+			continue
+		}
+		err = minioUploadData(c, remotePath, d, false)
+		if err != nil {
+			return nUploaded, err
+		}
+		logf("Uploaded '%s' as '%s'\n", zf.Name, remotePath)
+		nUploaded++
+	}
+	return nUploaded, nil
+}
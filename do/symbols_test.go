@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+const testMsfBlockSize = 512
+
+// guid of test pdb, as in the pdb info stream, and its symbol key without age
+var (
+	testPdbGUID = []byte{
+		0x1a, 0x0b, 0x7e, 0x5f, // data1, little endian
+		0x2d, 0x8c, // data2
+		0x6f, 0x4e, // data3
+		0x9a, 0x0b, 0x1c, 0x2d, 0x3e, 0x4f, 0x5a, 0x6b, // data4
+	}
+	testPdbGUIDKey = "5F7E0B1A8C2D4E6F9A0B1C2D3E4F5A6B"
+)
+
+// a stream of a synthesized msf file and the blocks it's stored in
+type testMsfStream struct {
+	d      []byte
+	blocks []uint32
+}
+
+// builds an msf file with 512 byte blocks: superblock in block 0, block
+// map in block 1, stream directory in block 2 and streams in blocks
+// given in streams. nil streams have size 0xffffffff
+func buildTestMsf(nBlocks int, streams []*testMsfStream) []byte {
+	le := binary.LittleEndian
+	d := make([]byte, nBlocks*testMsfBlockSize)
+	block := func(n uint32) []byte {
+		return d[n*testMsfBlockSize : (n+1)*testMsfBlockSize]
+	}
+	var dir []byte
+	u32 := func(v uint32) {
+		dir = le.AppendUint32(dir, v)
+	}
+	u32(uint32(len(streams)))
+	for _, s := range streams {
+		if s == nil {
+			u32(msfNilStreamSize)
+		} else {
+			u32(uint32(len(s.d)))
+		}
+	}
+	for _, s := range streams {
+		if s == nil {
+			continue
+		}
+		for i, n := range s.blocks {
+			u32(n)
+			rest := s.d[i*testMsfBlockSize:]
+			copy(block(n), rest)
+		}
+	}
+
+	hdr := block(0)
+	copy(hdr, msfMagic)
+	sb := hdr[len(msfMagic):]
+	le.PutUint32(sb[0:], testMsfBlockSize)
+	le.PutUint32(sb[8:], uint32(nBlocks))
+	le.PutUint32(sb[12:], uint32(len(dir)))
+	le.PutUint32(sb[20:], 1)
+	le.PutUint32(block(1), 2)
+	copy(block(2), dir)
+	return d
+}
+
+func testPdbInfoStream(age uint32) []byte {
+	le := binary.LittleEndian
+	var d []byte
+	d = le.AppendUint32(d, 20000404)
+	d = le.AppendUint32(d, 0x5f7e0b1a)
+	d = le.AppendUint32(d, age)
+	return append(d, testPdbGUID...)
+}
+
+// dbi stream of size bytes that spans several blocks
+func testPdbDbiStream(age uint32, size int) []byte {
+	le := binary.LittleEndian
+	d := make([]byte, size)
+	le.PutUint32(d[0:], 0xffffffff)
+	le.PutUint32(d[4:], 19990903)
+	le.PutUint32(d[8:], age)
+	return d
+}
+
+func TestGetPdbSymbolKey(t *testing.T) {
+	info := &testMsfStream{testPdbInfoStream(1), []uint32{3}}
+	// out of order blocks, age is in the first one
+	dbi := &testMsfStream{testPdbDbiStream(5, 600), []uint32{5, 4}}
+	tests := []struct {
+		what    string
+		streams []*testMsfStream
+		exp     string
+	}{
+		{"age from dbi stream", []*testMsfStream{nil, info, nil, dbi}, testPdbGUIDKey + "5"},
+		{"no dbi stream", []*testMsfStream{nil, info}, testPdbGUIDKey + "1"},
+		{"nil dbi stream", []*testMsfStream{nil, info, nil, nil}, testPdbGUIDKey + "1"},
+	}
+	for _, test := range tests {
+		d := buildTestMsf(6, test.streams)
+		f, err := parseMsf(d)
+		if err != nil {
+			t.Fatalf("%s: parseMsf() failed with %s", test.what, err)
+		}
+		if f.blockSize != testMsfBlockSize || len(f.streamSizes) != len(test.streams) {
+			t.Errorf("%s: block size %d and %d streams, expected %d and %d", test.what, f.blockSize, len(f.streamSizes), testMsfBlockSize, len(test.streams))
+		}
+		got, err := getPdbSymbolKey(d)
+		if err != nil {
+			t.Fatalf("%s: getPdbSymbolKey() failed with %s", test.what, err)
+		}
+		if got != test.exp {
+			t.Errorf("%s: getPdbSymbolKey() = '%s', expected '%s'", test.what, got, test.exp)
+		}
+	}
+}
+
+func TestGetPdbSymbolKeyInvalid(t *testing.T) {
+	info := &testMsfStream{testPdbInfoStream(1), []uint32{3}}
+	dbi := &testMsfStream{testPdbDbiStream(5, 600), []uint32{5, 4}}
+	valid := buildTestMsf(6, []*testMsfStream{nil, info, nil, dbi})
+	le := binary.LittleEndian
+	modified := func(fn func(d []byte)) []byte {
+		d := append([]byte(nil), valid...)
+		fn(d)
+		return d
+	}
+	tests := []struct {
+		what string
+		d    []byte
+		exp  string
+	}{
+		{"too short for superblock", valid[:len(msfMagic)+10], "not a pdb file"},
+		{"bad magic", modified(func(d []byte) { d[0] = 'X' }), "not a pdb file"},
+		{"bad block size", modified(func(d []byte) { le.PutUint32(d[len(msfMagic):], 1000) }), "invalid block size 1000"},
+		{"block map past the end", valid[:testMsfBlockSize+100], "past the end"},
+		{"directory past the end", valid[:2*testMsfBlockSize+100], "past the end"},
+		{"directory too big for block map", modified(func(d []byte) { le.PutUint32(d[len(msfMagic)+12:], 1000*testMsfBlockSize) }), "too big"},
+		{"truncated directory", modified(func(d []byte) { le.PutUint32(d[len(msfMagic)+12:], 8) }), "invalid number of streams"},
+		{"truncated stream blocks in directory", modified(func(d []byte) { le.PutUint32(d[len(msfMagic)+12:], 4+4*4+4) }), "truncated"},
+		{"info stream past the end", valid[:3*testMsfBlockSize+10], "past the end"},
+		{"dbi stream past the end", valid[:5*testMsfBlockSize], "past the end"},
+		{"no info stream", buildTestMsf(6, []*testMsfStream{nil}), "no stream 1"},
+		{"info stream too small", buildTestMsf(6, []*testMsfStream{nil, {make([]byte, 20), []uint32{3}}}), "info stream is too small"},
+		{"dbi stream too small", buildTestMsf(6, []*testMsfStream{nil, info, nil, {make([]byte, 8), []uint32{4}}}), "dbi stream is too small"},
+	}
+	for _, test := range tests {
+		_, err := getPdbSymbolKey(test.d)
+		if err == nil || !strings.Contains(err.Error(), test.exp) {
+			t.Errorf("%s: got error '%v', expected '%s'", test.what, err, test.exp)
+		}
+	}
+}
@@ -2,10 +2,14 @@ package main
 
 import (
 	"fmt"
+	"io/ioutil"
 	"path/filepath"
+	"regexp"
+	"runtime"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/kjk/u"
 )
@@ -101,9 +105,30 @@ func removeLang(langs []*Lang, lang *Lang) []*Lang {
 }
 
 func buildTransForLangs(langs []*Lang, stringsDict map[string][]*Translation, keys []string) []*Lang {
+	return buildTransForLangsN(langs, stringsDict, keys, runtime.NumCPU())
+}
+
+// like buildTransForLangs() but calculates at most nWorkers languages
+// at a time. With nWorkers of 1 it's serial
+func buildTransForLangsN(langs []*Lang, stringsDict map[string][]*Translation, keys []string, nWorkers int) []*Lang {
+	// languages are independent so we calculate them in parallel.
+	// stringsDict and keys are only read
+	sem := make(chan bool, nWorkers)
+	var wg sync.WaitGroup
+	for _, lang := range langs {
+		sem <- true
+		wg.Add(1)
+		go func(lang *Lang) {
+			lang.translations = getTransForLang(stringsDict, keys, lang.code)
+			wg.Done()
+			<-sem
+		}(lang)
+	}
+	wg.Wait()
+
+	// in the order of langs so that the result is the same as serial
 	gIncompleteLangs = nil
 	for _, lang := range langs {
-		lang.translations = getTransForLang(stringsDict, keys, lang.code)
 		if len(lang.translations) == 0 {
 			gIncompleteLangs = append(gIncompleteLangs, lang)
 		}
@@ -277,6 +302,141 @@ func printIncompleteLangs(dirName string) {
 	logf("\nIncomplete langs in %s: %s %s", fileNameFromDirName(dirName), count, langs)
 }
 
+// matches a language code in gLangCodes e.g. "sq\0"
+var langCodeRx = regexp.MustCompile(`"([^"\\]+)\\0"`)
+
+// returns language codes in gLangCodes of a previously generated
+// Trans_*_txt.cpp
+func parseGeneratedLangCodes(d []byte) []string {
+	var res []string
+	inLangCodes := false
+	for _, l := range toTrimmedLines(d) {
+		if strings.HasPrefix(l, "const char *gLangCodes =") {
+			inLangCodes = true
+		}
+		if !inLangCodes {
+			continue
+		}
+		for _, m := range langCodeRx.FindAllStringSubmatch(l, -1) {
+			res = append(res, m[1])
+		}
+		if strings.HasSuffix(l, ";") {
+			break
+		}
+	}
+	return res
+}
+
+// returns languages in curr but not in prev and languages in prev but
+// not in curr
+func diffLangCodes(prev []string, curr []string) ([]string, []string) {
+	inPrev := map[string]bool{}
+	for _, code := range prev {
+		inPrev[code] = true
+	}
+	inCurr := map[string]bool{}
+	var added []string
+	for _, code := range curr {
+		inCurr[code] = true
+		if !inPrev[code] {
+			added = append(added, code)
+		}
+	}
+	var dropped []string
+	for _, code := range prev {
+		if !inCurr[code] {
+			dropped = append(dropped, code)
+		}
+	}
+	return added, dropped
+}
+
+// a language crossing incompleteMissingThreshold either way changes
+// which languages we ship. Dropping one should be noticed in review
+// so we call it out
+func printLangsDiff(path string, langs []*Lang) {
+	d, err := ioutil.ReadFile(path)
+	if err != nil {
+		logf("'%s' doesn't exist, not comparing languages\n", path)
+		return
+	}
+	var curr []string
+	for _, lang := range langs {
+		curr = append(curr, lang.code)
+	}
+	added, dropped := diffLangCodes(parseGeneratedLangCodes(d), curr)
+	if len(added) == 0 && len(dropped) == 0 {
+		logf("No languages added or dropped in '%s'\n", path)
+		return
+	}
+	orNone := func(a []string) string {
+		if len(a) == 0 {
+			return "none"
+		}
+		return strings.Join(a, ", ")
+	}
+	logf("\nLanguages in '%s' changed. added languages: %s; dropped languages: %s.\n", path, orNone(added), orNone(dropped))
+}
+
+func langsMetaPath() string {
+	return filepath.Join("strings", "langs-meta.txt")
+}
+
+// splits name of a language from gLangs e.g. "Arabic (الْعَرَبيّة)" into
+// English and native name. If there's no native name, it's the same
+// as English name
+func parseLangName(name string) (string, string) {
+	idx := strings.Index(name, " (")
+	if idx == -1 || !strings.HasSuffix(name, ")") {
+		return name, name
+	}
+	return name[:idx], name[idx+2 : len(name)-1]
+}
+
+// writes metadata of languages included in generated translations, for
+// language menu of the app. Metadata comes from gLangs so it's in sync
+// with which languages are included
+func saveLangsMeta(langs []*Lang) {
+	var buf strings.Builder
+	buf.WriteString("# generated by -trans-langs-meta from gLangs in do/trans_langs.go\n")
+	buf.WriteString("# code\tEnglish name\tnative name\tdirection\n")
+	for _, lang := range langs {
+		english, native := parseLangName(lang.name)
+		dir := "ltr"
+		if lang.isRtl {
+			dir = "rtl"
+		}
+		fmt.Fprintf(&buf, "%s\t%s\t%s\t%s\n", lang.code, english, native, dir)
+	}
+	path := langsMetaPath()
+	u.WriteFileMust(path, []byte(buf.String()))
+	logf("Wrote metadata of %d languages to '%s'\n", len(langs), path)
+}
+
+// translations for languages not in gLangs are not included in generated
+// code, so they need entries in do/trans_langs.go
+func printLangsWithoutMeta(stringsDict map[string][]*Translation) {
+	known := map[string]bool{}
+	for _, lang := range gLangs {
+		known[lang[0]] = true
+	}
+	seen := map[string]bool{}
+	var missing []string
+	for _, translations := range stringsDict {
+		for _, t := range translations {
+			if !known[t.Lang] && !seen[t.Lang] {
+				seen[t.Lang] = true
+				missing = append(missing, t.Lang)
+			}
+		}
+	}
+	if len(missing) == 0 {
+		return
+	}
+	sort.Strings(missing)
+	logf("Languages with translations that need an entry in gLangs in do/trans_langs.go: %s\n", strings.Join(missing, ", "))
+}
+
 func genCCodeForDir(stringsDict map[string][]*Translation, keys []string, dirName string) {
 	logf("gen_c_code_for_dir: '%s', %d strings, len(strings_dict): %d\n", dirName, len(keys), len(stringsDict))
 
@@ -296,6 +456,10 @@ func genCCodeForDir(stringsDict map[string][]*Translation, keys []string, dirNam
 
 	langs = buildTransForLangs(langs, stringsDict, keys)
 	logf("langs: %d, g_langs: %d\n", len(langs), len(gLangs))
+	if flgTransLangsMeta {
+		saveLangsMeta(langs)
+		printLangsWithoutMeta(stringsDict)
+	}
 
 	var a []string
 	for _, lang := range langs {
@@ -395,6 +559,7 @@ func genCCodeForDir(stringsDict map[string][]*Translation, keys []string, dirNam
 	path := filepath.Join(dirName, fileNameFromDirName(dirName))
 	fileContent := evalTmpl(compactCTmpl, v2)
 	logf("file_content: path: %s, file size: %d\n", path, len(fileContent))
+	printLangsDiff(path, langs)
 	u.WriteFileMust(path, []byte(fileContent))
 	printIncompleteLangs(dirName)
 	// print_stats(langs)
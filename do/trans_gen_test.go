@@ -0,0 +1,65 @@
+package main
+
+import (
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/kjk/u"
+)
+
+// strings and translations from strings/translations.txt, a realistic
+// corpus of ~300 strings in ~70 languages
+func loadTestTranslations(t testing.TB) (map[string][]*Translation, []string) {
+	d := u.ReadFileMust(filepath.Join("..", "strings", "translations.txt"))
+	stringsDict := parseTranslations(string(d))
+	var keys []string
+	for k := range stringsDict {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	if len(keys) == 0 {
+		t.Fatal("no strings in translations.txt")
+	}
+	return stringsDict, keys
+}
+
+// returns generated C code for translations and codes of incomplete
+// languages, built with nWorkers
+func genTransWithWorkers(stringsDict map[string][]*Translation, keys []string, nWorkers int) string {
+	langs := getLangObjects(gLangs)
+	langs = buildTransForLangsN(langs, stringsDict, keys, nWorkers)
+	buildTranslations(langs)
+	var incomplete []string
+	for _, lang := range gIncompleteLangs {
+		incomplete = append(incomplete, lang.code)
+	}
+	return genTranslations(langs) + "\nincomplete: " + strings.Join(incomplete, ",")
+}
+
+func TestBuildTransForLangsParallelMatchesSerial(t *testing.T) {
+	stringsDict, keys := loadTestTranslations(t)
+	serial := genTransWithWorkers(stringsDict, keys, 1)
+	for _, n := range []int{2, 4, 16} {
+		parallel := genTransWithWorkers(stringsDict, keys, n)
+		if parallel != serial {
+			t.Errorf("output with %d workers differs from serial output", n)
+		}
+	}
+}
+
+func BenchmarkBuildTransForLangs(b *testing.B) {
+	stringsDict, keys := loadTestTranslations(b)
+	bench := func(nWorkers int) func(b *testing.B) {
+		return func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				langs := getLangObjects(gLangs)
+				buildTransForLangsN(langs, stringsDict, keys, nWorkers)
+			}
+		}
+	}
+	b.Run("serial", bench(1))
+	b.Run("parallel", bench(runtime.NumCPU()))
+}
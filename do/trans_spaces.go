@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+
+	"github.com/kjk/u"
+)
+
+// translations are uploaded as translations/${sha1}.txt.gz, where sha1 is
+// of uncompressed content, so that builds can refer to a given version
+func getTranslationsRemotePath(sha1 string) string {
+	return remoteJoin(remoteRoot, "translations", sha1+".txt.gz")
+}
+
+// contains sha1 of the most recently uploaded translations
+func getLatestTranslationsRemotePath() string {
+	return remoteJoin(remoteRoot, "translations", "latest.txt")
+}
+
+// no file name or modification time in the header so that the same
+// content always compresses to the same bytes
+func gzipData(d []byte) []byte {
+	var buf bytes.Buffer
+	w, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	must(err)
+	_, err = w.Write(d)
+	must(err)
+	must(w.Close())
+	return buf.Bytes()
+}
+
+// uploads strings/translations.txt to spaces, unless already uploaded,
+// and updates the "latest translations" pointer
+func minioUploadTranslations(c minioStorage) error {
+	d := u.ReadFileMust(translationsPath())
+	sha1 := u.Sha1HexOfBytes(d)
+	remotePath := getTranslationsRemotePath(sha1)
+	if minioExists(c, remotePath) {
+		logf("Translations already uploaded as '%s'\n", remotePath)
+	} else {
+		err := minioUploadData(c, remotePath, gzipData(d), false)
+		if err != nil {
+			return fmt.Errorf("failed to upload translations as '%s', err: %s", remotePath, err)
+		}
+		logf("Uploaded translations to spaces as '%s'\n", remotePath)
+	}
+	return minioUploadVersionFile(c, getLatestTranslationsRemotePath(), []byte(sha1))
+}
+
+func minioUploadTranslationsMust() {
+	fatalIf(!hasSpacesCreds(), "-trans-upload-spaces needs SPACES_KEY and SPACES_SECRET env variables\n")
+	err := minioUploadTranslations(newMinioStorage())
+	must(err)
+}
@@ -20,7 +20,29 @@ func readFile(path string) string {
 	return string(d)
 }
 
-func uploadStringsToServer(strs string, secret string) {
+// splits strings to upload into parts uploaded with separate requests.
+// apptranslator.org replaces all strings of the app on each upload
+// so for now it must be a single part. If the server learns to accept
+// partial uploads, this is where we would split large uploads
+func getStringsUploadParts(strs string) []string {
+	return []string{strs}
+}
+
+// each part is retried on its own. The server replaces the strings on
+// upload so re-uploading a part is harmless
+func uploadStringsToServer(strs string, secret string) error {
+	for _, part := range getStringsUploadParts(strs) {
+		err := retry("uploading strings", flgTransRetries, func() error {
+			return uploadStringsPartToServer(part, secret)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func uploadStringsPartToServer(strs string, secret string) error {
 	fmt.Printf("Uploading strings to the server...\n")
 	uri := fmt.Sprintf("%s/uploadstrings", translationServer)
 
@@ -29,19 +51,32 @@ func uploadStringsToServer(strs string, secret string) {
 	data.Set("app", "SumatraPDF")
 	data.Set("secret", secret)
 	dataStr := data.Encode()
+	size := int64(len(dataStr))
+	logf("Upload size: %s\n", u.FmtSizeHuman(size))
+	if flgTransUploadWarnKB > 0 && size > int64(flgTransUploadWarnKB)*1024 {
+		logf("Warning: upload of strings is %s, more than -trans-upload-warn-kb of %d kB. The server might reject it\n", u.FmtSizeHuman(size), flgTransUploadWarnKB)
+	}
 	r := strings.NewReader(dataStr)
 	req, err := http.NewRequest(http.MethodPost, uri, r)
 	must(err)
 	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
 	req.Header.Add("Accept", "text/plain")
 	req.Header.Add("Content-Length", strconv.Itoa(len(dataStr)))
-	rsp, err := http.DefaultClient.Do(req)
-	must(err)
+	rsp, err := getHTTPClient().Do(req)
+	if err != nil {
+		return err
+	}
 	defer rsp.Body.Close()
-	u.PanicIf(rsp.StatusCode != http.StatusOK)
+	if rsp.StatusCode != http.StatusOK {
+		return fmt.Errorf("upload returned %s", rsp.Status)
+	}
 	d, err := ioutil.ReadAll(rsp.Body)
+	if err != nil {
+		return err
+	}
 	fmt.Printf("Response:\n%s\n", string(d))
 	fmt.Printf("Upload finished\n")
+	return nil
 }
 
 func getTransSecret() string {
@@ -50,12 +85,16 @@ func getTransSecret() string {
 	return v
 }
 
+// strings/last_uploaded.txt is only updated after a successful upload so
+// unchanged strings are never re-uploaded. apptranslator.org doesn't
+// support upload tokens so that's the best we can do
 func uploadStringsIfChanged() {
 	path := filepath.Join("strings", "last_uploaded.txt")
 	// needs to have upload secret to protect apptranslator.org server from abuse
 	// TODO: we used to have a check if svn is up-to-date
 	// should we restore it for git?
 	a1 := extractStringsFromCFiles()
+	reportDuplicateSourceStrings(a1)
 	a := extractJustStrings(a1)
 	sort.Strings(a)
 	s := "AppTranslator strings\n" + strings.Join(a, "\n")
@@ -64,7 +103,8 @@ func uploadStringsIfChanged() {
 		return
 	}
 	uploadsecret := getTransSecret()
-	uploadStringsToServer(s, uploadsecret)
+	err := uploadStringsToServer(s, uploadsecret)
+	must(err)
 	u.WriteFileMust(path, []byte(s))
 	logf("Don't forget to checkin strings/last_uploaded.txt\n")
 }
@@ -1,17 +1,39 @@
 package main
 
 import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
 	"fmt"
+	"go/format"
 	"io/ioutil"
+	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/kjk/u"
 )
 
 const translationServer = "http://www.apptranslator.org"
 
+// translations.txt starts with translationsHeader line followed by
+// a line with sha1 of translations. parseTranslations() skips them
+const (
+	translationsHeader       = "AppTranslator: SumatraPDF"
+	nTranslationsHeaderLines = 2
+)
+
+// writes header lines of translations.txt format
+func writeTranslationsHeader(buf *strings.Builder, sha1 string) {
+	buf.WriteString(translationsHeader + "\n")
+	buf.WriteString(sha1 + "\n")
+}
+
 func translationsPath() string {
 	return filepath.Join("strings", "translations.txt")
 }
@@ -78,6 +100,9 @@ func saveLastDownload(d []byte) {
 	u.WriteFileMust(path, d)
 }
 
+// download is independent of uploading strings (see uploadStringsIfChanged())
+// and only depends on sha1 of the last download, so it can be retried
+// without re-uploading
 func downloadTranslations() []byte {
 	logf("Downloading translations from the server...\n")
 
@@ -88,7 +113,15 @@ func downloadTranslations() []byte {
 	// SERVER = "10.37.129.2"    // mac pro
 	// PORT = 5000
 	uri := fmt.Sprintf("http://www.apptranslator.org/dltrans?app=%s&sha1=%s", app, sha1)
-	d := httpDlMust(uri)
+	timeStart := time.Now()
+	var d []byte
+	err := retry("downloading translations", flgTransRetries, func() error {
+		var err error
+		d, err = httpDl(uri)
+		return err
+	})
+	must(err)
+	gaugeDuration("translations_download_seconds", time.Since(timeStart))
 	return d
 }
 
@@ -111,9 +144,25 @@ func trimEmptyLinesFromEnd(a []string) []string {
 	return a
 }
 
+// parses "${lang}:${translation}" line
+func parseTranslationLine(l string) (string, string, bool) {
+	parts := strings.SplitN(l, ":", 2)
+	if len(parts) != 2 {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
+
+// translations should only have \n line endings. A stray \r would end up
+// in translations in generated code
+func stripCarriageReturns(d []byte) []byte {
+	return bytes.Replace(d, []byte{'\r'}, nil, -1)
+}
+
 func parseTranslations(s string) map[string][]*Translation {
 	res := map[string][]*Translation{}
-	lines := strings.Split(s, "\n")[2:]
+	s = string(stripCarriageReturns([]byte(s)))
+	lines := strings.Split(s, "\n")[nTranslationsHeaderLines:]
 	// strip empty lines from the end
 	lines = trimEmptyLinesFromEnd(lines)
 	currStr := ""
@@ -134,9 +183,8 @@ func parseTranslations(s string) map[string][]*Translation {
 			currStr = l[1:]
 			currTranslations = nil
 		} else {
-			parts := strings.SplitN(l, ":", 2)
-			panicIf(len(parts) != 2, "Invalid line: '%s'", l)
-			lang, trans := parts[0], parts[1]
+			lang, trans, ok := parseTranslationLine(l)
+			panicIf(!ok, "Invalid line: '%s'", l)
 			tr := &Translation{
 				Text:        currStr,
 				Lang:        lang,
@@ -191,6 +239,18 @@ func extractTranslations(s string) []string {
 	return res
 }
 
+// like extractTranslations() but also returns 1-based line number of
+// each string
+func extractTranslationsWithLines(s string) ([]string, []int) {
+	var res []string
+	var lines []int
+	for _, el := range translationPattern.FindAllStringSubmatchIndex(s, -1) {
+		res = append(res, s[el[2]:el[3]])
+		lines = append(lines, strings.Count(s[:el[0]], "\n")+1)
+	}
+	return res, lines
+}
+
 func extractStringsFromCFile(path string) []string {
 	d := u.ReadFileMust(path)
 	return extractTranslations(string(d))
@@ -225,6 +285,8 @@ type stringWithPath struct {
 	Text string
 	Path string
 	Dir  string
+	// 1-based, 0 if not known (e.g. loaded from translations/strings.txt)
+	Line int
 }
 
 func extractStringsFromCFiles() []*stringWithPath {
@@ -232,12 +294,14 @@ func extractStringsFromCFiles() []*stringWithPath {
 	logf("Files to process: %d\n", len(filesToProcess))
 	var res []*stringWithPath
 	for _, path := range filesToProcess {
-		a := extractStringsFromCFile(path)
-		for _, s := range a {
+		d := u.ReadFileMust(path)
+		a, lines := extractTranslationsWithLines(string(d))
+		for i, s := range a {
 			swp := &stringWithPath{
 				Text: s,
 				Path: path,
 				Dir:  filepath.Base(filepath.Dir(path)),
+				Line: lines[i],
 			}
 			res = append(res, swp)
 		}
@@ -246,6 +310,101 @@ func extractStringsFromCFiles() []*stringWithPath {
 	return res
 }
 
+// logs strings that appear more than once in source code, with their
+// locations. We upload each string once so that's not an error but
+// usually a sign that one of them could be removed or re-used.
+// Returns number of such strings
+func reportDuplicateSourceStrings(a []*stringWithPath) int {
+	byText := map[string][]*stringWithPath{}
+	var texts []string
+	for _, swp := range a {
+		if len(byText[swp.Text]) == 0 {
+			texts = append(texts, swp.Text)
+		}
+		byText[swp.Text] = append(byText[swp.Text], swp)
+	}
+	sort.Strings(texts)
+	n := 0
+	for _, s := range texts {
+		dups := byText[s]
+		if len(dups) < 2 {
+			continue
+		}
+		n++
+		var locs []string
+		for _, swp := range dups {
+			loc := filepath.ToSlash(swp.Path)
+			if swp.Line > 0 {
+				loc += ":" + strconv.Itoa(swp.Line)
+			}
+			locs = append(locs, loc)
+		}
+		logf("'%s' appears %d times: %s\n", s, len(dups), strings.Join(locs, ", "))
+	}
+	if n > 0 {
+		logf("%d strings appear more than once in source code\n", n)
+	}
+	return n
+}
+
+func stringsListPath() string {
+	return filepath.Join("translations", "strings.txt")
+}
+
+// saves strings extracted from source code to translations/strings.txt so
+// that they can be used without scanning the source code.
+// Each line is "${path}:${string}". A string used more than once in
+// the same file is saved once so that the file doesn't change when
+// code using it moves around
+func saveStringsList() {
+	var lines []string
+	seen := map[string]bool{}
+	for _, swp := range extractStringsFromCFiles() {
+		l := filepath.ToSlash(swp.Path) + ":" + swp.Text
+		if seen[l] {
+			continue
+		}
+		seen[l] = true
+		lines = append(lines, l)
+	}
+	s := strings.Join(lines, "\n") + "\n"
+	path := stringsListPath()
+	u.WriteFileMust(path, []byte(s))
+	logf("Wrote %d strings to '%s'\n", len(lines), path)
+}
+
+func loadStringsList() []*stringWithPath {
+	path := stringsListPath()
+	var res []*stringWithPath
+	for _, l := range toTrimmedLines(u.ReadFileMust(path)) {
+		if l == "" {
+			continue
+		}
+		parts := strings.SplitN(l, ":", 2)
+		panicIf(len(parts) != 2, "invalid line in '%s': '%s'", path, l)
+		srcPath := filepath.FromSlash(parts[0])
+		swp := &stringWithPath{
+			Text: parts[1],
+			Path: srcPath,
+			Dir:  filepath.Base(filepath.Dir(srcPath)),
+		}
+		res = append(res, swp)
+	}
+	logf("%d strings to translate from '%s'\n", len(res), path)
+	return res
+}
+
+// with -trans-strings-from-file (or TRANS_STRINGS_FROM_FILE env
+// variable) gets strings from translations/strings.txt instead of
+// scanning the source code
+func getStringsToTranslate() []*stringWithPath {
+	fromFile := flgStringsFromFile || os.Getenv("TRANS_STRINGS_FROM_FILE") != ""
+	if fromFile && u.FileExists(stringsListPath()) {
+		return loadStringsList()
+	}
+	return extractStringsFromCFiles()
+}
+
 func extractJustStrings(a []*stringWithPath) []string {
 	var res []string
 	for _, el := range a {
@@ -287,15 +446,8 @@ func getUntranslatedAsList(untranslatedDict map[string]bool) []string {
 	return uniquifyStrings(a)
 }
 
-func generateCode(s string) {
-	fmt.Print("generate_code\n")
-	stringsDict := parseTranslations(s)
-	logf("%d strings\n", len(stringsDict))
-
-	strings := extractStringsFromCFiles()
-	stringsList := extractJustStrings(strings)
-
-	// remove obsolete strings from the server
+// removes strings that are no longer used in the source code
+func removeObsoleteStrings(stringsDict map[string][]*Translation, stringsList []string) {
 	var obsolete []string
 	for s := range stringsDict {
 		if !u.StringInSlice(stringsList, s) {
@@ -306,6 +458,94 @@ func generateCode(s string) {
 	if len(obsolete) > 0 {
 		logf("Removed %d obsolete strings\n", len(obsolete))
 	}
+}
+
+// short strings like "OK" often have translations a few times longer so
+// we compare with at least this many characters
+const minLenForLenRatio = 10
+
+// returns translations, grouped by language, that are more than ratio
+// times longer (in characters) than their strings. It's usually a mistake
+// like an explanation pasted instead of translation and might not fit
+// in the ui
+func findLongTranslations(stringsDict map[string][]*Translation, ratio float64) map[string][]*Translation {
+	res := map[string][]*Translation{}
+	for s, a := range stringsDict {
+		n := utf8.RuneCountInString(s)
+		if n < minLenForLenRatio {
+			n = minLenForLenRatio
+		}
+		for _, tr := range a {
+			if float64(utf8.RuneCountInString(tr.Translation)) > float64(n)*ratio {
+				res[tr.Lang] = append(res[tr.Lang], tr)
+			}
+		}
+	}
+	return res
+}
+
+func reportLongTranslations(byLang map[string][]*Translation, ratio float64) {
+	var langs []string
+	for lang := range byLang {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs)
+	n := 0
+	for _, lang := range langs {
+		a := byLang[lang]
+		sort.Slice(a, func(i, j int) bool {
+			return a[i].Text < a[j].Text
+		})
+		logf("%s: %d translations more than %g times longer than the string:\n", lang, len(a), ratio)
+		for _, tr := range a {
+			logf("  '%s'\n  => '%s'\n", tr.Text, tr.Translation)
+		}
+		n += len(a)
+	}
+	if n > 0 {
+		logf("%d suspiciously long translations in %d languages\n", n, len(langs))
+	}
+}
+
+// removes translations in byLang (see findLongTranslations()) from stringsDict
+func removeTranslations(stringsDict map[string][]*Translation, byLang map[string][]*Translation) {
+	toRemove := map[*Translation]bool{}
+	for _, a := range byLang {
+		for _, tr := range a {
+			toRemove[tr] = true
+		}
+	}
+	for s, a := range stringsDict {
+		var kept []*Translation
+		for _, tr := range a {
+			if !toRemove[tr] {
+				kept = append(kept, tr)
+			}
+		}
+		stringsDict[s] = kept
+	}
+}
+
+// returns translations (from s) of strings used in the code, as they
+// will be in generated code, and those strings
+func getTranslationsForCode(s string) (map[string][]*Translation, []*stringWithPath) {
+	stringsDict := parseTranslations(s)
+	logf("%d strings\n", len(stringsDict))
+
+	strings := getStringsToTranslate()
+	stringsList := extractJustStrings(strings)
+
+	// remove obsolete strings from the server
+	removeObsoleteStrings(stringsDict, stringsList)
+
+	if flgTransMaxLenRatio > 0 {
+		long := findLongTranslations(stringsDict, flgTransMaxLenRatio)
+		reportLongTranslations(long, flgTransMaxLenRatio)
+		if flgTransDropLong && len(long) > 0 {
+			removeTranslations(stringsDict, long)
+			logf("Not including suspiciously long translations in generated code because of -trans-drop-long\n")
+		}
+	}
 
 	untranslatedDict := dumpMissingPerLanguage(stringsList, stringsDict, false)
 	untranslated := getUntranslatedAsList(untranslatedDict)
@@ -318,36 +558,1002 @@ func generateCode(s string) {
 			}
 		}
 	}
+	return stringsDict, strings
+}
+
+func generateCode(s string) {
+	fmt.Print("generate_code\n")
+	stringsDict, strings := getTranslationsForCode(s)
 	genCCode(stringsDict, strings)
 }
 
-func downloadAndUpdateTranslationsIfChanged() bool {
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// returns 1-based line number and byte offset of first invalid
+// utf-8 sequence in d or -1, -1 if d is valid utf-8
+func findInvalidUtf8(d []byte) (int, int) {
+	line := 1
+	for i := 0; i < len(d); {
+		if d[i] == '\n' {
+			line++
+		}
+		r, size := utf8.DecodeRune(d[i:])
+		if r == utf8.RuneError && size == 1 {
+			return line, i
+		}
+		i += size
+	}
+	return -1, -1
+}
+
+// strips utf-8 BOM and verifies that translations are valid utf-8. Otherwise
+// invalid bytes would silently become replacement characters
+func validateTranslationsBytesMust(d []byte) []byte {
+	if bytes.HasPrefix(d, utf8BOM) {
+		logf("Stripping utf-8 BOM from translations\n")
+		d = d[len(utf8BOM):]
+	}
+	if !utf8.Valid(d) {
+		line, off := findInvalidUtf8(d)
+		panicIf(true, "translations are not valid utf-8, line %d, offset %d", line, off)
+	}
+	if n := bytes.Count(d, []byte{'\r'}); n > 0 {
+		logf("Stripping %d carriage returns from translations\n", n)
+		d = stripCarriageReturns(d)
+	}
+	return d
+}
+
+// summary of downloading translations
+type translationsDownloadJSON struct {
+	Changed bool   `json:"changed"`
+	Sha1    string `json:"sha1,omitempty"`
+	// number of strings used in the code
+	NumStrings           int `json:"numStrings"`
+	NumLangsComplete     int `json:"numLangsComplete"`
+	NumLangsIncomplete   int `json:"numLangsIncomplete"`
+	NumLangsUntranslated int `json:"numLangsUntranslated"`
+	NumOverridesApplied  int `json:"numOverridesApplied"`
+	// with -trans-freeze, number of changes not applied because
+	// they're not approved
+	NumChangesNotApproved int `json:"numChangesNotApproved,omitempty"`
+	// tier of each language and if it's translated enough for its tier
+	Coverage []*langCoverageCheckJSON `json:"coverage,omitempty"`
+	// problems found by lintTranslations() in saved translations
+	Errors []string `json:"errors,omitempty"`
+}
+
+// downloaded translations with less than this fraction of strings or
+// translations of the previous download are most likely truncated
+const translationsMinKeptFraction = 0.9
+
+func countTranslations(stringsDict map[string][]*Translation) (int, int) {
+	nTranslations := 0
+	for _, a := range stringsDict {
+		nTranslations += len(a)
+	}
+	return len(stringsDict), nTranslations
+}
+
+// a truncated response (e.g. connection reset mid-stream) can still be
+// 200 OK. Returns an error if s doesn't end with a newline or has much
+// fewer strings or translations than prev (previously downloaded
+// translations, if any) so that we don't save gutted translations.
+// We don't look at the last line: a new string without translations
+// is a valid last entry
+func verifyTranslationsNotTruncated(s string, prev string) error {
+	if !strings.HasSuffix(s, "\n") {
+		return fmt.Errorf("translations don't end with a newline, the response is probably truncated")
+	}
+	if prev == "" || flgTransAllowShrink {
+		return nil
+	}
+	nStrings, nTranslations := countTranslations(parseTranslations(s))
+	nPrevStrings, nPrevTranslations := countTranslations(parseTranslations(prev))
+	if float64(nStrings) < float64(nPrevStrings)*translationsMinKeptFraction || float64(nTranslations) < float64(nPrevTranslations)*translationsMinKeptFraction {
+		return fmt.Errorf("got %d strings and %d translations, previously %d and %d. If that's expected, use -trans-allow-shrink", nStrings, nTranslations, nPrevStrings, nPrevTranslations)
+	}
+	return nil
+}
+
+func downloadAndUpdateTranslationsIfChanged() *translationsDownloadJSON {
+	res := &translationsDownloadJSON{}
 	d := downloadTranslations()
+	d = validateTranslationsBytesMust(d)
 	s := string(d)
 	//logf("Downloaded translations:\n%s\n", s)
 	lines := strings.Split(s, "\n")
 	panicIf(len(lines) < 2, "Bad response, less than 2 lines: '%s'", s)
-	panicIf(lines[0] != "AppTranslator: SumatraPDF", "Bad response, invalid first line: '%s'", lines[0])
+	panicIf(lines[0] != translationsHeader, "Bad response, invalid first line: '%s'", lines[0])
 	sha1 := lines[1]
 	if strings.HasPrefix(sha1, "No change") {
 		logf("skipping because translations haven't changed\n")
-		return false
+		return res
 	}
 	panicIf(!validSha1(sha1), "Bad reponse, invalid sha1 on second line: '%s'", sha1)
 	logf("Translation data size: %d\n", len(s))
-	generateCode(s)
-	saveLastDownload(d)
-	return true
+	prev := ""
+	if u.FileExists(lastDownloadFilePath()) {
+		prev = string(u.ReadFileMust(lastDownloadFilePath()))
+	}
+	err := verifyTranslationsNotTruncated(s, prev)
+	panicIfErr(err)
+	res.Changed = true
+	res.Sha1 = sha1
+	s, res.NumOverridesApplied = applyTranslationOverridesFromFile(s)
+	// the server doesn't guarantee the order of strings and translations.
+	// We save them in a canonical order so that diffs of translations.txt
+	// only show changes of content
+	s = serializeTranslations(sha1, parseTranslations(s))
+	if flgTransFreeze {
+		var nApplied int
+		s, nApplied, res.NumChangesNotApproved = freezeTranslations(s, prev)
+		if nApplied == 0 {
+			logf("No approved changes in translations, %d not approved\n", res.NumChangesNotApproved)
+			res.Changed = false
+			return res
+		}
+		res.Sha1 = lastDownloadHash()
+	}
+	stringsDict, strs := getTranslationsForCode(s)
+	status := getTranslationsStatus(stringsDict, extractJustStrings(strs))
+	// checked before generating code and saving so that a failed check
+	// doesn't change any files and can be re-tried with the next download
+	supported, err := loadSupportedLangs()
+	panicIfErr(err)
+	res.Coverage = checkTranslationsCoverage(status.Langs, supported, flgTransMinSupported, flgTransMinCommunity)
+	err = reportTranslationsCoverage(res.Coverage)
+	panicIfErr(err)
+	fmt.Print("generate_code\n")
+	genCCode(stringsDict, strs)
+	saveLastDownload([]byte(s))
+
+	for _, st := range status.Langs {
+		metrics.Gauge(metricName("translations_coverage_percent", st.Lang), st.PercentDone)
+		res.NumStrings = st.Total
+		switch st.Translated {
+		case st.Total:
+			res.NumLangsComplete++
+		case 0:
+			res.NumLangsUntranslated++
+		default:
+			res.NumLangsIncomplete++
+		}
+	}
+	path := lastDownloadFilePath()
+	for _, issue := range lintTranslations(path) {
+		e := fmt.Sprintf("%s:%d: %s", path, issue.LineNo, issue.Msg)
+		res.Errors = append(res.Errors, e)
+	}
+	return res
 }
 
-func downloadTranslationsMain() {
-	changed := downloadAndUpdateTranslationsIfChanged()
-	if changed {
-		logf("\nNew translations downloaded from the server! Check them in!\n")
+func downloadTranslationsMain(asJSON bool) {
+	res := downloadAndUpdateTranslationsIfChanged()
+	if asJSON {
+		js, err := json.MarshalIndent(res, "", "  ")
+		must(err)
+		fmt.Printf("%s\n", js)
+		return
+	}
+	if !res.Changed {
+		return
 	}
+	logf("%d strings, %d languages fully translated, %d partially, %d not at all, %d overrides applied\n", res.NumStrings, res.NumLangsComplete, res.NumLangsIncomplete, res.NumLangsUntranslated, res.NumOverridesApplied)
+	for _, e := range res.Errors {
+		logf("%s\n", e)
+	}
+	logf("\nNew translations downloaded from the server! Check them in!\n")
 }
 
 func regenerateLangs() {
 	d := u.ReadFileMust(lastDownloadFilePath())
-	generateCode(string(d))
+	s, _ := applyTranslationOverridesFromFile(string(d))
+	generateCode(s)
+}
+
+func translationOverridesPath() string {
+	return filepath.Join("strings", "overrides.txt")
+}
+
+// TranslationOverride is a manual fix of a translation that over-rides
+// what we get from the server
+type TranslationOverride struct {
+	Lang        string
+	Text        string
+	Translation string
+	used        bool
+}
+
+// each line is "${lang}:${string} -> ${translation}"
+// empty lines and lines starting with '#' are ignored
+func parseTranslationOverrides(d []byte) ([]*TranslationOverride, error) {
+	var res []*TranslationOverride
+	for _, l := range strings.Split(string(d), "\n") {
+		l = strings.TrimRight(l, "\r")
+		if len(l) == 0 || l[0] == '#' {
+			continue
+		}
+		lang, rest, ok := parseTranslationLine(l)
+		parts := strings.SplitN(rest, " -> ", 2)
+		if !ok || len(parts) != 2 || lang == "" {
+			return nil, fmt.Errorf("invalid override line '%s', expected '${lang}:${string} -> ${translation}'", l)
+		}
+		o := &TranslationOverride{
+			Lang:        lang,
+			Text:        parts[0],
+			Translation: parts[1],
+		}
+		res = append(res, o)
+	}
+	return res, nil
+}
+
+// applies overrides to translations in the format of translations.txt.
+// Returns overrides that didn't match any string (they're stale)
+func applyTranslationOverrides(s string, overrides []*TranslationOverride) (string, []*TranslationOverride) {
+	findOverride := func(lang string, text string) *TranslationOverride {
+		for _, o := range overrides {
+			if o.Lang == lang && o.Text == text {
+				return o
+			}
+		}
+		return nil
+	}
+	var res []string
+	currStr := ""
+	// adds translations for languages that don't have it on the server
+	addMissing := func() {
+		for _, o := range overrides {
+			if o.Text == currStr && !o.used {
+				res = append(res, o.Lang+":"+o.Translation)
+				o.used = true
+			}
+		}
+	}
+	lines := strings.Split(s, "\n")
+	seen := map[string]bool{}
+	for i, l := range lines {
+		// first 2 lines are header
+		if i < 2 || len(l) == 0 {
+			res = append(res, l)
+			continue
+		}
+		if l[0] == ':' {
+			addMissing()
+			currStr = l[1:]
+			seen[currStr] = true
+			res = append(res, l)
+			continue
+		}
+		lang, _, ok := parseTranslationLine(l)
+		if o := findOverride(lang, currStr); ok && o != nil {
+			l = lang + ":" + o.Translation
+			o.used = true
+		}
+		res = append(res, l)
+	}
+	// add missing for the last string before trailing empty lines
+	n := len(res)
+	for n > 2 && res[n-1] == "" {
+		n--
+	}
+	trailing := append([]string{}, res[n:]...)
+	res = res[:n]
+	addMissing()
+	res = append(res, trailing...)
+
+	var stale []*TranslationOverride
+	for _, o := range overrides {
+		if !seen[o.Text] {
+			stale = append(stale, o)
+		}
+	}
+	return strings.Join(res, "\n"), stale
+}
+
+// applies overrides from strings/overrides.txt, if it exists
+// returns translations with overrides applied and number of applied overrides
+func applyTranslationOverridesFromFile(s string) (string, int) {
+	path := translationOverridesPath()
+	if !u.FileExists(path) {
+		return s, 0
+	}
+	overrides, err := parseTranslationOverrides(u.ReadFileMust(path))
+	panicIfErr(err)
+	s, stale := applyTranslationOverrides(s, overrides)
+	nApplied := len(overrides) - len(stale)
+	logf("Applied %d translation overrides from '%s'\n", nApplied, path)
+	for _, o := range stale {
+		logf("Stale override in '%s', string doesn't exist: '%s:%s'\n", path, o.Lang, o.Text)
+	}
+	return s, nApplied
+}
+
+type langStatusJSON struct {
+	Lang        string  `json:"lang"`
+	Name        string  `json:"name"`
+	Translated  int     `json:"translated"`
+	Total       int     `json:"total"`
+	PercentDone float64 `json:"percentDone"`
+}
+
+type stringStatusJSON struct {
+	Text     string `json:"text"`
+	NumLangs int    `json:"numLangs"`
+}
+
+type translationsStatusJSON struct {
+	Langs []*langStatusJSON `json:"langs"`
+	// strings translated in less than rarelyTranslatedThreshold languages,
+	// least translated first. Those with 0 are most likely new strings
+	RarelyTranslated []*stringStatusJSON `json:"rarelyTranslated"`
+}
+
+const rarelyTranslatedThreshold = 3
+
+// returns completion stats for each language, most complete first
+func getLangsStatus(stringsDict map[string][]*Translation, keys []string) []*langStatusJSON {
+	var res []*langStatusJSON
+	for _, lang := range gLangs {
+		code := lang[0]
+		if code == "en" {
+			continue
+		}
+		n := 0
+		for _, k := range keys {
+			for _, tr := range stringsDict[k] {
+				if tr.Lang == code {
+					n++
+					break
+				}
+			}
+		}
+		st := &langStatusJSON{
+			Lang:       code,
+			Name:       lang[1],
+			Translated: n,
+			Total:      len(keys),
+		}
+		if len(keys) > 0 {
+			st.PercentDone = float64(n) * 100 / float64(len(keys))
+		}
+		res = append(res, st)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Translated != res[j].Translated {
+			return res[i].Translated > res[j].Translated
+		}
+		return res[i].Lang < res[j].Lang
+	})
+	return res
+}
+
+// returns strings translated in less than threshold languages,
+// least translated first
+func getRarelyTranslatedStrings(stringsDict map[string][]*Translation, keys []string, threshold int) []*stringStatusJSON {
+	var res []*stringStatusJSON
+	for _, k := range keys {
+		langs := map[string]bool{}
+		for _, tr := range stringsDict[k] {
+			langs[tr.Lang] = true
+		}
+		if len(langs) >= threshold {
+			continue
+		}
+		st := &stringStatusJSON{
+			Text:     k,
+			NumLangs: len(langs),
+		}
+		res = append(res, st)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].NumLangs != res[j].NumLangs {
+			return res[i].NumLangs < res[j].NumLangs
+		}
+		return res[i].Text < res[j].Text
+	})
+	return res
+}
+
+func getTranslationsStatus(stringsDict map[string][]*Translation, keys []string) *translationsStatusJSON {
+	return &translationsStatusJSON{
+		Langs:            getLangsStatus(stringsDict, keys),
+		RarelyTranslated: getRarelyTranslatedStrings(stringsDict, keys, rarelyTranslatedThreshold),
+	}
+}
+
+// prints how complete are translations for each language and which
+// strings need attention, based on strings/translations.txt and strings
+// in source code
+func printTranslationsStatus(asJSON bool) {
+	d := u.ReadFileMust(lastDownloadFilePath())
+	stringsDict := parseTranslations(string(d))
+	keys := extractJustStrings(getStringsToTranslate())
+	status := getTranslationsStatus(stringsDict, keys)
+	if asJSON {
+		js, err := json.MarshalIndent(status, "", "  ")
+		must(err)
+		fmt.Printf("%s\n", js)
+		return
+	}
+	for _, st := range status.Langs {
+		fmt.Printf("%5s: %4d / %d %5.1f%% %s\n", st.Lang, st.Translated, st.Total, st.PercentDone, st.Name)
+	}
+	fmt.Printf("\nStrings translated in less than %d languages:\n", rarelyTranslatedThreshold)
+	for _, st := range status.RarelyTranslated {
+		fmt.Printf("%2d: %s\n", st.NumLangs, st.Text)
+	}
+	if len(status.RarelyTranslated) == 0 {
+		fmt.Printf("none\n")
+	}
+}
+
+// TranslationIssue describes a problem found in translations.txt
+type TranslationIssue struct {
+	LineNo int    `json:"line_no"` // 1-based
+	Line   string `json:"line"`
+	Msg    string `json:"msg"`
+	// for issues in a translation, its language and the string it translates
+	Lang string `json:"lang,omitempty"`
+	Str  string `json:"str,omitempty"`
+}
+
+var (
+	formatSpecifierPattern = regexp.MustCompile(`%[-+ #0-9.]*[sdufxc]`)
+)
+
+// returns printf-style format specifiers in s, in order
+func extractFormatSpecifiers(s string) []string {
+	s = strings.Replace(s, "%%", "", -1)
+	return formatSpecifierPattern.FindAllString(s, -1)
+}
+
+func isKnownLangCode(code string) bool {
+	for _, lang := range gLangs {
+		if lang[0] == code {
+			return true
+		}
+	}
+	return false
+}
+
+// checks translations.txt for problems without talking to the server
+func lintTranslations(path string) []*TranslationIssue {
+	d := u.ReadFileMust(path)
+	lines := strings.Split(string(d), "\n")
+	var res []*TranslationIssue
+	addIssue := func(lineNo int, line string, format string, args ...interface{}) {
+		issue := &TranslationIssue{
+			LineNo: lineNo,
+			Line:   line,
+			Msg:    fmt.Sprintf(format, args...),
+		}
+		res = append(res, issue)
+	}
+
+	if len(lines) < 2 {
+		addIssue(1, "", "file has less than 2 lines")
+		return res
+	}
+	if lines[0] != translationsHeader {
+		addIssue(1, lines[0], "invalid header")
+	}
+	if !validSha1(lines[1]) {
+		addIssue(2, lines[1], "'%s' doesn't look like sha1", lines[1])
+	}
+
+	currStr := ""
+	for i, l := range lines[nTranslationsHeaderLines:] {
+		lineNo := i + nTranslationsHeaderLines + 1
+		if len(l) == 0 {
+			continue
+		}
+		if strings.TrimRight(l, " \t\r") != l {
+			addIssue(lineNo, l, "trailing whitespace")
+		}
+		if l[0] == ':' {
+			currStr = l[1:]
+			continue
+		}
+		lang, trans, ok := parseTranslationLine(l)
+		if !ok {
+			// most likely a newline embedded in a translation
+			addIssue(lineNo, l, "malformed line, expected '${lang}:${translation}'")
+			continue
+		}
+		if currStr == "" {
+			addIssue(lineNo, l, "translation without a string to translate")
+			continue
+		}
+		nIssues := len(res)
+		// longest lang code is "ca-xv"
+		if len(lang) > 5 || !isKnownLangCode(lang) {
+			addIssue(lineNo, l, "unknown language code '%s'", lang)
+		}
+		if strings.Count(trans, `\n`) != strings.Count(currStr, `\n`) {
+			addIssue(lineNo, l, "number of newlines doesn't match '%s'", currStr)
+		}
+		exp := strings.Join(extractFormatSpecifiers(currStr), " ")
+		got := strings.Join(extractFormatSpecifiers(trans), " ")
+		if exp != got {
+			addIssue(lineNo, l, "format specifiers '%s' don't match '%s' in '%s'", got, exp, currStr)
+		}
+		for _, issue := range res[nIssues:] {
+			issue.Lang = lang
+			issue.Str = currStr
+		}
+	}
+	return res
+}
+
+// writes issues to path so that they can be e.g. attached to a CI build
+// and sent to translators. Format is JSON if path ends with .json
+func writeTranslationIssues(path string, translationsPath string, issues []*TranslationIssue) {
+	var d []byte
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		var err error
+		d, err = json.MarshalIndent(issues, "", "  ")
+		must(err)
+	} else {
+		var buf strings.Builder
+		for _, issue := range issues {
+			fmt.Fprintf(&buf, "%s:%d: %s\n", translationsPath, issue.LineNo, issue.Msg)
+			if issue.Lang != "" {
+				fmt.Fprintf(&buf, "  lang: %s\n  string: %s\n", issue.Lang, issue.Str)
+			}
+			fmt.Fprintf(&buf, "  line: %s\n", issue.Line)
+		}
+		d = []byte(buf.String())
+	}
+	u.WriteFileMust(path, d)
+	logf("Wrote %d issues to '%s'\n", len(issues), path)
+}
+
+// if reportPath is not empty, issues are also written there
+func lintTranslationsMain(reportPath string) {
+	path := translationsPath()
+	issues := lintTranslations(path)
+	for _, issue := range issues {
+		logf("%s:%d: %s\n  %s\n", path, issue.LineNo, issue.Msg, issue.Line)
+	}
+	if reportPath != "" {
+		writeTranslationIssues(reportPath, path, issues)
+	}
+	fatalIf(len(issues) > 0, "found %d issues in '%s'\n", len(issues), path)
+	logf("No issues in '%s'\n", path)
+}
+
+// returns descriptions of translations in subset that are not
+// identical in full i.e. why subset is not a subset of full
+func reconcileTranslations(full, subset map[string][]*Translation) []string {
+	var keys []string
+	for s := range subset {
+		keys = append(keys, s)
+	}
+	sort.Strings(keys)
+	var res []string
+	for _, s := range keys {
+		fullTrans, ok := full[s]
+		if !ok {
+			res = append(res, fmt.Sprintf("string '%s' doesn't exist", s))
+			continue
+		}
+		byLang := map[string]string{}
+		for _, tr := range fullTrans {
+			byLang[tr.Lang] = tr.Translation
+		}
+		for _, tr := range subset[s] {
+			trFull, ok := byLang[tr.Lang]
+			if !ok {
+				res = append(res, fmt.Sprintf("string '%s' has no '%s' translation", s, tr.Lang))
+				continue
+			}
+			if trFull != tr.Translation {
+				res = append(res, fmt.Sprintf("string '%s' has '%s' translation '%s' instead of '%s'", s, tr.Lang, trFull, tr.Translation))
+			}
+		}
+	}
+	return res
+}
+
+// verifies that translations in subsetPath are a subset of
+// strings/translations.txt
+func reconcileTranslationsMain(subsetPath string) {
+	fullPath := translationsPath()
+	full := parseTranslations(string(u.ReadFileMust(fullPath)))
+	subset := parseTranslations(string(u.ReadFileMust(subsetPath)))
+	problems := reconcileTranslations(full, subset)
+	for _, s := range problems {
+		logf("%s\n", s)
+	}
+	fatalIf(len(problems) > 0, "'%s' is not a subset of '%s', found %d differences\n", subsetPath, fullPath, len(problems))
+	logf("'%s' (%d strings) is a subset of '%s'\n", subsetPath, len(subset), fullPath)
+}
+
+// a difference between two versions of translations. Old is "" for
+// added translations and New is "" for removed translations
+type translationChange struct {
+	Text string
+	Lang string
+	Old  string
+	New  string
+}
+
+func (c *translationChange) String() string {
+	switch {
+	case c.Old == "":
+		return fmt.Sprintf("added '%s' translation of '%s': '%s'", c.Lang, c.Text, c.New)
+	case c.New == "":
+		return fmt.Sprintf("removed '%s' translation of '%s': '%s'", c.Lang, c.Text, c.Old)
+	}
+	return fmt.Sprintf("changed '%s' translation of '%s' from '%s' to '%s'", c.Lang, c.Text, c.Old, c.New)
+}
+
+func translationsByLang(a []*Translation) map[string]string {
+	res := map[string]string{}
+	for _, tr := range a {
+		res[tr.Lang] = tr.Translation
+	}
+	return res
+}
+
+// returns changes of translations from prev to curr, sorted by string
+// and language
+func diffTranslations(prev, curr map[string][]*Translation) []*translationChange {
+	keys := map[string]bool{}
+	for s := range prev {
+		keys[s] = true
+	}
+	for s := range curr {
+		keys[s] = true
+	}
+	var sorted []string
+	for s := range keys {
+		sorted = append(sorted, s)
+	}
+	sort.Strings(sorted)
+	var res []*translationChange
+	for _, s := range sorted {
+		prevByLang := translationsByLang(prev[s])
+		currByLang := translationsByLang(curr[s])
+		var langs []string
+		for lang := range prevByLang {
+			langs = append(langs, lang)
+		}
+		for lang := range currByLang {
+			if _, ok := prevByLang[lang]; !ok {
+				langs = append(langs, lang)
+			}
+		}
+		sort.Strings(langs)
+		for _, lang := range langs {
+			oldTr, newTr := prevByLang[lang], currByLang[lang]
+			if oldTr != newTr {
+				res = append(res, &translationChange{Text: s, Lang: lang, Old: oldTr, New: newTr})
+			}
+		}
+	}
+	return res
+}
+
+// translations reviewed for -trans-freeze, in the format of
+// translations.txt
+func translationsApprovedPath() string {
+	return filepath.Join("strings", "translations-approved.txt")
+}
+
+// returns stringsDict with changes applied
+func applyTranslationChanges(stringsDict map[string][]*Translation, changes []*translationChange) map[string][]*Translation {
+	res := map[string][]*Translation{}
+	for s, a := range stringsDict {
+		res[s] = append([]*Translation(nil), a...)
+	}
+	for _, c := range changes {
+		var a []*Translation
+		for _, tr := range res[c.Text] {
+			if tr.Lang != c.Lang {
+				a = append(a, tr)
+			}
+		}
+		if c.New != "" {
+			a = append(a, &Translation{Text: c.Text, Lang: c.Lang, Translation: c.New})
+		}
+		if len(a) == 0 {
+			delete(res, c.Text)
+			continue
+		}
+		res[c.Text] = a
+	}
+	return res
+}
+
+// for release branches we don't want unreviewed translations. Reports
+// all changes from committed (current strings/translations.txt) to
+// downloaded translations and applies only those approved i.e. in
+// translationsApprovedPath() with the same new translation. Removals
+// can't be approved that way and are never applied.
+// Returns committed translations with approved changes, keeping sha1 of
+// committed translations so that the next download reports not approved
+// changes again
+func freezeTranslations(downloaded string, committed string) (string, int, int) {
+	approved := map[string][]*Translation{}
+	if path := translationsApprovedPath(); u.FileExists(path) {
+		approved = parseTranslations(string(u.ReadFileMust(path)))
+	}
+	prev := map[string][]*Translation{}
+	if committed != "" {
+		prev = parseTranslations(committed)
+	}
+	var toApply []*translationChange
+	nNotApproved := 0
+	for _, c := range diffTranslations(prev, parseTranslations(downloaded)) {
+		if c.New != "" && translationsByLang(approved[c.Text])[c.Lang] == c.New {
+			logf("approved:     %s\n", c)
+			toApply = append(toApply, c)
+			continue
+		}
+		logf("not approved: %s\n", c)
+		nNotApproved++
+	}
+	s := serializeTranslations(lastDownloadHash(), applyTranslationChanges(prev, toApply))
+	return s, len(toApply), nNotApproved
+}
+
+// serializes translations in the format of strings/translations.txt,
+// sorted by string and then by language so that the result only depends
+// on the content
+func serializeTranslations(sha1 string, stringsDict map[string][]*Translation) string {
+	var keys []string
+	for s := range stringsDict {
+		keys = append(keys, s)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	writeTranslationsHeader(&b, sha1)
+	for _, s := range keys {
+		b.WriteString(":" + s + "\n")
+		translations := append([]*Translation(nil), stringsDict[s]...)
+		// compare with ':' like whole lines so that e.g. "ca-xv:" sorts
+		// before "ca:", as the server does, and existing files don't churn
+		sort.SliceStable(translations, func(i, j int) bool {
+			return translations[i].Lang+":" < translations[j].Lang+":"
+		})
+		for _, tr := range translations {
+			b.WriteString(tr.Lang + ":" + tr.Translation + "\n")
+		}
+	}
+	return b.String()
+}
+
+// generates Go source file with translations of strings used in
+// the source code as a string constant. Each line of translations is
+// on its own line so that the file diffs cleanly
+func genGoTranslations(pkgName string, s string) string {
+	stringsDict := parseTranslations(s)
+	stringsList := extractJustStrings(getStringsToTranslate())
+	removeObsoleteStrings(stringsDict, stringsList)
+	sha1 := translationsSha1HexMust([]byte(s))
+	lines := strings.SplitAfter(serializeTranslations(sha1, stringsDict), "\n")
+
+	var b strings.Builder
+	b.WriteString("// Code generated by \"do -trans-emit-go\" from strings/translations.txt; DO NOT EDIT.\n\n")
+	b.WriteString("package " + pkgName + "\n\n")
+	b.WriteString("const TranslationsTxt = \"\" +\n")
+	for _, l := range lines {
+		if l == "" {
+			continue
+		}
+		b.WriteString("\t" + strconv.Quote(l) + " +\n")
+	}
+	b.WriteString("\t\"\"\n")
+	return b.String()
+}
+
+// writes translations as Go source file to path. Package name is
+// the name of the directory of path
+func emitGoTranslations(path string) {
+	d := u.ReadFileMust(lastDownloadFilePath())
+	s, _ := applyTranslationOverridesFromFile(string(d))
+	absPath, err := filepath.Abs(path)
+	panicIfErr(err)
+	pkgName := filepath.Base(filepath.Dir(absPath))
+	src := genGoTranslations(pkgName, s)
+	formatted, err := format.Source([]byte(src))
+	panicIfErr(err)
+	u.CreateDirForFileMust(path)
+	u.WriteFileMust(path, formatted)
+	logf("Wrote translations to '%s'\n", path)
+}
+
+func translationsCSVPath() string {
+	return filepath.Join("strings", "translations.csv")
+}
+
+// returns translations as csv with English string in the first column
+// and a column for each language in gLangs. Missing translations are
+// empty
+func genTranslationsCSV(stringsDict map[string][]*Translation) []byte {
+	var langs []string
+	for _, lang := range gLangs {
+		if lang[0] != "en" {
+			langs = append(langs, lang[0])
+		}
+	}
+	sort.Strings(langs)
+	var keys []string
+	for s := range stringsDict {
+		keys = append(keys, s)
+	}
+	sort.Strings(keys)
+
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	err := w.Write(append([]string{"English"}, langs...))
+	must(err)
+	for _, s := range keys {
+		byLang := map[string]string{}
+		for _, t := range stringsDict[s] {
+			byLang[t.Lang] = t.Translation
+		}
+		row := []string{s}
+		for _, lang := range langs {
+			row = append(row, byLang[lang])
+		}
+		err = w.Write(row)
+		must(err)
+	}
+	w.Flush()
+	must(w.Error())
+	return buf.Bytes()
+}
+
+// writes strings/translations.txt as csv, for reviewing in a spreadsheet
+func emitTranslationsCSV() {
+	d := u.ReadFileMust(translationsPath())
+	stringsDict := parseTranslations(string(d))
+	path := translationsCSVPath()
+	u.WriteFileMust(path, genTranslationsCSV(stringsDict))
+	logf("Wrote %d strings to '%s'\n", len(stringsDict), path)
+}
+
+func translationsCoveragePath() string {
+	return filepath.Join("strings", "coverage.json")
+}
+
+// per-language coverage for shields.io-style badges
+type langCoverageJSON struct {
+	Percent int    `json:"percent"`
+	Color   string `json:"color"`
+}
+
+// green for languages complete enough to be included in the generated
+// translations (see incompleteMissingThreshold)
+func coverageColor(percentDone float64) string {
+	switch {
+	case percentDone >= (1-incompleteMissingThreshold)*100:
+		return "green"
+	case percentDone >= 50:
+		return "yellow"
+	default:
+		return "red"
+	}
+}
+
+func getTranslationsCoverage(langs []*langStatusJSON) map[string]*langCoverageJSON {
+	res := map[string]*langCoverageJSON{}
+	for _, st := range langs {
+		res[st.Lang] = &langCoverageJSON{
+			Percent: int(st.PercentDone),
+			Color:   coverageColor(st.PercentDone),
+		}
+	}
+	return res
+}
+
+// writes how complete translations of each language are, based on
+// strings/translations.txt and strings in source code, to
+// strings/coverage.json
+func emitTranslationsCoverage() {
+	d := u.ReadFileMust(translationsPath())
+	stringsDict := parseTranslations(string(d))
+	keys := extractJustStrings(getStringsToTranslate())
+	coverage := getTranslationsCoverage(getLangsStatus(stringsDict, keys))
+	js, err := json.MarshalIndent(coverage, "", "  ")
+	must(err)
+	path := translationsCoveragePath()
+	u.WriteFileMust(path, append(js, '\n'))
+	logf("Wrote coverage of %d languages to '%s'\n", len(coverage), path)
+}
+
+// tiers of languages for coverage checks of downloaded translations
+const (
+	// listed in supportedLangsPath(), -trans-dl fails if they're
+	// less complete than -trans-min-supported
+	langTierSupported = "supported"
+	// all other languages, we only warn if they're less complete
+	// than -trans-min-community
+	langTierCommunity = "community"
+)
+
+// one language code per line, empty lines and lines starting
+// with '#' are ignored
+func supportedLangsPath() string {
+	return filepath.Join("strings", "supported-langs.txt")
+}
+
+func parseSupportedLangs(d []byte) (map[string]bool, error) {
+	res := map[string]bool{}
+	for i, l := range strings.Split(string(stripCarriageReturns(d)), "\n") {
+		l = strings.TrimSpace(l)
+		if l == "" || strings.HasPrefix(l, "#") {
+			continue
+		}
+		if !isKnownLangCode(l) {
+			return nil, fmt.Errorf("%s:%d: unknown language '%s'", supportedLangsPath(), i+1, l)
+		}
+		res[l] = true
+	}
+	return res, nil
+}
+
+// returns nil if supportedLangsPath() doesn't exist i.e. all languages
+// are community languages
+func loadSupportedLangs() (map[string]bool, error) {
+	path := supportedLangsPath()
+	if !u.FileExists(path) {
+		return nil, nil
+	}
+	return parseSupportedLangs(u.ReadFileMust(path))
+}
+
+type langCoverageCheckJSON struct {
+	Lang        string  `json:"lang"`
+	Tier        string  `json:"tier"`
+	PercentDone float64 `json:"percentDone"`
+	MinPercent  float64 `json:"minPercent"`
+	Passed      bool    `json:"passed"`
+}
+
+// checks completeness of each language against the threshold of its tier
+func checkTranslationsCoverage(langs []*langStatusJSON, supported map[string]bool, minSupported float64, minCommunity float64) []*langCoverageCheckJSON {
+	var res []*langCoverageCheckJSON
+	for _, st := range langs {
+		c := &langCoverageCheckJSON{
+			Lang:        st.Lang,
+			Tier:        langTierCommunity,
+			PercentDone: st.PercentDone,
+			MinPercent:  minCommunity,
+		}
+		if supported[st.Lang] {
+			c.Tier = langTierSupported
+			c.MinPercent = minSupported
+		}
+		c.Passed = c.PercentDone >= c.MinPercent
+		res = append(res, c)
+	}
+	return res
+}
+
+// logs result of each check and returns an error if any of supported
+// languages didn't pass
+func reportTranslationsCoverage(checks []*langCoverageCheckJSON) error {
+	var failed []string
+	for _, c := range checks {
+		res := "ok"
+		if !c.Passed {
+			res = "FAILED"
+			if c.Tier == langTierCommunity {
+				res = "warning"
+			}
+		}
+		logf("%-6s %-9s %5.1f%% (min %.0f%%) %s\n", c.Lang, c.Tier, c.PercentDone, c.MinPercent, res)
+		if !c.Passed && c.Tier == langTierSupported {
+			failed = append(failed, fmt.Sprintf("%s (%.1f%%, min %.0f%%)", c.Lang, c.PercentDone, c.MinPercent))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("supported languages are not translated enough: %s", strings.Join(failed, ", "))
+	}
+	return nil
 }
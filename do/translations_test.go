@@ -0,0 +1,251 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/kjk/u"
+)
+
+func TestStripCarriageReturns(t *testing.T) {
+	tests := []struct {
+		s   string
+		exp string
+	}{
+		{"", ""},
+		{"a\nb\n", "a\nb\n"},
+		{"a\r\nb\r\n", "a\nb\n"},
+		{"a\r\r\nb\rc", "a\nbc"},
+		{"\r", ""},
+	}
+	for _, test := range tests {
+		got := string(stripCarriageReturns([]byte(test.s)))
+		if got != test.exp {
+			t.Errorf("stripCarriageReturns(%q) = %q, expected %q", test.s, got, test.exp)
+		}
+	}
+}
+
+const testTranslations = `AppTranslator: SumatraPDF
+8ed3369de793564c5badf05a7e18cac28b8b2bef
+:&Open
+de:Ö&ffnen
+fr:&Ouvrir
+:Page %d
+de:Seite %d
+`
+
+// parseTranslations() must skip exactly the lines written by
+// writeTranslationsHeader() and start at the first ":" string
+func TestTranslationsHeader(t *testing.T) {
+	var buf strings.Builder
+	writeTranslationsHeader(&buf, "8ed3369de793564c5badf05a7e18cac28b8b2bef")
+	header := buf.String()
+	lines := strings.Split(strings.TrimSuffix(header, "\n"), "\n")
+	if len(lines) != nTranslationsHeaderLines {
+		t.Fatalf("header has %d lines, expected %d:\n%s", len(lines), nTranslationsHeaderLines, header)
+	}
+	if lines[0] != translationsHeader {
+		t.Errorf("first line is '%s', expected '%s'", lines[0], translationsHeader)
+	}
+	if !strings.HasPrefix(testTranslations, header) {
+		t.Fatalf("header differs from testTranslations:\n%s", header)
+	}
+
+	body := strings.TrimPrefix(testTranslations, header)
+	if body[0] != ':' {
+		t.Fatalf("body doesn't start with a string:\n%s", body)
+	}
+	got := parseTranslations(header + body)
+	exp := map[string][]*Translation{
+		"&Open": {
+			{Text: "&Open", Lang: "de", Translation: "Ö&ffnen"},
+			{Text: "&Open", Lang: "fr", Translation: "&Ouvrir"},
+		},
+		"Page %d": {
+			{Text: "Page %d", Lang: "de", Translation: "Seite %d"},
+		},
+	}
+	if !reflect.DeepEqual(got, exp) {
+		t.Errorf("parseTranslations() = %v, expected %v", got, exp)
+	}
+	path := filepath.Join(t.TempDir(), "translations.txt")
+	u.WriteFileMust(path, []byte(header+body))
+	for _, issue := range lintTranslations(path) {
+		t.Errorf("line %d: %s", issue.LineNo, issue.Msg)
+	}
+}
+
+func TestParseTranslationsCRLF(t *testing.T) {
+	exp := parseTranslations(testTranslations)
+	if len(exp) != 2 {
+		t.Fatalf("parsed %d strings, expected 2", len(exp))
+	}
+	crlf := strings.Replace(testTranslations, "\n", "\r\n", -1)
+	// mixed line endings and a stray \r inside a line
+	mixed := strings.Replace(testTranslations, "fr:&Ouvrir\n", "fr:&Ouv\rrir\r\r\n", 1)
+	for _, s := range []string{crlf, mixed} {
+		got := parseTranslations(s)
+		if !reflect.DeepEqual(got, exp) {
+			t.Errorf("parseTranslations(%q) differs from parsing \\n version", s)
+		}
+		for str, a := range got {
+			for _, tr := range a {
+				if strings.Contains(str, "\r") || strings.Contains(tr.Translation, "\r") || strings.Contains(tr.Lang, "\r") {
+					t.Errorf("translation %q of %q has \\r", tr.Translation, str)
+				}
+			}
+		}
+	}
+}
+
+func TestValidateTranslationsBytesStripsCarriageReturns(t *testing.T) {
+	crlf := strings.Replace(testTranslations, "\n", "\r\n", -1)
+	got := string(validateTranslationsBytesMust([]byte(crlf)))
+	if got != testTranslations {
+		t.Errorf("validateTranslationsBytesMust(%q) = %q, expected %q", crlf, got, testTranslations)
+	}
+}
+
+func TestVerifyTranslationsNotTruncated(t *testing.T) {
+	prevAllowShrink := flgTransAllowShrink
+	defer func() { flgTransAllowShrink = prevAllowShrink }()
+
+	shrunk := strings.Replace(testTranslations, ":Page %d\nde:Seite %d\n", "", 1)
+	tests := []struct {
+		name        string
+		s           string
+		prev        string
+		allowShrink bool
+		ok          bool
+	}{
+		{"first download", testTranslations, "", false, true},
+		{"unchanged", testTranslations, testTranslations, false, true},
+		{"no trailing newline", strings.TrimSuffix(testTranslations, "\n"), "", false, false},
+		{"cut in the middle of a line", testTranslations[:len(testTranslations)-4], testTranslations, false, false},
+		// a new string doesn't have translations yet
+		{"last string is new", testTranslations + ":New string\n", "", false, true},
+		{"fewer strings", shrunk, testTranslations, false, false},
+		{"fewer strings allowed", shrunk, testTranslations, true, true},
+	}
+	for _, test := range tests {
+		flgTransAllowShrink = test.allowShrink
+		err := verifyTranslationsNotTruncated(test.s, test.prev)
+		if test.ok && err != nil {
+			t.Errorf("%s: unexpected error: %s", test.name, err)
+		}
+		if !test.ok && err == nil {
+			t.Errorf("%s: expected an error", test.name)
+		}
+	}
+}
+
+func changesToStrings(a []*translationChange) []string {
+	var res []string
+	for _, c := range a {
+		res = append(res, c.String())
+	}
+	return res
+}
+
+func TestDiffTranslations(t *testing.T) {
+	prev := parseTranslations(testTranslations)
+	curr := parseTranslations(`AppTranslator: SumatraPDF
+8ed3369de793564c5badf05a7e18cac28b8b2bef
+:&Open
+de:&Öffnen
+it:&Apri
+:New
+de:Neu
+`)
+	exp := []string{
+		"changed 'de' translation of '&Open' from 'Ö&ffnen' to '&Öffnen'",
+		"removed 'fr' translation of '&Open': '&Ouvrir'",
+		"added 'it' translation of '&Open': '&Apri'",
+		"added 'de' translation of 'New': 'Neu'",
+		"removed 'de' translation of 'Page %d': 'Seite %d'",
+	}
+	got := changesToStrings(diffTranslations(prev, curr))
+	if !reflect.DeepEqual(got, exp) {
+		t.Errorf("diffTranslations() = %q, expected %q", got, exp)
+	}
+	if a := diffTranslations(prev, prev); len(a) != 0 {
+		t.Errorf("diff of the same translations is %q", changesToStrings(a))
+	}
+	// applying all changes gives the new translations
+	applied := applyTranslationChanges(prev, diffTranslations(prev, curr))
+	if s, exp := serializeTranslations("x", applied), serializeTranslations("x", curr); s != exp {
+		t.Errorf("applying changes gives:\n%s\nexpected:\n%s", s, exp)
+	}
+}
+
+// runs the test in a temporary directory with strings/ sub-directory,
+// like the root of the repository
+func chdirToTempRepo(t *testing.T) {
+	dir := t.TempDir()
+	must(os.Mkdir(filepath.Join(dir, "strings"), 0755))
+	wd, err := os.Getwd()
+	must(err)
+	must(os.Chdir(dir))
+	t.Cleanup(func() { must(os.Chdir(wd)) })
+}
+
+func TestFreezeTranslations(t *testing.T) {
+	chdirToTempRepo(t)
+	committed := testTranslations
+	saveLastDownload([]byte(committed))
+	downloaded := `AppTranslator: SumatraPDF
+1111111111111111111111111111111111111111
+:&Open
+de:&Öffnen
+it:&Apri
+:New
+de:Neu
+`
+	approved := `AppTranslator: SumatraPDF
+0000000000000000000000000000000000000000
+:&Open
+de:&Öffnen
+:New
+de:Neu
+`
+	u.WriteFileMust(translationsApprovedPath(), []byte(approved))
+
+	s, nApplied, nNotApproved := freezeTranslations(downloaded, committed)
+	if nApplied != 2 || nNotApproved != 3 {
+		t.Errorf("applied %d and didn't apply %d changes, expected 2 and 3", nApplied, nNotApproved)
+	}
+	// sha1 stays the same so that changes that were not approved
+	// are reported again by the next download
+	exp := `AppTranslator: SumatraPDF
+8ed3369de793564c5badf05a7e18cac28b8b2bef
+:&Open
+de:&Öffnen
+fr:&Ouvrir
+:New
+de:Neu
+:Page %d
+de:Seite %d
+`
+	if s != exp {
+		t.Errorf("freezeTranslations() =\n%s\nexpected:\n%s", s, exp)
+	}
+
+	// without approved translations nothing changes
+	must(os.Remove(translationsApprovedPath()))
+	s, nApplied, _ = freezeTranslations(downloaded, committed)
+	if nApplied != 0 || s != committed {
+		t.Errorf("applied %d changes without approved translations:\n%s", nApplied, s)
+	}
+}
+
+func TestMinioUploadTranslationsNeedsCreds(t *testing.T) {
+	t.Setenv("SPACES_KEY", "")
+	t.Setenv("SPACES_SECRET", "")
+	if !panics(minioUploadTranslationsMust) {
+		t.Errorf("no error uploading translations without spaces credentials")
+	}
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/minio/minio-go/v6"
+)
+
+const (
+	storageS3     = "s3"
+	storageSpaces = "spaces"
+)
+
+// storages we upload each build type to
+var buildTypeStorages = map[string][]string{
+	buildTypeDaily:   {storageS3, storageSpaces},
+	buildTypePreRel:  {storageS3, storageSpaces},
+	buildTypeRel:     {storageS3, storageSpaces},
+	buildTypeRaMicro: {storageSpaces},
+}
+
+// parses -upload-storages over-rides of buildTypeStorages
+// e.g. "daily=spaces;rel=s3,spaces"
+func parseBuildTypeStorages(s string) (map[string][]string, error) {
+	res := map[string][]string{}
+	for _, part := range strings.Split(s, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid '%s', expected ${buildType}=${storage},${storage}", part)
+		}
+		buildType := strings.TrimSpace(kv[0])
+		if !isValidBuildType(buildType) {
+			return nil, fmt.Errorf("invalid build type '%s' in '%s'", buildType, part)
+		}
+		var storages []string
+		for _, storage := range strings.Split(kv[1], ",") {
+			storage = strings.TrimSpace(storage)
+			if storage == "" {
+				continue
+			}
+			if storage != storageS3 && storage != storageSpaces {
+				return nil, fmt.Errorf("invalid storage '%s' in '%s'", storage, part)
+			}
+			if storage == storageS3 && buildType == buildTypeRaMicro {
+				return nil, fmt.Errorf("ramicro builds are only uploaded to spaces")
+			}
+			storages = append(storages, storage)
+		}
+		res[buildType] = storages
+	}
+	return res, nil
+}
+
+func getStoragesForBuildType(buildType string) []string {
+	if flgUploadStorages != "" {
+		m, err := parseBuildTypeStorages(flgUploadStorages)
+		panicIfErr(err)
+		if storages, ok := m[buildType]; ok {
+			return storages
+		}
+	}
+	return buildTypeStorages[buildType]
+}
+
+// returns storages whose credentials are set in env variables. Skipped
+// storages are logged (see hasS3Creds() and hasSpacesCreds())
+func availableStorages() []string {
+	var res []string
+	if hasS3Creds() {
+		res = append(res, storageS3)
+	}
+	if hasSpacesCreds() {
+		res = append(res, storageSpaces)
+	}
+	return res
+}
+
+func hasStorage(storages []string, storage string) bool {
+	for _, s := range storages {
+		if s == storage {
+			return true
+		}
+	}
+	return false
+}
+
+// guards against publishing files of one build type as another e.g.
+// release files staged in out/final-prerel. Returns an error if names of
+// files in dir don't start with the app name and version of buildType
+// (e.g. "SumatraPDF-prerel-12223" or "SumatraPDF-3.2") or the manifest
+// has a different version.
+// Daily and pre-release builds are named the same way so we can't tell
+// them apart
+func verifyBuildMatchesBuildType(dir string, buildType string) error {
+	ver := getVerForBuildType(buildType)
+	prefix := getAppNameForBuildType(buildType) + "-" + ver
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	var bad []string
+	for _, f := range files {
+		name := f.Name()
+		if f.IsDir() || isIgnoredUploadFile(name) {
+			continue
+		}
+		rest := strings.TrimPrefix(name, prefix)
+		if rest == name || !(strings.HasPrefix(rest, "-") || strings.HasPrefix(rest, ".")) {
+			bad = append(bad, name)
+			continue
+		}
+		if !isManifestFile(name) {
+			continue
+		}
+		d, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return err
+		}
+		if manifestVer := getManifestValue(d, "ver"); manifestVer != ver {
+			return fmt.Errorf("'%s' is for version '%s' but '%s' build is version '%s'", name, manifestVer, buildType, ver)
+		}
+	}
+	if len(bad) > 0 {
+		return fmt.Errorf("files in '%s' are not from '%s' build (names should start with '%s'): %s", dir, buildType, prefix, strings.Join(bad, ", "))
+	}
+	return nil
+}
+
+// uploads the build to all storages configured for its build type.
+// With -verify-signed, release and pre-release builds are only uploaded
+// if their executables are signed. If upload to one storage fails, we
+// still upload to the others and then panic with all failures
+func uploadBuildToAll(buildType string) {
+	if flgVerifySigned && (buildType == buildTypeRel || buildType == buildTypePreRel) {
+		dir := flgUploadDir
+		if dir == "" {
+			dir = getFinalDirForBuildType(buildType)
+		}
+		verifyDirSignedMust(dir)
+	}
+	available := availableStorages()
+	// decided once, before uploading anywhere, so that s3 doesn't get
+	// a build that spaces skips. -upload-only re-uploads files of
+	// an existing build so it's expected to be from the same sha1
+	checkSha1 := flgUploadOnly == "" && hasStorage(available, storageSpaces) && !shouldSkipUpload()
+	if checkSha1 && isLatestBuildSameSha1(newMinioStorage(), buildType) {
+		return
+	}
+	var storages []string
+	for _, storage := range getStoragesForBuildType(buildType) {
+		if !hasStorage(available, storage) {
+			logf("Not uploading '%s' build to %s because its credentials are not set\n", buildType, storage)
+			continue
+		}
+		if storage == storageS3 && flgUploadOnly != "" {
+			logf("Not uploading to s3 because -upload-only is only supported for spaces\n")
+			continue
+		}
+		storages = append(storages, storage)
+	}
+	must(checkSSEStorages(uploadSSE, storages))
+	err := forEachStorage("upload '"+buildType+"' build", storages, func(storage string) {
+		switch storage {
+		case storageS3:
+			s3UploadBuildMust(buildType)
+		case storageSpaces:
+			spacesUploadBuildMust(buildType, flgUploadDir)
+		}
+	})
+	must(err)
+}
+
+// calls fn for each of storages. A failure (panic) of fn for one storage
+// doesn't stop it from being called for the others. Returns the failures
+// as MultiError
+func forEachStorage(what string, storages []string, fn func(storage string)) error {
+	errs := newMultiError(what, len(storages))
+	for _, storage := range storages {
+		err := catchPanic(func() {
+			fn(storage)
+		})
+		if err != nil {
+			logf("Failed to %s in %s, err: %s\n", what, storage, err)
+		}
+		errs.AddForBackend(storage, "", err)
+	}
+	return errs.ErrorOrNil()
+}
+
+// returns true if the latest build of buildType in c is from the same
+// git sha1 as this build. It would be functionally identical so we don't
+// upload it anywhere, unless -force
+func isLatestBuildSameSha1(c minioStorage, buildType string) bool {
+	if flgForceUpload {
+		return false
+	}
+	prevSha1, err := minioGetLatestBuildSha1(c, buildType)
+	panicIfErr(err)
+	sha1 := getGitSha1()
+	if prevSha1 != sha1 {
+		return false
+	}
+	logf("Skipping upload: latest '%s' build is already from sha1 '%s'. Use -force to upload anyway\n", buildType, sha1)
+	return true
+}
+
+// deletes old builds from spaces and s3, if we have their credentials.
+// Gives up after -delete-timeout. Like uploadBuildToAll(), a failure
+// in one storage doesn't stop deleting from the other
+func deleteOldBuildsFromAll() {
+	ctx, cancel := context.WithTimeout(context.Background(), flgDeleteTimeout)
+	defer cancel()
+	available := availableStorages()
+	var storages []string
+	for _, storage := range []string{storageSpaces, storageS3} {
+		if !hasStorage(available, storage) {
+			logf("Not deleting old builds from %s because its credentials are not set\n", storage)
+			continue
+		}
+		storages = append(storages, storage)
+	}
+	err := forEachStorage("delete old builds", storages, func(storage string) {
+		switch storage {
+		case storageSpaces:
+			minioDeleteOldBuilds(ctx)
+		case storageS3:
+			s3DeleteOldBuilds(ctx)
+		}
+	})
+	must(err)
+}
+
+// how a file is stored in spaces
+type uploadPolicy struct {
+	ContentType  string
+	CacheControl string
+	Public       bool
+}
+
+const (
+	// builds and other files whose names include version or sha1 never change
+	cacheImmutable = "public, max-age=31536000, immutable"
+	// version info files change with every build
+	cacheNone = "no-cache"
+)
+
+// upload policy for files with a given extension. The longest matching
+// extension wins so that e.g. ".pdb.zip" can differ from ".zip"
+var uploadPolicies = map[string]uploadPolicy{
+	".exe":      {"application/octet-stream", cacheImmutable, true},
+	".zip":      {"application/zip", cacheImmutable, true},
+	".pdb.zip":  {"application/zip", cacheImmutable, true},
+	".pdb":      {"application/octet-stream", cacheImmutable, true},
+	".pdb.lzsa": {"application/octet-stream", cacheImmutable, true},
+	".tar.zst":  {"application/zstd", cacheImmutable, true},
+	".sha1":     {"text/plain; charset=utf-8", cacheImmutable, true},
+	".sha256":   {"text/plain; charset=utf-8", cacheImmutable, true},
+	".sha512":   {"text/plain; charset=utf-8", cacheImmutable, true},
+	".txt.gz":   {"application/gzip", cacheImmutable, true},
+	".js":       {"application/javascript; charset=utf-8", cacheNone, true},
+	".txt":      {"text/plain; charset=utf-8", cacheNone, true},
+	".json":     {"application/json", cacheNone, true},
+	".xml":      {"application/atom+xml; charset=utf-8", cacheNone, true},
+	".lock":     {"text/plain; charset=utf-8", cacheNone, false},
+}
+
+// for files we don't know about, we don't make them public
+var defaultUploadPolicy = uploadPolicy{"application/octet-stream", cacheNone, false}
+
+func getUploadPolicy(remotePath string) uploadPolicy {
+	name := strings.ToLower(path.Base(remotePath))
+	res := defaultUploadPolicy
+	matchLen := 0
+	for ext, policy := range uploadPolicies {
+		if len(ext) > matchLen && strings.HasSuffix(name, ext) {
+			res = policy
+			matchLen = len(ext)
+		}
+	}
+	return res
+}
+
+func (p uploadPolicy) putObjectOptions() minio.PutObjectOptions {
+	opts := minio.PutObjectOptions{
+		ContentType:  p.ContentType,
+		CacheControl: p.CacheControl,
+		// nil unless -sse
+		ServerSideEncryption: uploadSSE.serverSide(),
+	}
+	if p.Public {
+		opts.UserMetadata = map[string]string{
+			"x-amz-acl": "public-read",
+		}
+	}
+	return opts
+}
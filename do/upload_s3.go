@@ -2,11 +2,14 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io/ioutil"
+	"net/url"
 	"os"
-	"path"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"text/template"
 	"time"
 )
@@ -40,45 +43,164 @@ func execTextTemplate(tmplText string, data interface{}) string {
 }
 
 // sumatrapdf/sumatralatest.js
-func createSumatraLatestJs(buildType string) string {
-	var appName string
-	switch buildType {
-	case buildTypePreRel, buildTypeDaily:
-		appName = "SumatraPDF-prerel"
-	case buildTypeRel:
-		appName = "SumatraPDF"
-	case buildTypeRaMicro:
-		// must match name in spacesUploadBuildMust
-		appName = "RAMicroPDFViewer-prerel"
-	default:
-		panicIf(true, "invalid buildType '%s'", buildType)
+// Note: urls point to spaces and respect remoteRoot. Their form depends
+// on -latest-js-urls
+func createSumatraLatestJs(buildType string, archs buildArchs) string {
+	ver := getVerForBuildType(buildType)
+	sha1 := getGitSha1()
+	builtOn := time.Now().UTC().Format(buildDateFormat)
+	return createSumatraLatestJsForVer(buildType, ver, sha1, builtOn, archs)
+}
+
+const spacesURLBase = "https://kjkpubsf.sfo2.digitaloceanspaces.com/"
+
+// returns url of directory with builds of a given type in spaces
+// i.e. getRemoteDir() as url
+func getDownloadHost(buildType string) string {
+	return spacesURLBase + escapeURLPath(remoteJoin(remoteRoot, getBuildTypeInfo(buildType).remoteDir))
+}
+
+// forms of download urls in sumatralatest.js (see -latest-js-urls). The
+// updater reads urls from *-update.txt which are always absolute
+const (
+	urlModeAbsolute         = "absolute"
+	urlModeProtocolRelative = "protocol-relative"
+	urlModePath             = "path"
+)
+
+func isValidURLMode(mode string) bool {
+	switch mode {
+	case urlModeAbsolute, urlModeProtocolRelative, urlModePath:
+		return true
+	}
+	return false
+}
+
+// converts absolute url under spacesURLBase to a given form e.g.
+// "//kjkpubsf.sfo2.digitaloceanspaces.com/software/..." for
+// urlModeProtocolRelative and "/software/..." for urlModePath, so that
+// the website can serve files from a different host
+func urlForMode(uri string, mode string) string {
+	switch mode {
+	case urlModeAbsolute:
+		return uri
+	case urlModeProtocolRelative:
+		return strings.TrimPrefix(uri, "https:")
+	case urlModePath:
+		return "/" + strings.TrimPrefix(uri, spacesURLBase)
 	}
+	panicIf(true, "invalid url mode '%s'", mode)
+	return ""
+}
+
+// reverse of urlForMode()
+func absoluteURL(uri string) string {
+	if strings.HasPrefix(uri, "//") {
+		return "https:" + uri
+	}
+	if strings.HasPrefix(uri, "/") {
+		return spacesURLBase + uri[1:]
+	}
+	return uri
+}
+
+// returns url of .tar.zst variant of 64-bit build (created with -zstd)
+func getZstdDownloadURL(buildType string, ver string) string {
+	name := getAppNameForBuildType(buildType) + "-" + ver
+	return getDownloadHost(buildType) + "/" + url.PathEscape(name) + "-64.tar.zst"
+}
+
+// returns [label, url] of files of a given version of a build
+func getDownloadURLs(buildType string, ver string) [][]string {
+	prefix := getDownloadHost(buildType) + "/" + url.PathEscape(getAppNameForBuildType(buildType)+"-"+ver)
+	var res [][]string
+	for _, arch := range []string{"", "-64"} {
+		label := "32-bit"
+		if arch != "" {
+			label = "64-bit"
+		}
+		res = append(res,
+			[]string{label + " exe", prefix + arch + ".exe"},
+			[]string{label + " zip", prefix + arch + ".zip"},
+			[]string{label + " installer", prefix + arch + "-install.exe"},
+			[]string{label + " pdb", prefix + arch + ".pdb.zip"},
+		)
+		if flgZstd {
+			res = append(res, []string{label + " tar.zst", prefix + arch + ".tar.zst"})
+		}
+	}
+	return res
+}
 
-	currDate := time.Now().Format("2006-01-02")
+// replaces public urls returned by getDownloadURLs() with presigned urls
+// valid for expiry, for when files are not public
+func presignDownloadURLs(c minioStorage, urls [][]string, expiry time.Duration) [][]string {
+	var res [][]string
+	for _, f := range urls {
+		remotePath, err := remotePathFromURL(f[1])
+		panicIfErr(err)
+		uri, err := c.PresignedURL(remotePath, expiry)
+		panicIfErr(err)
+		res = append(res, []string{f[0], uri})
+	}
+	return res
+}
+
+// prints download urls of a given version of a build. With
+// -presign-expiry they are presigned
+func printDownloadURLs(buildType string, ver string) {
+	panicIf(!isValidBuildType(buildType), "invalid build type: '%s'", buildType)
+	if buildType == buildTypeRel {
+		verifyCorrectVersionMust(ver)
+	} else {
+		_, err := strconv.Atoi(ver)
+		panicIf(err != nil, "invalid version '%s' of '%s' build, must be a number", ver, buildType)
+	}
+	urls := getDownloadURLs(buildType, ver)
+	if flgPresignExpiry > 0 {
+		urls = presignDownloadURLs(newMinioStorage(), urls, flgPresignExpiry)
+	}
+	for _, f := range urls {
+		fmt.Printf("%-20s %s\n", f[0]+":", f[1])
+	}
+}
+
+// urls are only included for architectures in archs. builtOn is the
+// date of the build (see manifestInfo) and not of the upload
+// so that re-generating the file for the same build doesn't change it
+func createSumatraLatestJsForVer(buildType string, ver string, sha1 string, builtOn string, archs buildArchs) string {
+	appName := getAppNameForBuildType(buildType)
 	tmplText := `
 var sumLatestVer = {{.Ver}};
 var sumCommitSha1 = "{{ .Sha1 }}";
-var sumBuiltOn = "{{.CurrDate}}";
-var sumLatestName = "{{.Prefix}}.exe";
-
+var sumBuiltOn = "{{.BuiltOn}}";
+var sumLatestName = "{{.Name}}.exe";
+{{if .Has32}}
 var sumLatestExe         = "{{.Host}}/{{.Prefix}}.exe";
 var sumLatestExeZip      = "{{.Host}}/{{.Prefix}}.zip";
 var sumLatestPdb         = "{{.Host}}/{{.Prefix}}.pdb.zip";
 var sumLatestInstaller   = "{{.Host}}/{{.Prefix}}-install.exe";
-
+{{end}}{{if .Has64}}
 var sumLatestExe64       = "{{.Host}}/{{.Prefix}}-64.exe";
 var sumLatestExeZip64    = "{{.Host}}/{{.Prefix}}-64.zip";
 var sumLatestPdb64       = "{{.Host}}/{{.Prefix}}-64.pdb.zip";
 var sumLatestInstaller64 = "{{.Host}}/{{.Prefix}}-64-install.exe";
-`
-	ver := getVerForBuildType(buildType)
-	sha1 := getGitSha1()
+{{end}}{{if .Zstd}}
+{{if .Has32}}var sumLatestExeZst      = "{{.Host}}/{{.Prefix}}.tar.zst";
+{{end}}{{if .Has64}}var sumLatestExeZst64    = "{{.Host}}/{{.Prefix}}-64.tar.zst";
+{{end}}{{end}}`
+	name := appName + "-" + ver
+	// ver is used in urls so escape it in case it has unexpected characters
 	d := map[string]interface{}{
-		"Host":     "https://kjkpubsf.sfo2.digitaloceanspaces.com/software/sumatrapdf/" + buildType,
-		"Ver":      ver,
-		"Sha1":     sha1,
-		"CurrDate": currDate,
-		"Prefix":   appName + "-" + ver,
+		"Host":    urlForMode(getDownloadHost(buildType), flgLatestJsURLs),
+		"Ver":     ver,
+		"Sha1":    sha1,
+		"BuiltOn": builtOn,
+		"Name":    name,
+		"Prefix":  url.PathEscape(name),
+		"Zstd":    flgZstd,
+		"Has32":   archs.has32,
+		"Has64":   archs.has64,
 	}
 	return execTextTemplate(tmplText, d)
 }
@@ -99,10 +221,8 @@ func s3ListPreReleaseFilesMust(c *S3Client, prefix string) []string {
 
 // only check one file we know will be uploaded
 func verifyBuildNotInS3ShortMust(buildType string) {
-	dirRemote := getRemoteDir(buildType)
 	ver := getVerForBuildType(buildType)
-	fname := fmt.Sprintf("SumatraPDF-prerelease-%s-manifest.txt", ver)
-	remotePath := path.Join(dirRemote, fname)
+	remotePath := getManifestRemotePath(buildType, ver)
 	c := newS3Client()
 	fatalIf(c.Exists(remotePath), "build of type '%s' for ver '%s' already exists in s3 because file '%s' exists\n", buildType, ver, remotePath)
 }
@@ -119,7 +239,7 @@ func verifyBuildNotInS3Must(c *S3Client, buildType string) {
 	panicIfErr(err)
 	for _, f := range files {
 		fname := f.Name()
-		remotePath := path.Join(dirRemote, fname)
+		remotePath := remoteJoin(dirRemote, fname)
 		fatalIf(c.Exists(remotePath), "build from dir %s already exists in s3 because file '%s' exists\n", dirLocal, remotePath)
 	}
 }
@@ -153,17 +273,18 @@ func newS3Client() *S3Client {
 		Access: os.Getenv("AWS_ACCESS"),
 		Secret: os.Getenv("AWS_SECRET"),
 		Bucket: "kjkpub",
+		Region: os.Getenv("AWS_REGION"),
 	}
 	return c
 }
 
 func hasS3Creds() bool {
 	if os.Getenv("AWS_ACCESS") == "" {
-		logf("Not uploading to s3 because AWS_ACCESS env variable not set\n")
+		logf("Skipping s3 because AWS_ACCESS env variable not set\n")
 		return false
 	}
 	if os.Getenv("AWS_SECRET") == "" {
-		logf("Not uploading to s3 because AWS_SECRET env variable not set\n")
+		logf("Skipping s3 because AWS_SECRET env variable not set\n")
 		return false
 	}
 	return true
@@ -174,8 +295,11 @@ func s3UploadDir(c *S3Client, dirRemote string, dirLocal string) error {
 	must(err)
 	for _, f := range files {
 		fname := f.Name()
+		if isBuildDescFile(fname) {
+			continue
+		}
 		pathLocal := filepath.Join(dirLocal, fname)
-		pathRemote := path.Join(dirRemote, fname)
+		pathRemote := remoteJoin(dirRemote, fname)
 		err := c.UploadFileReader(pathRemote, pathLocal, true)
 		if err != nil {
 			return fmt.Errorf("failed s3 upload '%s' as '%s', err: %s", pathLocal, pathRemote, err)
@@ -185,37 +309,6 @@ func s3UploadDir(c *S3Client, dirRemote string, dirLocal string) error {
 	return nil
 }
 
-func getFinalDirForBuildType(buildType string) string {
-	var dir string
-	switch buildType {
-	case buildTypeDaily:
-		dir = "final-daily"
-	case buildTypeRel:
-		dir = "final-rel"
-	case buildTypePreRel:
-		dir = "final-prerel"
-	case buildTypeRaMicro:
-		dir = "final-ramicro"
-	default:
-		panicIf(true, "invalid buildType '%s'", buildType)
-	}
-	return filepath.Join("out", dir)
-}
-
-// this returns version to be used in uploaded file names
-func getVerForBuildType(buildType string) string {
-	switch buildType {
-	case buildTypeDaily, buildTypePreRel, buildTypeRaMicro:
-		// this is linear build number like "12223"
-		return getPreReleaseVer()
-	case buildTypeRel:
-		// this is program version like "3.2"
-		return sumatraVersion
-	}
-	panicIf(true, "invalid buildType '%s'", buildType)
-	return ""
-}
-
 // upload as:
 // https://kjkpub.s3.amazonaws.com/sumatrapdf/prerel/SumatraPDF-prerelease-1027-install.exe etc.
 func s3UploadBuildMust(buildType string) {
@@ -227,12 +320,18 @@ func s3UploadBuildMust(buildType string) {
 	timeStart := time.Now()
 	c := newS3Client()
 	c.VerifyHasSecrets()
+	c.VerifyRegion()
 
 	dirRemote := getRemoteDir(buildType)
 	dirLocal := getFinalDirForBuildType(buildType)
+	err := verifyBuildMatchesBuildType(dirLocal, buildType)
+	panicIfErr(err)
+	err = verifyBuildDesc(dirLocal, buildType)
+	panicIfErr(err)
+	verifyGitStateForReleaseMust(buildType)
 	verifyBuildNotInS3Must(c, buildType)
 
-	err := s3UploadDir(c, dirRemote, dirLocal)
+	err = s3UploadDir(c, dirRemote, dirLocal)
 	panicIfErr(err)
 
 	// for release build we don't upload files with version info
@@ -240,7 +339,7 @@ func s3UploadBuildMust(buildType string) {
 		return
 	}
 
-	files := getVersionFilesForLatestInfo(buildType)
+	files := getVersionFilesForLatestInfo(buildType, dirLocal)
 	for _, f := range files {
 		remotePath := f[0]
 		err = c.UploadString(remotePath, f[1], true)
@@ -251,16 +350,15 @@ func s3UploadBuildMust(buildType string) {
 	logf("Uploaded the build to s3 in %s\n", time.Since(timeStart))
 }
 
-func s3DeleteOldBuildsPrefix(buildType string) {
+// stops with a panic if ctx is cancelled or its deadline passes
+func s3DeleteOldBuildsPrefix(ctx context.Context, buildType string) {
 	panicIf(buildType == buildTypeRel, "can't delete release builds")
 	c := newS3Client()
 
-	nBuildsToRetain := nBuildsToRetainDaily
-	if buildType == buildTypePreRel {
-		nBuildsToRetain = nBuildsToRetainPreRel
-	}
+	nBuildsToRetain := getBuildTypeInfo(buildType).nRetain
 	remoteDir := getRemoteDir(buildType)
 
+	must(ctx.Err())
 	keys := s3ListPreReleaseFilesMust(c, remoteDir)
 	fmt.Printf("%d s3 files under '%s'\n", len(keys), remoteDir)
 	byVer := groupFilesByVersion(keys)
@@ -270,6 +368,7 @@ func s3DeleteOldBuildsPrefix(buildType string) {
 			fmt.Printf("%d, deleting\n", v.ver)
 			for _, fn := range v.files {
 				fmt.Printf("  %s deleting\n", fn)
+				must(ctx.Err())
 				err := c.Delete(fn)
 				must(err)
 			}
@@ -282,8 +381,8 @@ func s3DeleteOldBuildsPrefix(buildType string) {
 	}
 }
 
-func s3DeleteOldBuilds() {
-	s3DeleteOldBuildsPrefix(buildTypePreRel)
-	s3DeleteOldBuildsPrefix(buildTypeDaily)
-	s3DeleteOldBuildsPrefix(buildTypeRaMicro)
+func s3DeleteOldBuilds(ctx context.Context) {
+	s3DeleteOldBuildsPrefix(ctx, buildTypePreRel)
+	s3DeleteOldBuildsPrefix(ctx, buildTypeDaily)
+	s3DeleteOldBuildsPrefix(ctx, buildTypeRaMicro)
 }
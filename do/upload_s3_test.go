@@ -0,0 +1,123 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestURLForMode(t *testing.T) {
+	uri := spacesURLBase + "software/sumatrapdf/prerel/SumatraPDF-prerel-12345-64.exe"
+	tests := []struct {
+		mode string
+		exp  string
+	}{
+		{urlModeAbsolute, uri},
+		{urlModeProtocolRelative, "//kjkpubsf.sfo2.digitaloceanspaces.com/software/sumatrapdf/prerel/SumatraPDF-prerel-12345-64.exe"},
+		{urlModePath, "/software/sumatrapdf/prerel/SumatraPDF-prerel-12345-64.exe"},
+	}
+	for _, test := range tests {
+		if !isValidURLMode(test.mode) {
+			t.Errorf("'%s' is not a valid url mode", test.mode)
+		}
+		got := urlForMode(uri, test.mode)
+		if got != test.exp {
+			t.Errorf("urlForMode('%s', '%s') = '%s', expected '%s'", uri, test.mode, got, test.exp)
+		}
+		if back := absoluteURL(got); back != uri {
+			t.Errorf("absoluteURL('%s') = '%s', expected '%s'", got, back, uri)
+		}
+	}
+	if isValidURLMode("relative") {
+		t.Errorf("'relative' should not be a valid url mode")
+	}
+}
+
+func TestURLForModeInvalid(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("urlForMode() with invalid mode didn't panic")
+		}
+	}()
+	urlForMode(spacesURLBase+"a.exe", "relative")
+}
+
+func TestCreateSumatraLatestJsURLModes(t *testing.T) {
+	prevMode := flgLatestJsURLs
+	defer func() { flgLatestJsURLs = prevMode }()
+	prevZstd := flgZstd
+	defer func() { flgZstd = prevZstd }()
+	flgZstd = false
+
+	exe64 := "software/sumatrapdf/prerel/SumatraPDF-prerel-12345-64.exe"
+	tests := []struct {
+		mode string
+		exp  string
+	}{
+		{urlModeAbsolute, `"` + spacesURLBase + exe64 + `"`},
+		{urlModeProtocolRelative, `"//kjkpubsf.sfo2.digitaloceanspaces.com/` + exe64 + `"`},
+		{urlModePath, `"/` + exe64 + `"`},
+	}
+	for _, test := range tests {
+		flgLatestJsURLs = test.mode
+		js := createSumatraLatestJsForVer(buildTypePreRel, "12345", "abc", "2020-01-01", buildArchs{has64: true})
+		if !strings.Contains(js, "var sumLatestExe64       = "+test.exp+";") {
+			t.Errorf("%s: sumatralatest.js doesn't have %s:\n%s", test.mode, test.exp, js)
+		}
+		if strings.Contains(js, "var sumLatestExe ") {
+			t.Errorf("%s: sumatralatest.js has 32-bit build:\n%s", test.mode, js)
+		}
+	}
+}
+
+func TestS3GetRegion(t *testing.T) {
+	tests := []struct {
+		region string
+		name   string
+		exp    string
+	}{
+		{"", "us-east-1", "https://s3.amazonaws.com"},
+		{"us-east-1", "us-east-1", "https://s3.amazonaws.com"},
+		{"eu-west-1", "eu-west-1", "https://s3.eu-west-1.amazonaws.com"},
+		// not in goamz's aws.Regions
+		{"eu-north-1", "eu-north-1", "https://s3.eu-north-1.amazonaws.com"},
+		{"ap-southeast-3", "ap-southeast-3", "https://s3.ap-southeast-3.amazonaws.com"},
+	}
+	for _, test := range tests {
+		c := &S3Client{Region: test.region}
+		got := c.GetRegion()
+		if got.Name != test.name || got.S3Endpoint != test.exp {
+			t.Errorf("GetRegion() for '%s' = ('%s', '%s'), expected ('%s', '%s')", test.region, got.Name, got.S3Endpoint, test.name, test.exp)
+		}
+	}
+}
+
+func TestIsValidS3RegionName(t *testing.T) {
+	valid := []string{"us-east-1", "eu-north-1", "ap-southeast-3", "us-gov-west-1"}
+	for _, s := range valid {
+		if !isValidS3RegionName(s) {
+			t.Errorf("'%s' should be a valid s3 region name", s)
+		}
+	}
+	invalid := []string{"", "us-east", "US-EAST-1", "us_east_1", "https://s3.amazonaws.com"}
+	for _, s := range invalid {
+		if isValidS3RegionName(s) {
+			t.Errorf("'%s' should not be a valid s3 region name", s)
+		}
+	}
+}
+
+// download urls must point to where builds are uploaded, which can
+// differ from the name of the build type
+func TestGetDownloadHostUsesRemoteDir(t *testing.T) {
+	info := getBuildTypeInfo(buildTypeDaily)
+	prevRemoteDir := info.remoteDir
+	defer func() { info.remoteDir = prevRemoteDir }()
+	info.remoteDir = "nightly"
+	exp := spacesURLBase + "software/sumatrapdf/nightly"
+	if got := getDownloadHost(buildTypeDaily); got != exp {
+		t.Errorf("getDownloadHost() = '%s', expected '%s'", got, exp)
+	}
+	if !strings.HasPrefix(spacesURLBase+getRemoteDir(buildTypeDaily), exp+"/") {
+		t.Errorf("getRemoteDir() = '%s' is not under '%s'", getRemoteDir(buildTypeDaily), exp)
+	}
+}
@@ -1,32 +1,29 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path"
 	"path/filepath"
+	"regexp"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/kjk/u"
+	"github.com/minio/minio-go/v6"
 )
 
 // we delete old daily and pre-release builds. This defines how many most recent
-// builds to retain
-const nBuildsToRetainPreRel = 16
-const nBuildsToRetainDaily = 64
-const nBuildsToRetaininMicro = 32
-
-const (
-	buildTypeDaily   = "daily"
-	buildTypePreRel  = "prerel"
-	buildTypeRel     = "rel"
-	buildTypeRaMicro = "ramicro"
-)
-
 var (
 	rel32Dir   = filepath.Join("out", "rel32")
 	rel32XPDir = filepath.Join("out", "rel32_xp")
@@ -34,93 +31,297 @@ var (
 	rel64RaDir = filepath.Join("out", "rel64ra")
 )
 
-func getRemotePaths(buildType string) []string {
-	if buildType == buildTypePreRel {
-		return []string{
-			"software/sumatrapdf/sumatralatest.js",
-			"software/sumatrapdf/sumpdf-prerelease-latest.txt",
-			"software/sumatrapdf/sumpdf-prerelease-update.txt",
-		}
-	}
+// all remote paths of builds are under this prefix. Over-ride with
+// -remote-root e.g. to "staging/software/sumatrapdf/" so that uploads
+// don't collide with production
+var remoteRoot = "software/sumatrapdf/"
 
-	if buildType == buildTypeDaily {
-		return []string{
-			"software/sumatrapdf/sumadaily.js",
-			"software/sumatrapdf/sumpdf-daily-latest.txt",
-			"software/sumatrapdf/sumpdf-daily-update.txt",
-		}
-	}
+// all remote paths should be constructed with this so that there are no
+// double slashes or leading slash regardless of how parts (e.g. remoteRoot)
+// end
+func remoteJoin(elem ...string) string {
+	return strings.TrimPrefix(path.Join(elem...), "/")
+}
 
-	if buildType == buildTypeRaMicro {
-		return []string{
-			"software/sumatrapdf/ramicrolatest.js",
-			"software/sumatrapdf/ramicro-daily-latest.txt",
-			"software/sumatrapdf/ramicro-daily-update.txt",
-		}
+func newMinioClient() *u.MinioClient {
+	res := &u.MinioClient{
+		StorageKey:    os.Getenv("SPACES_KEY"),
+		StorageSecret: os.Getenv("SPACES_SECRET"),
+		Bucket:        "kjkpubsf",
+		Endpoint:      "sfo2.digitaloceanspaces.com",
 	}
+	res.EnsureConfigured()
+	// GetClient() caches the client so the transport is used
+	// for all requests
+	mc, err := res.GetClient()
+	panicIfErr(err)
+	mc.SetCustomTransport(getHTTPClient().Transport)
+	return res
+}
 
-	if buildType == buildTypeRel {
-		return []string{
-			"software/sumatrapdf/sumarellatest.js",
-			"software/sumatrapdf/release-latest.txt",
-			"software/sumatrapdf/release-update.txt",
+// storage operations used for uploading and managing builds.
+// Implemented by spacesStorage, can be replaced e.g. with a fake that
+// records the calls
+type minioStorage interface {
+	ListRemoteFiles(prefix string) ([]*minio.ObjectInfo, error)
+	// like ListRemoteFiles() but calls fn for each file as it's listed
+	// instead of collecting all of them. If fn returns an error or ctx
+	// is done, listing stops and the error is returned
+	ListRemoteFilesFunc(ctx context.Context, prefix string, fn func(oi *minio.ObjectInfo) error) error
+	StatObject(remotePath string) (minio.ObjectInfo, error)
+	DownloadFileAsData(remotePath string) ([]byte, error)
+	DownloadFileAtomically(dstPath string, remotePath string) error
+	// use getUploadPolicy() to get opts for a given remote path
+	UploadData(remotePath string, d []byte, opts minio.PutObjectOptions) error
+	UploadFileWithOptions(remotePath string, filePath string, opts minio.PutObjectOptions) error
+	// server-side copy of srcPath to dstPath, with metadata from opts
+	Copy(dstPath string, srcPath string, opts minio.PutObjectOptions) error
+	// returns url for downloading remotePath that works even if the
+	// file is not public, until expiry passes
+	PresignedURL(remotePath string, expiry time.Duration) (string, error)
+	Delete(remotePath string) error
+}
+
+// minioStorage backed by digital ocean spaces
+type spacesStorage struct {
+	*u.MinioClient
+}
+
+func newMinioStorage() minioStorage {
+	return &spacesStorage{newMinioClient()}
+}
+
+func (c *spacesStorage) ListRemoteFilesFunc(ctx context.Context, prefix string, fn func(oi *minio.ObjectInfo) error) error {
+	mc, err := c.GetClient()
+	if err != nil {
+		return err
+	}
+	// closing doneCh stops listing if we return early
+	doneCh := make(chan struct{})
+	defer close(doneCh)
+	files := mc.ListObjectsV2(c.Bucket, prefix, true, doneCh)
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case oi, ok := <-files:
+			if !ok {
+				return nil
+			}
+			if oi.Err != nil {
+				return oi.Err
+			}
+			err = fn(&oi)
+			if err != nil {
+				return err
+			}
 		}
 	}
-
-	panicIf(true, "Unkonwn buildType='%s'", buildType)
-	return nil
 }
 
-func isValidBuildType(buildType string) bool {
-	switch buildType {
-	case buildTypeDaily, buildTypePreRel, buildTypeRel, buildTypeRaMicro:
-		return true
+func (c *spacesStorage) UploadFileWithOptions(remotePath string, filePath string, opts minio.PutObjectOptions) error {
+	mc, err := c.GetClient()
+	if err != nil {
+		return err
 	}
-	return false
+	_, err = mc.FPutObject(c.Bucket, remotePath, filePath, opts)
+	return err
 }
 
-func getRemoteDir(buildType string) string {
-	panicIf(!isValidBuildType(buildType), "invalid build type: '%s'", buildType)
-	return "software/sumatrapdf/" + buildType + "/"
+func (c *spacesStorage) Copy(dstPath string, srcPath string, opts minio.PutObjectOptions) error {
+	mc, err := c.GetClient()
+	if err != nil {
+		return err
+	}
+	// copy doesn't preserve acl and we over-write content type
+	// because the source is a temporary file
+	meta := map[string]string{
+		"Content-Type":  opts.ContentType,
+		"Cache-Control": opts.CacheControl,
+	}
+	for k, v := range opts.UserMetadata {
+		meta[k] = v
+	}
+	dst, err := minio.NewDestinationInfo(c.Bucket, dstPath, opts.ServerSideEncryption, meta)
+	if err != nil {
+		return err
+	}
+	src := minio.NewSourceInfo(c.Bucket, srcPath, nil)
+	return mc.CopyObject(dst, src)
 }
 
-func newMinioClient() *u.MinioClient {
-	res := &u.MinioClient{
-		StorageKey:    os.Getenv("SPACES_KEY"),
-		StorageSecret: os.Getenv("SPACES_SECRET"),
-		Bucket:        "kjkpubsf",
-		Endpoint:      "sfo2.digitaloceanspaces.com",
+func (c *spacesStorage) PresignedURL(remotePath string, expiry time.Duration) (string, error) {
+	mc, err := c.GetClient()
+	if err != nil {
+		return "", err
 	}
-	res.EnsureConfigured()
-	return res
+	uri, err := mc.PresignedGetObject(c.Bucket, remotePath, expiry, nil)
+	if err != nil {
+		return "", err
+	}
+	return uri.String(), nil
 }
 
 func hasSpacesCreds() bool {
 	if os.Getenv("SPACES_KEY") == "" {
-		logf("Not uploading to do spaces because SPACES_KEY env variable not set\n")
+		logf("Skipping do spaces because SPACES_KEY env variable not set\n")
 		return false
 	}
 	if os.Getenv("SPACES_SECRET") == "" {
-		logf("Not uploading to do spaces because SPACES_SECRET env variable not set\n")
+		logf("Skipping do spaces because SPACES_SECRET env variable not set\n")
 		return false
 	}
 	return true
 }
 
 // TODO: add Exists() method to u.MinioClient to keep code closer to s3
-func minioExists(c *u.MinioClient, remotePath string) bool {
+func minioExists(c minioStorage, remotePath string) bool {
 	_, err := c.StatObject(remotePath)
 	return err == nil
 }
 
-func minioUploadDir(c *u.MinioClient, dirRemote string, dirLocal string) error {
+// returns true if err is from reading a file that doesn't exist, as
+// opposed to e.g. network or permission errors
+func isMinioNotFound(err error) bool {
+	return err != nil && minio.ToErrorResponse(err).Code == "NoSuchKey"
+}
+
+// name of manifest of version ver of buildType. The build writes it
+// (see copyBuiltManifest()) and we check it to tell if a build was
+// already uploaded so this is the only place that decides the name
+func manifestName(buildType string, ver string) string {
+	return getAppNameForBuildType(buildType) + "-" + ver + "-manifest.txt"
+}
+
+// remote path of manifest of version ver of buildType. Checking if a
+// build was already uploaded must look for exactly this file
+func getManifestRemotePath(buildType string, ver string) string {
+	return remoteJoin(getRemoteDir(buildType), manifestName(buildType, ver))
+}
+
+// we upload manifest last so that its presence in storage means
+// the whole build was uploaded
+func isManifestFile(name string) bool {
+	return strings.HasSuffix(name, "-manifest.txt")
+}
+
+func isNotManifestFile(name string) bool {
+	return !isManifestFile(name)
+}
+
+// catches uploading wrong files (e.g. uncompressed debug build)
+// by mistake. Over-ride with -max-upload-mb for legitimately large files
+func checkUploadSize(name string, size int64) error {
+	maxSize := int64(flgMaxUploadMB) * 1024 * 1024
+	if maxSize > 0 && size > maxSize {
+		return fmt.Errorf("'%s' is %s, more than max upload size of %s. Use -max-upload-mb if it's not a mistake", name, u.FmtSizeHuman(size), u.FmtSizeHuman(maxSize))
+	}
+	return nil
+}
+
+// content type, caching and acl are based on getUploadPolicy(remotePath).
+// If atomic is true, we upload to a temporary key, verify it and copy
+// it to remotePath on the server. That way clients reading remotePath
+// never see a partially written file
+func minioUploadData(c minioStorage, remotePath string, d []byte, atomic bool) error {
+	err := checkUploadSize(remotePath, int64(len(d)))
+	if err != nil {
+		return err
+	}
+	opts := getUploadPolicy(remotePath).putObjectOptions()
+	if !atomic {
+		err = c.UploadData(remotePath, d, opts)
+		if err != nil {
+			return err
+		}
+		return minioVerifyEncryption(c, remotePath)
+	}
+
+	tmpPath := remotePath + ".tmp"
+	err = c.UploadData(tmpPath, d, opts)
+	if err != nil {
+		return err
+	}
+	defer c.Delete(tmpPath)
+	uploaded, err := c.DownloadFileAsData(tmpPath)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(uploaded, d) {
+		return fmt.Errorf("content of '%s' doesn't match uploaded data", tmpPath)
+	}
+	err = c.Copy(remotePath, tmpPath, opts)
+	if err != nil {
+		return err
+	}
+	return minioVerifyEncryption(c, remotePath)
+}
+
+// uploads version info file (sumatralatest.js etc.) unless it already
+// has the same content. Re-uploading would needlessly invalidate caches
+func minioUploadVersionFile(c minioStorage, remotePath string, d []byte) error {
+	existing, err := c.DownloadFileAsData(remotePath)
+	if err == nil && bytes.Equal(existing, d) {
+		logf("Pointer unchanged: '%s'\n", remotePath)
+		return nil
+	}
+	err = minioUploadData(c, remotePath, d, true)
+	if err != nil {
+		return err
+	}
+	logf("Uploaded to spaces: '%s'\n", remotePath)
+	return nil
+}
+
+// files left by os or tools that we never upload, see -upload-ignore
+const defaultUploadIgnore = ".*,Thumbs.db,desktop.ini,*.tmp"
+
+// returns true if name matches one of comma-separated patterns
+// in flgUploadIgnore
+func isIgnoredUploadFile(name string) bool {
+	if isBuildDescFile(name) {
+		return true
+	}
+	for _, pattern := range strings.Split(flgUploadIgnore, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// if skip is not nil, we don't upload files for which it returns true.
+// We also don't upload files matching -upload-ignore
+func minioUploadDir(c minioStorage, dirRemote string, dirLocal string, skip func(name string) bool) error {
 	files, err := ioutil.ReadDir(dirLocal)
 	must(err)
+	// check sizes before uploading anything
+	for _, f := range files {
+		if skip != nil && skip(f.Name()) || isIgnoredUploadFile(f.Name()) {
+			continue
+		}
+		err = checkUploadSize(filepath.Join(dirLocal, f.Name()), f.Size())
+		if err != nil {
+			return err
+		}
+	}
 	for _, f := range files {
 		fname := f.Name()
+		if skip != nil && skip(fname) {
+			continue
+		}
 		pathLocal := filepath.Join(dirLocal, fname)
-		pathRemote := path.Join(dirRemote, fname)
-		err := c.UploadFilePublic(pathRemote, pathLocal)
+		if isIgnoredUploadFile(fname) {
+			logf("Not uploading '%s' because it matches -upload-ignore\n", pathLocal)
+			continue
+		}
+		pathRemote := remoteJoin(dirRemote, fname)
+		opts := getUploadPolicy(pathRemote).putObjectOptions()
+		err := c.UploadFileWithOptions(pathRemote, pathLocal, opts)
 		if err != nil {
 			return fmt.Errorf("failed spaces upload '%s' as '%s', err: %s", pathLocal, pathRemote, err)
 		}
@@ -129,18 +330,149 @@ func minioUploadDir(c *u.MinioClient, dirRemote string, dirLocal string) error {
 	return nil
 }
 
-func verifyBuildNotInSpacesShortMust(buildType string) {
+// checks that files in dirLocal were uploaded to dirRemote with the same
+// size. Reports all files that weren't
+func minioVerifyDirUploadedMust(c minioStorage, dirRemote string, dirLocal string, skip func(name string) bool) {
+	files, err := ioutil.ReadDir(dirLocal)
+	must(err)
+	errs := newMultiError("verify upload of files in '"+dirLocal+"'", 0)
+	for _, f := range files {
+		fname := f.Name()
+		if skip != nil && skip(fname) || isIgnoredUploadFile(fname) {
+			continue
+		}
+		remotePath := remoteJoin(dirRemote, fname)
+		oi, err := c.StatObject(remotePath)
+		if err != nil {
+			errs.AddForBackend(storageSpaces, remotePath, fmt.Errorf("wasn't uploaded, err: %s", err))
+		} else if oi.Size != f.Size() {
+			errs.AddForBackend(storageSpaces, remotePath, fmt.Errorf("has size %d, expected %d", oi.Size, f.Size()))
+		} else {
+			errs.AddForBackend(storageSpaces, remotePath, uploadSSE.verify(oi))
+		}
+	}
+	err = errs.ErrorOrNil()
+	fatalIf(err != nil, "%s\n", err)
+}
+
+// returns version of the build in dirLocal, from the name of its manifest
+func getLocalBuildVersion(dirLocal string) (int, error) {
+	files, err := ioutil.ReadDir(dirLocal)
+	if err != nil {
+		return 0, err
+	}
+	for _, f := range files {
+		if !isManifestFile(f.Name()) {
+			continue
+		}
+		ver, ok := parseVersionFromName(f.Name())
+		if !ok {
+			return 0, fmt.Errorf("no version in name of '%s'", f.Name())
+		}
+		return ver, nil
+	}
+	return 0, fmt.Errorf("no manifest in '%s'", dirLocal)
+}
+
+// etag is md5 of the content unless the file was uploaded in multiple parts
+func isMd5ETag(etag string) bool {
+	return len(etag) == 32 && !strings.Contains(etag, "-")
+}
+
+// checks that the build in dirLocal is exactly what is in storage: every
+// local file exists remotely with the same size (and md5, if etag is md5)
+// and there are no remote files of this version that are not local e.g.
+// left over from a previous, failed upload. Prints all problems
+func verifyReleaseParity(c minioStorage, buildType string, dirLocal string) error {
+	ver, err := getLocalBuildVersion(dirLocal)
+	if err != nil {
+		return err
+	}
 	dirRemote := getRemoteDir(buildType)
+	byVer, infos := minioListBuildsMust(c, buildType)
+	remote := map[string]*minio.ObjectInfo{}
+	for _, v := range byVer {
+		if v.ver != ver {
+			continue
+		}
+		for _, remotePath := range v.files {
+			// notes are in ${ver}/ directory and are not local
+			if remoteJoin(dirRemote, path.Base(remotePath)) == remotePath {
+				remote[path.Base(remotePath)] = infos[remotePath]
+			}
+		}
+	}
+
+	files, err := ioutil.ReadDir(dirLocal)
+	if err != nil {
+		return err
+	}
+	var missing, different []string
+	local := map[string]bool{}
+	for _, f := range files {
+		name := f.Name()
+		if f.IsDir() || isIgnoredUploadFile(name) {
+			continue
+		}
+		local[name] = true
+		oi := remote[name]
+		if oi == nil {
+			missing = append(missing, name)
+			continue
+		}
+		if oi.Size != f.Size() {
+			different = append(different, fmt.Sprintf("%s: size is %d, expected %d", name, oi.Size, f.Size()))
+			continue
+		}
+		etag := strings.Trim(oi.ETag, `"`)
+		if !isMd5ETag(etag) {
+			continue
+		}
+		sums, err := computeChecksums(filepath.Join(dirLocal, name), []crypto.Hash{crypto.MD5})
+		if err != nil {
+			return err
+		}
+		if sums[crypto.MD5] != etag {
+			different = append(different, fmt.Sprintf("%s: md5 is %s, expected %s", name, etag, sums[crypto.MD5]))
+		}
+	}
+	var unexpected []string
+	for name := range remote {
+		if !local[name] {
+			unexpected = append(unexpected, name)
+		}
+	}
+	sort.Strings(unexpected)
+
+	report := func(what string, names []string) {
+		for _, name := range names {
+			fmt.Printf("%s: %s\n", what, name)
+		}
+	}
+	report("missing remotely", missing)
+	report("unexpected remotely", unexpected)
+	report("different remotely", different)
+	if n := len(missing) + len(unexpected) + len(different); n > 0 {
+		return fmt.Errorf("version %d of '%s' in '%s' doesn't match '%s': %d missing, %d unexpected, %d different", ver, buildType, dirRemote, dirLocal, len(missing), len(unexpected), len(different))
+	}
+	fmt.Printf("%d files of version %d of '%s' in '%s' match '%s'\n", len(local), ver, buildType, dirRemote, dirLocal)
+	return nil
+}
+
+func verifyBuildNotInSpacesShortMust(buildType string) {
+	verifyBuildNotInStorageShortMust(newMinioStorage(), buildType)
+}
+
+// only check manifest which we upload last
+func verifyBuildNotInStorageShortMust(c minioStorage, buildType string) {
 	ver := getVerForBuildType(buildType)
-	fname := fmt.Sprintf("SumatraPDF-prerelease-%s-manifest.txt", ver)
-	remotePath := path.Join(dirRemote, fname)
-	c := newMinioClient()
+	remotePath := getManifestRemotePath(buildType, ver)
 	fatalIf(minioExists(c, remotePath), "build of type '%s' for ver '%s' already exists in s3 because file '%s' exists\n", buildType, ver, remotePath)
 }
 
 // we shouldn't re-upload files. We upload manifest-${ver}.txt last, so we
 // consider a pre-release build already present in s3 if manifest file exists
-func verifyBuildNotInSpacesMust(c *u.MinioClient, buildType string) {
+func verifyBuildNotInSpacesMust(c minioStorage, buildType string) {
 	if !flgUpload {
 		return
 	}
@@ -150,166 +482,1741 @@ func verifyBuildNotInSpacesMust(c *u.MinioClient, buildType string) {
 	panicIfErr(err)
 	for _, f := range files {
 		fname := f.Name()
-		remotePath := path.Join(dirRemote, fname)
+		remotePath := remoteJoin(dirRemote, fname)
 		fatalIf(minioExists(c, remotePath), "build from dir %s already exists in s3 because file '%s' exists\n", dirLocal, remotePath)
 	}
 }
 
-func getVersionFilesForLatestInfo(buildType string) [][]string {
-	panicIf(buildType == buildTypeRel)
-	remotePaths := getRemotePaths(buildType)
-	var res [][]string
-	s := createSumatraLatestJs(buildType)
-	res = append(res, []string{remotePaths[0], s})
-	ver := getVerForBuildType(buildType)
-	res = append(res, []string{remotePaths[1], ver})
-	// TOOD different for ramicro
-	s = fmt.Sprintf("[SumatraPDF]\nLatest %s\n", ver)
-	res = append(res, []string{remotePaths[2], s})
-	return res
+// which architectures a build has files for
+type buildArchs struct {
+	has32 bool
+	has64 bool
 }
 
-// https://kjkpubsf.sfo2.digitaloceanspaces.com/software/sumatrapdf/prerel/SumatraPDF-prerelease-1027-install.exe etc.
-func spacesUploadBuildMust(buildType string) {
-	if shouldSkipUpload() {
-		return
-	}
-	if !hasSpacesCreds() {
-		return
-	}
-
-	timeStart := time.Now()
-	c := newMinioClient()
-
-	dirRemote := getRemoteDir(buildType)
-	dirLocal := getFinalDirForBuildType(buildType)
-	//verifyBuildNotInSpaces(c, buildType)
-
-	err := minioUploadDir(c, dirRemote, dirLocal)
-	panicIfErr(err)
-
-	// for release build we don't upload files with version info
-	if buildType == buildTypeRel {
-		return
+// names of 64-bit files have "-64" in them e.g. SumatraPDF-prerel-12230-64.exe
+func detectBuildArchs(names []string) buildArchs {
+	var res buildArchs
+	for _, name := range names {
+		name = path.Base(name)
+		if !strings.HasSuffix(name, ".exe") {
+			continue
+		}
+		if strings.Contains(name, "-64") {
+			res.has64 = true
+		} else {
+			res.has32 = true
+		}
 	}
+	return res
+}
 
-	files := getVersionFilesForLatestInfo(buildType)
+func detectBuildArchsInDir(dir string) buildArchs {
+	files, err := ioutil.ReadDir(dir)
+	must(err)
+	var names []string
 	for _, f := range files {
-		remotePath := f[0]
-		err = c.UploadDataPublic(remotePath, []byte(f[1]))
-		panicIfErr(err)
-		logf("Uploaded to spaces: '%s'\n", remotePath)
+		names = append(names, f.Name())
 	}
+	archs := detectBuildArchs(names)
+	fatalIf(!archs.has32 && !archs.has64, "no 32-bit or 64-bit .exe files in '%s'\n", dir)
+	return archs
+}
 
-	logf("Uploaded the build to spaces in %s\n", time.Since(timeStart))
+// version info only advertises architectures for which there are
+// files in dirLocal
+func getVersionFilesForLatestInfo(buildType string, dirLocal string) [][]string {
+	ver := getVerForBuildType(buildType)
+	sha1 := getGitSha1()
+	archs := detectBuildArchsInDir(dirLocal)
+	info := manifestInfo{date: time.Now().UTC().Format(buildDateFormat)}
+	path := filepath.Join(dirLocal, manifestName(buildType, ver))
+	if st, err := os.Stat(path); err == nil {
+		info = parseManifestInfo(u.ReadFileMust(path), st.ModTime())
+	}
+	return getVersionFilesForLatestInfoForVer(buildType, ver, sha1, info, archs)
 }
 
-// "software/sumatrapdf/prerel/SumatraPDF-prerelease-11290-64-install.exe"
-// =>
-// 11290
-func extractVersionFromName(s string) int {
-	parts := strings.Split(s, "/")
-	name := parts[len(parts)-1]
-	// TODO: eventually we'll only need prerel- as prerelease-
-	// is older naming
-	name = strings.TrimPrefix(name, "SumatraPDF-prerelease-")
-	name = strings.TrimPrefix(name, "SumatraPDF-prerel-")
+// kinds of version info files, in the same order as getRemotePaths()
+const (
+	// sumatralatest.js etc. used by the website
+	versionFileJs = "js"
+	// *-latest.txt with just the version
+	versionFileLatest = "latest"
+	// *-update.txt read by the updater
+	versionFileUpdate = "update"
+)
 
-	name = strings.TrimPrefix(name, "RAMicro-prerelease-")
-	name = strings.TrimPrefix(name, "RAMicro-prerel-")
-	name = strings.TrimPrefix(name, "RAMicroPDFViewer-prerel-")
+var versionFileKinds = []string{versionFileJs, versionFileLatest, versionFileUpdate}
 
-	// TODO: temporary, for old builds in s3
-	name = strings.TrimPrefix(name, "SumatraPDF-prerelase-")
-	name = strings.TrimPrefix(name, "manifest-")
-	name = strings.TrimPrefix(name, "manifest")
-	if name == "" {
-		return 0
+// parses -version-files, comma-separated kinds of version info files
+func parseVersionFileKinds(s string) (map[string]bool, error) {
+	res := map[string]bool{}
+	for _, kind := range strings.Split(s, ",") {
+		kind = strings.TrimSpace(kind)
+		if !u.StringInSlice(versionFileKinds, kind) {
+			return nil, fmt.Errorf("invalid version info file '%s', must be one of %s", kind, strings.Join(versionFileKinds, ", "))
+		}
+		res[kind] = true
 	}
+	return res, nil
+}
 
-	parts = strings.Split(name, "-")
-	parts = strings.Split(parts[0], ".")
-	verStr := parts[0]
-	ver, err := strconv.Atoi(verStr)
-	if err != nil {
-		// TODO: temporary, for builds uploaded with bad names
-		//
-		return 1
+// minOSVersion is in the format of -min-os-version, see parseMinOSVersions()
+func genUpdateTxt(buildType string, ver string, minOSVersion string, archs buildArchs) string {
+	// TOOD different for ramicro
+	s := fmt.Sprintf("[SumatraPDF]\nLatest %s\n", ver)
+	if flgZstd && archs.has64 {
+		s += fmt.Sprintf("Zstd64 %s\n", getZstdDownloadURL(buildType, ver))
 	}
-	//panicIf(err != nil, "extractVersionFromName: '%s', err='%s'\n", s, err)
-	return ver
+	minOSVers, err := parseMinOSVersions(minOSVersion)
+	panicIfErr(err)
+	s += formatMinOSVersions(minOSVers, archs)
+	return s
 }
 
-type filesByVer struct {
-	ver   int
-	files []string
+// returns content of version info file of a given kind
+func genVersionFile(kind string, buildType string, ver string, sha1 string, info manifestInfo, archs buildArchs) string {
+	switch kind {
+	case versionFileJs:
+		return createSumatraLatestJsForVer(buildType, ver, sha1, info.date, archs)
+	case versionFileLatest:
+		return ver
+	case versionFileUpdate:
+		return genUpdateTxt(buildType, ver, info.minOSVersion, archs)
+	}
+	panicIf(true, "invalid version info file '%s'", kind)
+	return ""
 }
 
-func groupFilesByVersion(files []string) []*filesByVer {
-	m := map[int]*filesByVer{}
-	for _, f := range files {
-		ver := extractVersionFromName(f)
-		i := m[ver]
-		if i == nil {
-			i = &filesByVer{
-				ver: ver,
-			}
-			m[ver] = i
+// returns remote path and content of version info files selected
+// with -version-files (all of them by default)
+func getVersionFilesForLatestInfoForVer(buildType string, ver string, sha1 string, info manifestInfo, archs buildArchs) [][]string {
+	panicIf(buildType == buildTypeRel)
+	kinds, err := parseVersionFileKinds(flgVersionFiles)
+	panicIfErr(err)
+	remotePaths := getRemotePaths(buildType)
+	var res [][]string
+	for i, kind := range versionFileKinds {
+		if !kinds[kind] {
+			logf("Not updating '%s' because of -version-files\n", remotePaths[i])
+			continue
 		}
-		i.files = append(i.files, f)
-	}
-	res := []*filesByVer{}
-	for _, v := range m {
-		res = append(res, v)
+		s := genVersionFile(kind, buildType, ver, sha1, info, archs)
+		res = append(res, []string{remotePaths[i], s})
 	}
-	sort.Slice(res, func(i, j int) bool {
-		return res[i].ver > res[j].ver
-	})
 	return res
 }
 
-func minioDeleteOldBuildsPrefix(buildType string) {
-	panicIf(buildType == buildTypeRel, "can't delete release builds")
+// matches Windows versions like "6.1" or "10.0.19041"
+var osVersionRx = regexp.MustCompile(`^\d+(\.\d+)+$`)
 
-	nBuildsToRetain := nBuildsToRetainDaily
-	if buildType == buildTypePreRel {
-		nBuildsToRetain = nBuildsToRetainPreRel
+// parses -min-os-version which is either a version for all architectures
+// e.g. "6.1" or versions per architecture e.g. "32=6.1,64=10.0".
+// Returns map of architecture ("32", "64" or "" for all) to version
+func parseMinOSVersions(s string) (map[string]string, error) {
+	res := map[string]string{}
+	if s == "" {
+		return res, nil
 	}
-	if buildType == buildTypeRaMicro {
-		nBuildsToRetain = nBuildsToRetaininMicro
+	if osVersionRx.MatchString(s) {
+		res[""] = s
+		return res, nil
 	}
-	remoteDir := getRemoteDir(buildType)
+	for _, part := range strings.Split(s, ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 || (kv[0] != "32" && kv[0] != "64") {
+			return nil, fmt.Errorf("invalid '%s' in '%s', expected ${ver} or 32=${ver},64=${ver}", part, s)
+		}
+		if !osVersionRx.MatchString(kv[1]) {
+			return nil, fmt.Errorf("invalid Windows version '%s' in '%s'", kv[1], s)
+		}
+		res[kv[0]] = kv[1]
+	}
+	return res, nil
+}
 
-	c := newMinioClient()
-	files, err := c.ListRemoteFiles(remoteDir)
-	must(err)
-	fmt.Printf("%d minio files under '%s'\n", len(files), remoteDir)
-	var keys []string
-	for _, f := range files {
-		keys = append(keys, f.Key)
-		//fmt.Printf("key: %s\n", f.Key)
+// returns "MinOSVersion ${ver}" line or "MinOSVersion32 ${ver}" and
+// "MinOSVersion64 ${ver}" lines for architectures the build has.
+// Updaters that don't know them ignore them
+func formatMinOSVersions(vers map[string]string, archs buildArchs) string {
+	s := ""
+	if ver := vers[""]; ver != "" {
+		s += fmt.Sprintf("MinOSVersion %s\n", ver)
 	}
-	byVer := groupFilesByVersion(keys)
-	for i, v := range byVer {
-		deleting := (i >= nBuildsToRetain)
-		if deleting {
-			fmt.Printf("%d, deleting\n", v.ver)
-			for _, fn := range v.files {
-				fmt.Printf("  %s deleting\n", fn)
-				err := c.Delete(fn)
-				must(err)
-			}
-		} else {
-			//fmt.Printf("%d, not deleting\n", v.ver)
-			// for _, fn := range v.files {
-			// 	fmt.Printf("  %s not deleting\n", fn)
-			// }
-		}
+	if ver := vers["32"]; ver != "" && archs.has32 {
+		s += fmt.Sprintf("MinOSVersion32 %s\n", ver)
 	}
+	if ver := vers["64"]; ver != "" && archs.has64 {
+		s += fmt.Sprintf("MinOSVersion64 %s\n", ver)
+	}
+	return s
 }
 
-func minioDeleteOldBuilds() {
-	minioDeleteOldBuildsPrefix(buildTypePreRel)
-	minioDeleteOldBuildsPrefix(buildTypeDaily)
-	minioDeleteOldBuildsPrefix(buildTypeRaMicro)
+// returns an error if uploading incomingBytes would make files of buildType
+// take more than quotaBytes in storage
+func enforceQuota(c minioStorage, buildType string, incomingBytes int64, quotaBytes int64) error {
+	_, infos := minioListBuildsMust(c, buildType)
+	var total int64
+	for _, oi := range infos {
+		total += oi.Size
+	}
+	after := total + incomingBytes
+	if after > quotaBytes {
+		return fmt.Errorf("uploading %s would make '%s' take %s, over the quota of %s. Run -delete-old-builds to free space", u.FmtSizeHuman(incomingBytes), buildType, u.FmtSizeHuman(after), u.FmtSizeHuman(quotaBytes))
+	}
+	logf("'%s' will take %s out of quota of %s\n", buildType, u.FmtSizeHuman(after), u.FmtSizeHuman(quotaBytes))
+	return nil
+}
+
+// it's outside of getRemoteDir() so that it's not seen as part of a build
+func getUploadLockPath(buildType string) string {
+	return remoteJoin(remoteRoot, buildType+".lock")
+}
+
+// prevents concurrent uploads of the same build type, which could leave
+// version info pointing to a mix of builds. A lock older than
+// flgUploadLockMaxAge is considered stale and over-ridden.
+// Returns a function that releases the lock.
+func minioAcquireUploadLockMust(c minioStorage, buildType string) func() {
+	lockPath := getUploadLockPath(buildType)
+	oi, err := c.StatObject(lockPath)
+	if err == nil {
+		age := time.Since(oi.LastModified)
+		fatalIf(age < flgUploadLockMaxAge, "upload of '%s' in progress because '%s' exists and is %s old. If it's stale, use -upload-lock-max-age\n", buildType, lockPath, age)
+		logf("Over-riding stale lock '%s' which is %s old\n", lockPath, age)
+	}
+	s := fmt.Sprintf("ver: %s\ntime: %s\n", getVerForBuildType(buildType), time.Now().Format(time.RFC3339))
+	err = minioUploadData(c, lockPath, []byte(s), false)
+	panicIfErr(err)
+	logf("Acquired lock '%s'\n", lockPath)
+	return func() {
+		err := c.Delete(lockPath)
+		if err != nil {
+			logf("Failed to delete lock '%s', err: %s\n", lockPath, err)
+			return
+		}
+		logf("Released lock '%s'\n", lockPath)
+	}
+}
+
+// writes ${name}.${algo} checksum files, in the format of sha256sum etc.,
+// for files in dir so that they're uploaded with the build
+func writeChecksumFiles(dir string, algos []crypto.Hash) error {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		name := f.Name()
+		if _, ok := checksumFileAlgo(name); ok || f.IsDir() || isManifestFile(name) || isIgnoredUploadFile(name) {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		sums, err := computeChecksums(path, algos)
+		if err != nil {
+			return err
+		}
+		for _, algo := range algos {
+			s := fmt.Sprintf("%s  %s\n", sums[algo], name)
+			err = ioutil.WriteFile(path+"."+checksumAlgoName(algo), []byte(s), 0644)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// https://kjkpubsf.sfo2.digitaloceanspaces.com/software/sumatrapdf/prerel/SumatraPDF-prerelease-1027-install.exe etc.
+// dirLocal over-rides the directory we upload from. If empty, we use
+// getFinalDirForBuildType()
+func spacesUploadBuildMust(buildType string, dirLocal string) {
+	if shouldSkipUpload() {
+		return
+	}
+	if !hasSpacesCreds() {
+		return
+	}
+	var onlyFiles []string
+	for _, name := range strings.Split(flgUploadOnly, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			onlyFiles = append(onlyFiles, name)
+		}
+	}
+	minioUploadBuild(newMinioStorage(), buildType, dirLocal, onlyFiles)
+}
+
+// uploads only files in dirLocal named in onlyFiles e.g. to replace
+// a corrupted file of an already published build. Doesn't upload notes
+// and version info files
+func minioUploadOnlyFiles(c minioStorage, buildType string, dirLocal string, onlyFiles []string) {
+	only := map[string]bool{}
+	for _, name := range onlyFiles {
+		path := filepath.Join(dirLocal, name)
+		fatalIf(!u.FileExists(path), "'%s' given in -upload-only doesn't exist\n", path)
+		only[name] = true
+	}
+	skip := func(name string) bool {
+		return !only[name]
+	}
+	defer minioAcquireUploadLockMust(c, buildType)()
+	dirRemote := getRemoteDir(buildType)
+	err := minioUploadDir(c, dirRemote, dirLocal, skip)
+	panicIfErr(err)
+	minioVerifyDirUploadedMust(c, dirRemote, dirLocal, skip)
+	logf("Uploaded %d files of '%s' build to spaces\n", len(onlyFiles), buildType)
+}
+
+// uploads the build and version info files to c
+// with onlyFiles, only those files are uploaded (see minioUploadOnlyFiles())
+func minioUploadBuild(c minioStorage, buildType string, dirLocal string, onlyFiles []string) {
+	timeStart := time.Now()
+	dirRemote := getRemoteDir(buildType)
+	if dirLocal == "" {
+		dirLocal = getFinalDirForBuildType(buildType)
+	}
+	err := verifyBuildMatchesBuildType(dirLocal, buildType)
+	panicIfErr(err)
+	err = verifyBuildDesc(dirLocal, buildType)
+	panicIfErr(err)
+	verifyGitStateForReleaseMust(buildType)
+	algos, err := parseChecksumAlgos(flgChecksums)
+	panicIfErr(err)
+	err = writeChecksumFiles(dirLocal, algos)
+	panicIfErr(err)
+	if len(onlyFiles) > 0 {
+		minioUploadOnlyFiles(c, buildType, dirLocal, onlyFiles)
+		return
+	}
+	if flgUploadQuotaMB > 0 {
+		quota := int64(flgUploadQuotaMB) * 1024 * 1024
+		err := enforceQuota(c, buildType, dirSizeMust(dirLocal), quota)
+		panicIfErr(err)
+	}
+
+	defer minioAcquireUploadLockMust(c, buildType)()
+	// uploadBuildToAll() already skipped builds from the same sha1. This
+	// is for the build notes
+	prevSha1, err := minioGetLatestBuildSha1(c, buildType)
+	panicIfErr(err)
+	if !flgForceUpload && buildType != buildTypeRel {
+		err := verifyVersionIsNewer(c, buildType, getVerForBuildType(buildType))
+		panicIfErr(err)
+	}
+	logf("Uploading to spaces from '%s'\n", dirLocal)
+	//verifyBuildNotInSpaces(c, buildType)
+
+	err = minioUploadDir(c, dirRemote, dirLocal, isManifestFile)
+	panicIfErr(err)
+	minioVerifyDirUploadedMust(c, dirRemote, dirLocal, isManifestFile)
+	notesPath := getNotesRemotePath(buildType, getVerForBuildType(buildType))
+	err = minioUploadData(c, notesPath, []byte(getBuildNotes(prevSha1)), false)
+	panicIfErr(err)
+	logf("Uploaded to spaces: '%s'\n", notesPath)
+	err = minioUploadDir(c, dirRemote, dirLocal, isNotManifestFile)
+	panicIfErr(err)
+	err = verifyReleaseParity(c, buildType, dirLocal)
+	panicIfErr(err)
+	if flgUploadSymbols {
+		err = minioUploadSymbols(c, dirLocal)
+		panicIfErr(err)
+	}
+	metrics.Count(metricName("upload_bytes", buildType), dirSizeMust(dirLocal))
+	gaugeDuration(metricName("upload_duration_seconds", buildType), time.Since(timeStart))
+
+	// for release build we don't upload files with version info
+	if buildType == buildTypeRel {
+		return
+	}
+
+	files := getVersionFilesForLatestInfo(buildType, dirLocal)
+	for _, f := range files {
+		err = minioUploadVersionFile(c, f[0], []byte(f[1]))
+		panicIfErr(err)
+	}
+	err = minioUploadBuildFeed(c, buildType)
+	panicIfErr(err)
+
+	logf("Uploaded the build to spaces in %s\n", time.Since(timeStart))
+}
+
+// returns value from "${key}: ${value}" line in manifest content
+// or "" if not found
+func getManifestValue(d []byte, key string) string {
+	prefix := key + ": "
+	for _, l := range toTrimmedLines(d) {
+		if strings.HasPrefix(l, prefix) {
+			return strings.TrimPrefix(l, prefix)
+		}
+	}
+	return ""
+}
+
+// format of "date: ${date}" line in manifest and sumBuiltOn in sumatralatest.js
+const buildDateFormat = "2006-01-02"
+
+// information about a build, recorded in its manifest, that goes into
+// version info files. Because it travels with the build, version info
+// files re-generated for an older build describe that build and not
+// the current flags
+type manifestInfo struct {
+	// date of the build, from "date: ${date}" line. Manifests of older
+	// builds don't have it so it's the time the manifest was written or
+	// uploaded
+	date string
+	// -min-os-version of the build, from "min-os-version: ${ver}" line.
+	// Empty if not given
+	minOSVersion string
+}
+
+// modTime is the time the manifest was written or uploaded
+func parseManifestInfo(d []byte, modTime time.Time) manifestInfo {
+	res := manifestInfo{
+		date:         getManifestValue(d, "date"),
+		minOSVersion: getManifestValue(d, "min-os-version"),
+	}
+	if res.date == "" {
+		res.date = modTime.UTC().Format(buildDateFormat)
+	}
+	return res
+}
+
+// returns manifestInfo of version ver of buildType in c. For builds
+// without a manifest, which we don't upload anymore, date is today
+func minioGetManifestInfo(c minioStorage, buildType string, ver string) manifestInfo {
+	remotePath := getManifestRemotePath(buildType, ver)
+	oi, err := c.StatObject(remotePath)
+	if isMinioNotFound(err) {
+		logf("'%s' doesn't exist, using today as the date of the build\n", remotePath)
+		return manifestInfo{date: time.Now().UTC().Format(buildDateFormat)}
+	}
+	panicIfErr(err)
+	d, err := c.DownloadFileAsData(remotePath)
+	panicIfErr(err)
+	return parseManifestInfo(d, oi.LastModified)
+}
+
+// returns version from "ver: ${ver}" line in manifest content
+// or 0 if not found
+func extractVersionFromManifest(d []byte) int {
+	ver, err := strconv.Atoi(getManifestValue(d, "ver"))
+	if err != nil {
+		return 0
+	}
+	return ver
+}
+
+// manifest has a "${algo} ${name}: ${size} ${hash}" line for each
+// uploaded file of the build and each -checksums algorithm (see
+// checksumAlgos and copyBuiltManifest())
+type manifestFileHash struct {
+	size int64
+	// hex digests by algorithm
+	sums map[crypto.Hash]string
+}
+
+// returns sizes and hashes of files recorded in manifest, by file name
+func parseManifestHashes(d []byte) (map[string]manifestFileHash, error) {
+	res := map[string]manifestFileHash{}
+	for _, l := range toTrimmedLines(d) {
+		algoName := strings.SplitN(l, " ", 2)[0]
+		algo, ok := checksumAlgos[algoName]
+		if !ok {
+			continue
+		}
+		parts := strings.SplitN(strings.TrimPrefix(l, algoName+" "), ": ", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid line '%s'", l)
+		}
+		fields := strings.Fields(parts[1])
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid line '%s'", l)
+		}
+		size, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid size in line '%s'", l)
+		}
+		name := parts[0]
+		h, ok := res[name]
+		if !ok {
+			h = manifestFileHash{size: size, sums: map[crypto.Hash]string{}}
+		}
+		if h.size != size {
+			return nil, fmt.Errorf("size of '%s' in line '%s' differs from %d in other lines", name, l, h.size)
+		}
+		h.sums[algo] = fields[1]
+		res[name] = h
+	}
+	return res, nil
+}
+
+// "unzipped ${name}: ${size}" lines in manifest are uncompressed sizes
+// of .zip files, see -zip-sizes
+const manifestUnzippedPrefix = "unzipped "
+
+// returns uncompressed sizes of .zip files recorded in manifest, by file name
+func parseManifestUnzippedSizes(d []byte) (map[string]int64, error) {
+	res := map[string]int64{}
+	for _, l := range toTrimmedLines(d) {
+		if !strings.HasPrefix(l, manifestUnzippedPrefix) {
+			continue
+		}
+		parts := strings.SplitN(strings.TrimPrefix(l, manifestUnzippedPrefix), ": ", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid line '%s'", l)
+		}
+		size, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid size in line '%s'", l)
+		}
+		res[parts[0]] = size
+	}
+	return res, nil
+}
+
+// notes describing what changed in a given version are stored
+// in ${buildType}/${ver}/notes.txt
+func getNotesRemotePath(buildType string, ver string) string {
+	return remoteJoin(getRemoteDir(buildType), ver, "notes.txt")
+}
+
+// returns content of -notes-file if given or a git log of changes
+// since prevSha1
+func getBuildNotes(prevSha1 string) string {
+	if flgNotesFile != "" {
+		return string(u.ReadFileMust(flgNotesFile))
+	}
+	return getGitLogSince(prevSha1)
+}
+
+// returns git sha1 from manifest of the latest build in c
+// or "" if there are no builds or the manifest doesn't have it
+func minioGetLatestBuildSha1(c minioStorage, buildType string) (string, error) {
+	ver, err := minioLatestVersion(c, buildType)
+	if errors.Is(err, errNoBuilds) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	d, err := c.DownloadFileAsData(getManifestRemotePath(buildType, strconv.Itoa(ver)))
+	if isMinioNotFound(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return getManifestValue(d, "sha1"), nil
+}
+
+// "software/sumatrapdf/prerel/SumatraPDF-prerelease-11290-64-install.exe"
+// =>
+// 11290
+// Files in a "${getRemoteDir()}/${ver}/" directory get the version from
+// the directory e.g. "software/sumatrapdf/prerel/11290/SumatraPDF-prerel-64.exe"
+// returns 0 for names without version and 1 for names we couldn't parse
+func extractVersionFromName(s string) int {
+	ver, ok, stripped := parseVersionFromNameVerbose(s)
+	if flgVerbose {
+		how := "parsed"
+		if !ok {
+			how = "fallback"
+		}
+		logf("extractVersionFromName: '%s', stripped: [%s], ver: %d (%s)\n", s, strings.Join(stripped, ", "), ver, how)
+	}
+	return ver
+}
+
+// like extractVersionFromName() but returns false if s doesn't have
+// a valid version in it
+func parseVersionFromName(s string) (int, bool) {
+	ver, ok, _ := parseVersionFromNameVerbose(s)
+	return ver, ok
+}
+
+// prefixes of file names that are stripped, in order, before parsing
+// the version
+var versionNamePrefixes = []string{
+	// TODO: eventually we'll only need prerel- as prerelease-
+	// is older naming
+	"SumatraPDF-prerelease-",
+	"SumatraPDF-prerel-",
+
+	"RAMicro-prerelease-",
+	"RAMicro-prerel-",
+	"RAMicroPDFViewer-prerel-",
+
+	// TODO: temporary, for old builds in s3
+	"SumatraPDF-prerelase-",
+	"manifest-",
+	"manifest",
+}
+
+// like parseVersionFromName() but also returns the prefixes that
+// were stripped from the name, for debugging
+func parseVersionFromNameVerbose(s string) (int, bool, []string) {
+	parts := strings.Split(s, "/")
+	name := parts[len(parts)-1]
+	// "${ver}/notes.txt" (see getNotesRemotePath()) or a build uploaded with
+	// version in the directory. It's checked before the name because names
+	// in such directories, like "SumatraPDF-prerel-64.exe", would parse
+	// the architecture as the version
+	if len(parts) > 1 {
+		if ver, err := strconv.Atoi(parts[len(parts)-2]); err == nil {
+			return ver, true, nil
+		}
+	}
+	var stripped []string
+	for _, prefix := range versionNamePrefixes {
+		if strings.HasPrefix(name, prefix) {
+			name = strings.TrimPrefix(name, prefix)
+			stripped = append(stripped, prefix)
+		}
+	}
+	if name == "" {
+		return 0, false, stripped
+	}
+
+	parts = strings.Split(name, "-")
+	parts = strings.Split(parts[0], ".")
+	verStr := parts[0]
+	ver, err := strconv.Atoi(verStr)
+	if err != nil {
+		// TODO: temporary, for builds uploaded with bad names
+		//
+		return 1, false, stripped
+	}
+	//panicIf(err != nil, "extractVersionFromName: '%s', err='%s'\n", s, err)
+	return ver, true, stripped
+}
+
+type filesByVer struct {
+	ver   int
+	files []string
+	// newest modification time of files, zero if not known. Kept per
+	// version so that retention doesn't need information about every file
+	lastModified time.Time
+}
+
+func groupFilesByVersion(files []string) []*filesByVer {
+	return groupFilesByVersionFunc(files, extractVersionFromName)
+}
+
+func groupFilesByVersionFunc(files []string, getVer func(string) int) []*filesByVer {
+	g := newVersionGrouper()
+	for _, f := range files {
+		g.add(getVer(f), f, time.Time{})
+	}
+	return g.result()
+}
+
+// groups files by version as they arrive so that a listing can be grouped
+// while it's streamed, without first collecting all keys
+type versionGrouper struct {
+	byVer map[int]*filesByVer
+}
+
+func newVersionGrouper() *versionGrouper {
+	return &versionGrouper{
+		byVer: map[int]*filesByVer{},
+	}
+}
+
+func (g *versionGrouper) add(ver int, file string, lastModified time.Time) {
+	i := g.byVer[ver]
+	if i == nil {
+		i = &filesByVer{
+			ver: ver,
+		}
+		g.byVer[ver] = i
+	}
+	i.files = append(i.files, file)
+	if lastModified.After(i.lastModified) {
+		i.lastModified = lastModified
+	}
+}
+
+// returns groups sorted by version, most recent first
+func (g *versionGrouper) result() []*filesByVer {
+	res := []*filesByVer{}
+	for _, v := range g.byVer {
+		res = append(res, v)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].ver > res[j].ver
+	})
+	return res
+}
+
+// like groupFilesByVersion() but also returns information (size,
+// modification time) about each file, keyed by remote path.
+// If there are no builds, returns an empty slice
+// The listing is grouped as it's streamed and we only keep the parts of
+// minio.ObjectInfo we use, so that memory use stays reasonable for
+// prefixes with tens of thousands of files
+func minioListBuildsMust(c minioStorage, buildType string) ([]*filesByVer, map[string]*minio.ObjectInfo) {
+	return minioListBuildsCtxMust(context.Background(), c, buildType)
+}
+
+// like minioListBuildsMust() but listing stops with a panic when ctx is done
+func minioListBuildsCtxMust(ctx context.Context, c minioStorage, buildType string) ([]*filesByVer, map[string]*minio.ObjectInfo) {
+	infos := map[string]*minio.ObjectInfo{}
+	byVer := minioGroupBuildsCtxMust(ctx, c, buildType, func(oi *minio.ObjectInfo) {
+		infos[oi.Key] = &minio.ObjectInfo{
+			Key:          oi.Key,
+			Size:         oi.Size,
+			LastModified: oi.LastModified,
+			ETag:         oi.ETag,
+		}
+	})
+	return byVer, infos
+}
+
+// lists builds of buildType grouped by version, as the listing is
+// streamed. Only names of files and the newest modification time of each
+// version are kept. If fn is not nil, it's called with each listed file so
+// that callers that need more information can keep it.
+// Listing stops with a panic when ctx is done
+func minioGroupBuildsCtxMust(ctx context.Context, c minioStorage, buildType string, fn func(oi *minio.ObjectInfo)) []*filesByVer {
+	remoteDir := getRemoteDir(buildType)
+	g := newVersionGrouper()
+	nFiles := 0
+	err := c.ListRemoteFilesFunc(ctx, remoteDir, func(oi *minio.ObjectInfo) error {
+		key := oi.Key
+		nFiles++
+		if fn != nil {
+			fn(oi)
+		}
+		ver := extractVersionFromName(key)
+		if _, ok := parseVersionFromName(key); !ok && flgVersionFromManifest && isManifestFile(key) {
+			// file names that can't be parsed get version 0 or 1. For
+			// such manifests, try to get the version from their content
+			d, err := c.DownloadFileAsData(key)
+			if err != nil {
+				return err
+			}
+			if manifestVer := extractVersionFromManifest(d); manifestVer != 0 {
+				logf("Got version %d from content of '%s'\n", manifestVer, key)
+				ver = manifestVer
+			}
+		}
+		g.add(ver, key, oi.LastModified)
+		return nil
+	})
+	must(err)
+	if nFiles == 0 {
+		fmt.Printf("no builds under '%s'\n", remoteDir)
+		return nil
+	}
+	fmt.Printf("%d minio files under '%s'\n", nFiles, remoteDir)
+	return g.result()
+}
+
+// number of files in builds
+func countFiles(byVer []*filesByVer) int {
+	n := 0
+	for _, v := range byVer {
+		n += len(v.files)
+	}
+	return n
+}
+
+type buildFileJSON struct {
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"lastModified"`
+	// for .zip files, with -zip-sizes, if recorded in manifest
+	UnzippedSize int64 `json:"unzippedSize,omitempty"`
+}
+
+type buildJSON struct {
+	Ver   int              `json:"ver"`
+	Files []*buildFileJSON `json:"files"`
+}
+
+// returns uncompressed sizes of .zip files recorded in manifest (if any)
+// among files of a build
+func minioGetUnzippedSizesMust(c minioStorage, files []string) map[string]int64 {
+	for _, remotePath := range files {
+		if !isManifestFile(remotePath) {
+			continue
+		}
+		d, err := c.DownloadFileAsData(remotePath)
+		must(err)
+		res, err := parseManifestUnzippedSizes(d)
+		must(err)
+		return res
+	}
+	return map[string]int64{}
+}
+
+// prints builds of a given type in spaces, most recent first
+func minioListBuilds(buildType string, asJSON bool) {
+	panicIf(!isValidBuildType(buildType), "invalid build type: '%s'", buildType)
+	c := newMinioStorage()
+	byVer, infos := minioListBuildsMust(c, buildType)
+	if !asJSON {
+		for _, v := range byVer {
+			fmt.Printf("%d:\n", v.ver)
+			for _, remotePath := range v.files {
+				oi := infos[remotePath]
+				fmt.Printf("  %s %s %s\n", remotePath, u.FmtSizeHuman(oi.Size), oi.LastModified.Format("2006-01-02"))
+			}
+		}
+		return
+	}
+
+	res := []*buildJSON{}
+	for _, v := range byVer {
+		b := &buildJSON{
+			Ver: v.ver,
+		}
+		unzippedSizes := map[string]int64{}
+		if flgZipSizes {
+			unzippedSizes = minioGetUnzippedSizesMust(c, v.files)
+		}
+		for _, remotePath := range v.files {
+			oi := infos[remotePath]
+			f := &buildFileJSON{
+				Key:          remotePath,
+				Size:         oi.Size,
+				LastModified: oi.LastModified,
+				UnzippedSize: unzippedSizes[path.Base(remotePath)],
+			}
+			b.Files = append(b.Files, f)
+		}
+		res = append(res, b)
+	}
+	d, err := json.MarshalIndent(res, "", "  ")
+	must(err)
+	fmt.Printf("%s\n", d)
+}
+
+// lists versions that should never be deleted, one per line. Lines
+// starting with '#' are comments
+func getPinnedPath(buildType string) string {
+	return remoteJoin(remoteRoot, buildType+"-pinned.txt")
+}
+
+func parsePinnedVersions(d []byte) (map[int]bool, error) {
+	res := map[int]bool{}
+	for _, l := range toTrimmedLines(d) {
+		if strings.HasPrefix(l, "#") {
+			continue
+		}
+		ver, err := strconv.Atoi(l)
+		if err != nil {
+			return nil, fmt.Errorf("invalid version '%s'", l)
+		}
+		res[ver] = true
+	}
+	return res, nil
+}
+
+// lists versions that were rolled back with -rollback-mark-bad, in the
+// same format as pinned versions. Version info is never re-generated
+// for them
+func getBadVersionsPath(buildType string) string {
+	return remoteJoin(remoteRoot, buildType+"-bad.txt")
+}
+
+func minioReadPinnedVersionsMust(c minioStorage, buildType string) map[int]bool {
+	return minioReadVersionsMust(c, getPinnedPath(buildType))
+}
+
+func minioReadBadVersionsMust(c minioStorage, buildType string) map[int]bool {
+	return minioReadVersionsMust(c, getBadVersionsPath(buildType))
+}
+
+// reads a list of versions in the format of getPinnedPath(). If it
+// doesn't exist, the list is empty
+func minioReadVersionsMust(c minioStorage, remotePath string) map[int]bool {
+	if !minioExists(c, remotePath) {
+		return map[int]bool{}
+	}
+	d, err := c.DownloadFileAsData(remotePath)
+	panicIfErr(err)
+	res, err := parsePinnedVersions(d)
+	panicIfErr(err, "failed to parse '%s', err: %s", remotePath, err)
+	return res
+}
+
+// which builds are kept when deleting old builds
+type retentionPolicy struct {
+	// we keep this many most recent builds
+	nRetain int
+	// if > 0, we also keep builds with files newer than this
+	minAge time.Duration
+}
+
+// over-ridable with -retain-builds and -retain-min-age
+func getRetentionPolicy(buildType string) retentionPolicy {
+	res := retentionPolicy{
+		nRetain: getBuildTypeInfo(buildType).nRetain,
+		minAge:  flgRetainMinAge,
+	}
+	if flgRetainBuilds > 0 {
+		res.nRetain = flgRetainBuilds
+	}
+	return res
+}
+
+// returns versions in byVer that are safe to delete because they're not
+// the version latest points to (0 if not known) and are not pinned.
+// Oldest first
+func getPruneCandidates(byVer []*filesByVer, latest int, pinned map[int]bool) []int {
+	var res []int
+	for _, v := range byVer {
+		if v.ver == latest || pinned[v.ver] {
+			continue
+		}
+		res = append(res, v.ver)
+	}
+	sort.Ints(res)
+	return res
+}
+
+// returns version that *-latest.txt of buildType points to or 0
+// if it doesn't exist. Other errors, like failing to read it, are returned
+func minioReadLatestVersion(c minioStorage, buildType string) (int, error) {
+	latestPath := getRemotePaths(buildType)[1]
+	d, err := c.DownloadFileAsData(latestPath)
+	if isMinioNotFound(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	ver, err := strconv.Atoi(strings.TrimSpace(string(d)))
+	if err != nil {
+		return 0, fmt.Errorf("invalid version in '%s': '%s'", latestPath, string(d))
+	}
+	return ver, nil
+}
+
+// returns versions of buildType in storage that are neither latest nor
+// pinned, oldest first. Only those are deleted by retention
+func pruneCandidates(c minioStorage, buildType string) ([]int, error) {
+	byVer, _ := minioListBuildsMust(c, buildType)
+	return pruneCandidatesForBuilds(c, buildType, byVer)
+}
+
+func pruneCandidatesForBuilds(c minioStorage, buildType string, byVer []*filesByVer) ([]int, error) {
+	latest, err := minioReadLatestVersion(c, buildType)
+	if err != nil {
+		return nil, err
+	}
+	pinned := minioReadPinnedVersionsMust(c, buildType)
+	return getPruneCandidates(byVer, latest, pinned), nil
+}
+
+// prints versions of buildType that retention is allowed to delete
+// (see pruneCandidates())
+func printPruneCandidates(buildType string, asJSON bool) {
+	panicIf(!isValidBuildType(buildType) || buildType == buildTypeRel, "invalid build type: '%s'", buildType)
+	c := newMinioStorage()
+	vers, err := pruneCandidates(c, buildType)
+	must(err)
+	if asJSON {
+		if vers == nil {
+			vers = []int{}
+		}
+		d, err := json.MarshalIndent(vers, "", "  ")
+		must(err)
+		fmt.Printf("%s\n", d)
+		return
+	}
+	for _, ver := range vers {
+		fmt.Printf("%d\n", ver)
+	}
+}
+
+// decides which files to delete. Only builds with versions in candidates
+// (see getPruneCandidates()) are deleted. Doesn't talk to the network so
+// that it can be run on a saved listing (see -preview-retention).
+// Returns remote paths of files to delete and versions of deleted builds
+func getFilesToDelete(byVer []*filesByVer, policy retentionPolicy, candidates []int, now time.Time) ([]string, []int) {
+	isCandidate := map[int]bool{}
+	for _, ver := range candidates {
+		isCandidate[ver] = true
+	}
+	var toDelete []string
+	var vers []int
+	for i, v := range byVer {
+		if i < policy.nRetain {
+			continue
+		}
+		if !isCandidate[v.ver] {
+			fmt.Printf("%d, latest or pinned, not deleting\n", v.ver)
+			continue
+		}
+		if policy.minAge > 0 {
+			isRecent := !v.lastModified.IsZero() && now.Sub(v.lastModified) < policy.minAge
+			if isRecent {
+				fmt.Printf("%d, newer than %s, not deleting\n", v.ver, policy.minAge)
+				continue
+			}
+		}
+		fmt.Printf("%d, deleting\n", v.ver)
+		toDelete = append(toDelete, v.files...)
+		vers = append(vers, v.ver)
+	}
+	return toDelete, vers
+}
+
+// stops with a panic if ctx is cancelled or its deadline passes
+func minioDeleteOldBuildsPrefix(ctx context.Context, buildType string) {
+	panicIf(buildType == buildTypeRel, "can't delete release builds")
+
+	remoteDir := getRemoteDir(buildType)
+
+	c := newMinioStorage()
+	// we don't keep information about each file, only what retention needs.
+	// Names of all files are kept because retention is decided only after
+	// the whole listing (it's in name order, not version order) and we need
+	// names of files of old builds to delete them
+	byVer := minioGroupBuildsCtxMust(ctx, c, buildType, nil)
+	if len(byVer) == 0 {
+		fmt.Printf("nothing to delete under '%s'\n", remoteDir)
+		return
+	}
+	candidates, err := pruneCandidatesForBuilds(c, buildType, byVer)
+	must(err)
+	policy := getRetentionPolicy(buildType)
+	toDelete, vers := getFilesToDelete(byVer, policy, candidates, time.Now())
+	err = verifyDeleteFraction(len(toDelete), countFiles(byVer))
+	must(err)
+	err = minioDeleteFiles(ctx, c, toDelete)
+	must(err)
+	fmt.Printf("deleted %d files of %d builds under '%s'\n", len(toDelete), len(vers), remoteDir)
+	metrics.Count(metricName("retention_deleted_files", buildType), int64(len(toDelete)))
+	metrics.Count(metricName("retention_deleted_builds", buildType), int64(len(vers)))
+	minioVerifyLatestExistsMust(c, buildType)
+	err = minioUploadBuildFeed(c, buildType)
+	must(err)
+}
+
+// loads listing saved with -list-builds ${buildType} -json
+func loadBuildsListing(path string) ([]*filesByVer, map[string]*minio.ObjectInfo, error) {
+	d, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	var builds []*buildJSON
+	err = json.Unmarshal(d, &builds)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse '%s', err: %s", path, err)
+	}
+	var byVer []*filesByVer
+	infos := map[string]*minio.ObjectInfo{}
+	for _, b := range builds {
+		v := &filesByVer{
+			ver: b.Ver,
+		}
+		for _, f := range b.Files {
+			v.files = append(v.files, f.Key)
+			if f.LastModified.After(v.lastModified) {
+				v.lastModified = f.LastModified
+			}
+			infos[f.Key] = &minio.ObjectInfo{
+				Key:          f.Key,
+				Size:         f.Size,
+				LastModified: f.LastModified,
+			}
+		}
+		byVer = append(byVer, v)
+	}
+	sort.Slice(byVer, func(i, j int) bool {
+		return byVer[i].ver > byVer[j].ver
+	})
+	return byVer, infos, nil
+}
+
+// shows what -delete-old-builds would delete from builds in a listing saved
+// with -list-builds ${buildType} -json, without needing credentials.
+// Pinned and latest versions are not part of the listing so they're
+// not respected
+func previewRetention(buildType string, listingPath string) {
+	panicIf(!isValidBuildType(buildType) || buildType == buildTypeRel, "invalid build type: '%s'", buildType)
+	byVer, infos, err := loadBuildsListing(listingPath)
+	must(err)
+	policy := getRetentionPolicy(buildType)
+	fmt.Printf("%d builds, %d files in '%s'. Retaining %d builds", len(byVer), len(infos), listingPath, policy.nRetain)
+	if policy.minAge > 0 {
+		fmt.Printf(" and builds newer than %s", policy.minAge)
+	}
+	fmt.Printf("\n")
+	candidates := getPruneCandidates(byVer, 0, nil)
+	toDelete, vers := getFilesToDelete(byVer, policy, candidates, time.Now())
+	var size int64
+	for _, remotePath := range toDelete {
+		size += infos[remotePath].Size
+	}
+	fmt.Printf("would delete %d files (%s) of %d builds\n", len(toDelete), u.FmtSizeHuman(size), len(vers))
+	if err = verifyDeleteFraction(len(toDelete), len(infos)); err != nil {
+		fmt.Printf("-delete-old-builds would fail: %s\n", err)
+	}
+}
+
+// a bug in listing could make us think that most files are from old
+// builds. Returns an error if we would delete more than
+// flgMaxDeleteFraction of nListed files, unless -allow-large-delete
+func verifyDeleteFraction(nToDelete int, nListed int) error {
+	if flgAllowLargeDelete || nToDelete == 0 {
+		return nil
+	}
+	fraction := float64(nToDelete) / float64(nListed)
+	if fraction > flgMaxDeleteFraction {
+		return fmt.Errorf("refusing to delete %d out of %d files (%.0f%%, more than %.0f%%). Use -allow-large-delete if that's intended", nToDelete, nListed, fraction*100, flgMaxDeleteFraction*100)
+	}
+	return nil
+}
+
+// deletes files using flgDeleteParallel goroutines. If flgDeleteRateLimit > 0
+// we issue at most that many deletes per second. When ctx is done, no new
+// deletes are started and an error is returned
+func minioDeleteFiles(ctx context.Context, c minioStorage, keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	// Note: newMinioClient() creates the underlying client so it's safe
+	// to use c from multiple goroutines
+
+	var throttle <-chan time.Time
+	if flgDeleteRateLimit > 0 {
+		ticker := time.NewTicker(time.Second / time.Duration(flgDeleteRateLimit))
+		defer ticker.Stop()
+		throttle = ticker.C
+	}
+	nWorkers := flgDeleteParallel
+	if nWorkers < 1 {
+		nWorkers = 1
+	}
+
+	errs := newMultiError("delete files", len(keys))
+	// keys sent to workers are skipped if ctx is done before they're
+	// deleted so we count successful deletes, not sent keys
+	var nDeleted int64
+	var wg sync.WaitGroup
+	ch := make(chan string)
+	for i := 0; i < nWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for key := range ch {
+				if ctx.Err() != nil {
+					continue
+				}
+				err := c.Delete(key)
+				if err == nil {
+					atomic.AddInt64(&nDeleted, 1)
+				}
+				errs.AddForBackend(storageSpaces, key, err)
+			}
+		}()
+	}
+loop:
+	for _, key := range keys {
+		if throttle != nil {
+			select {
+			case <-throttle:
+			case <-ctx.Done():
+				break loop
+			}
+		}
+		select {
+		case ch <- key:
+			fmt.Printf("  %s deleting\n", key)
+		case <-ctx.Done():
+			break loop
+		}
+	}
+	close(ch)
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("stopped after deleting %d out of %d files: %s", atomic.LoadInt64(&nDeleted), len(keys), err)
+	}
+	return errs.ErrorOrNil()
+}
+
+// remote paths of 64-bit artifacts for a given version. Those are
+// present in all build types, 32-bit builds are not
+func getLatestArtifactRemotePaths(buildType string, ver string) []string {
+	dirRemote := getRemoteDir(buildType)
+	prefix := getAppNameForBuildType(buildType) + "-" + ver
+	return []string{
+		remoteJoin(dirRemote, prefix+"-64.exe"),
+		remoteJoin(dirRemote, prefix+"-64.zip"),
+		remoteJoin(dirRemote, prefix+"-64-install.exe"),
+	}
+}
+
+// after deleting old builds, make sure that the version in *-latest.txt
+// still exists. If not, the updater would get 404s
+func minioVerifyLatestExistsMust(c minioStorage, buildType string) {
+	latestPath := getRemotePaths(buildType)[1]
+	n, err := minioReadLatestVersion(c, buildType)
+	fatalIf(err != nil, "minioVerifyLatestExistsMust: failed to read '%s', err: %s\n", latestPath, err)
+	fatalIf(n == 0, "minioVerifyLatestExistsMust: '%s' doesn't exist\n", latestPath)
+	ver := strconv.Itoa(n)
+	for _, remotePath := range getLatestArtifactRemotePaths(buildType, ver) {
+		fatalIf(!minioExists(c, remotePath), "'%s' points to version '%s' but '%s' doesn't exist\n", latestPath, ver, remotePath)
+	}
+	logf("Latest version '%s' of '%s' still exists\n", ver, buildType)
+}
+
+func minioDeleteOldBuilds(ctx context.Context) {
+	minioDeleteOldBuildsPrefix(ctx, buildTypePreRel)
+	minioDeleteOldBuildsPrefix(ctx, buildTypeDaily)
+	minioDeleteOldBuildsPrefix(ctx, buildTypeRaMicro)
+}
+
+// name of the file with version removed e.g.
+// "software/sumatrapdf/prerel/SumatraPDF-prerel-12230-64.exe"
+// =>
+// "SumatraPDF-prerel-64.exe"
+func artifactKind(remotePath string, ver int) string {
+	name := path.Base(remotePath)
+	return strings.Replace(name, "-"+strconv.Itoa(ver), "", 1)
+}
+
+func fmtSizeDelta(n int64) string {
+	if n < 0 {
+		return "-" + u.FmtSizeHuman(-n)
+	}
+	return "+" + u.FmtSizeHuman(n)
+}
+
+// prints how sizes of artifacts changed between 2 versions of a build.
+// Artifacts that don't exist in both versions are skipped
+func minioPrintBuildSizeDiff(buildType string, ver1 int, ver2 int) {
+	c := newMinioStorage()
+	byVer, infos := minioListBuildsMust(c, buildType)
+	getSizes := func(ver int) map[string]int64 {
+		for _, v := range byVer {
+			if v.ver != ver {
+				continue
+			}
+			res := map[string]int64{}
+			for _, remotePath := range v.files {
+				res[artifactKind(remotePath, ver)] = infos[remotePath].Size
+			}
+			return res
+		}
+		panicIf(true, "no files for version %d of '%s'", ver, buildType)
+		return nil
+	}
+	sizes1 := getSizes(ver1)
+	sizes2 := getSizes(ver2)
+	var kinds []string
+	for kind := range sizes1 {
+		if _, ok := sizes2[kind]; ok {
+			kinds = append(kinds, kind)
+		}
+	}
+	sort.Strings(kinds)
+
+	fmt.Printf("%-40s %10d %10d %10s\n", "", ver1, ver2, "delta")
+	for _, kind := range kinds {
+		size1, size2 := sizes1[kind], sizes2[kind]
+		fmt.Printf("%-40s %10s %10s %10s\n", kind, u.FmtSizeHuman(size1), u.FmtSizeHuman(size2), fmtSizeDelta(size2-size1))
+	}
+}
+
+// arg is "${ver1},${ver2}" e.g. "12200,12230"
+func buildSizeDiff(arg string) {
+	parts := strings.Split(arg, ",")
+	panicIf(len(parts) != 2, "expected 2 versions separated by ',', got '%s'", arg)
+	ver1, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	panicIfErr(err)
+	ver2, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+	panicIfErr(err)
+	minioPrintBuildSizeDiff(buildTypePreRel, ver1, ver2)
+}
+
+// downloads all files of a given version of a build to destDir
+func minioDownloadBuild(c minioStorage, buildType string, ver int, destDir string) {
+	byVer, infos := minioListBuildsMust(c, buildType)
+	var files []string
+	for _, v := range byVer {
+		if v.ver == ver {
+			files = v.files
+		}
+	}
+	fatalIf(len(byVer) == 0, "version %d of '%s' not found because there are no builds of '%s'\n", ver, buildType, buildType)
+	if len(files) == 0 {
+		oldest := byVer[len(byVer)-1].ver
+		fatalIf(true, "version %d of '%s' not found. It might have been deleted, oldest available version is %d\n", ver, buildType, oldest)
+	}
+	for _, remotePath := range files {
+		pathLocal := filepath.Join(destDir, path.Base(remotePath))
+		err := c.DownloadFileAtomically(pathLocal, remotePath)
+		panicIfErr(err)
+		size := fileSizeMust(pathLocal)
+		expSize := infos[remotePath].Size
+		fatalIf(size != expSize, "downloaded '%s' as '%s' but size is %d and expected %d\n", remotePath, pathLocal, size, expSize)
+		logf("Downloaded '%s' as '%s'\n", remotePath, pathLocal)
+	}
+}
+
+func downloadBuild(buildType string, ver int) {
+	panicIf(!isValidBuildType(buildType), "invalid build type: '%s'", buildType)
+	destDir := filepath.Join("out", "downloads", fmt.Sprintf("%s-%d", buildType, ver))
+	c := newMinioStorage()
+	minioDownloadBuild(c, buildType, ver, destDir)
+	logf("Downloaded version %d of '%s' to '%s'\n", ver, buildType, destDir)
+}
+
+// returned when there are no builds with a valid version
+var errNoBuilds = errors.New("no builds found")
+
+// returns the biggest version in keys, ignoring those we can't parse.
+// Returns errNoBuilds if there are none
+func latestVersionFromKeys(keys []string) (int, error) {
+	res := 0
+	for _, key := range keys {
+		ver, ok := parseVersionFromName(key)
+		if ok && ver > res {
+			res = ver
+		}
+	}
+	if res == 0 {
+		return 0, errNoBuilds
+	}
+	return res, nil
+}
+
+// returns the newest version of a build type in storage or an error
+// wrapping errNoBuilds if there are no builds
+func minioLatestVersion(c minioStorage, buildType string) (int, error) {
+	remoteDir := getRemoteDir(buildType)
+	files, err := c.ListRemoteFiles(remoteDir)
+	if err != nil {
+		return 0, err
+	}
+	var keys []string
+	for _, f := range files {
+		keys = append(keys, f.Key)
+	}
+	ver, err := latestVersionFromKeys(keys)
+	if err != nil {
+		return 0, fmt.Errorf("'%s': %w", remoteDir, err)
+	}
+	return ver, nil
+}
+
+// returns an error if ver is not bigger than the latest version of
+// buildType in storage. Uploading it would over-write or mis-order builds
+func verifyVersionIsNewer(c minioStorage, buildType string, ver string) error {
+	n, err := strconv.Atoi(ver)
+	if err != nil {
+		return fmt.Errorf("version '%s' of '%s' is not a number", ver, buildType)
+	}
+	latest, err := minioLatestVersion(c, buildType)
+	if errors.Is(err, errNoBuilds) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if n <= latest {
+		return fmt.Errorf("version %d of '%s' is not bigger than the latest version %d in storage. Use -force to upload anyway", n, buildType, latest)
+	}
+	return nil
+}
+
+// re-creates and uploads version info files (sumatralatest.js etc.) for
+// the latest version in storage. For recovering when they got deleted
+// or corrupted. Doesn't need the build files locally
+func minioRegenerateLatestInfo(c minioStorage, buildType string) {
+	ver, err := minioLatestVersion(c, buildType)
+	panicIfErr(err)
+	byVer, _ := minioListBuildsMust(c, buildType)
+	bad := minioReadBadVersionsMust(c, buildType)
+	if bad[ver] {
+		// newest version that wasn't rolled back
+		ver = 0
+		for _, v := range byVer {
+			if !bad[v.ver] {
+				ver = v.ver
+				break
+			}
+		}
+		fatalIf(ver == 0, "all versions of '%s' are listed in '%s'\n", buildType, getBadVersionsPath(buildType))
+	}
+	minioUploadVersionInfoForVer(c, buildType, ver, byVer)
+}
+
+// uploads version info files pointing to version ver of buildType.
+// byVer are builds of buildType in storage
+func minioUploadVersionInfoForVer(c minioStorage, buildType string, ver int, byVer []*filesByVer) {
+	var archs buildArchs
+	for _, v := range byVer {
+		if v.ver == ver {
+			archs = detectBuildArchs(v.files)
+		}
+	}
+	fatalIf(!archs.has32 && !archs.has64, "no .exe files in version %d of '%s'\n", ver, buildType)
+	sha1 := getGitSha1ForLinearVersion(ver)
+	info := minioGetManifestInfo(c, buildType, strconv.Itoa(ver))
+	logf("Regenerating version info for version %d of '%s', sha1: '%s', built on: %s\n", ver, buildType, sha1, info.date)
+	files := getVersionFilesForLatestInfoForVer(buildType, strconv.Itoa(ver), sha1, info, archs)
+	for _, f := range files {
+		err := minioUploadVersionFile(c, f[0], []byte(f[1]))
+		panicIfErr(err)
+	}
+}
+
+// re-points version info files of buildType from the version they point
+// to, to the previous version in storage. The bad build is not deleted.
+// If markBad is true, the bad version is added to getBadVersionsPath()
+// so that -regen-latest-info doesn't point to it again
+func minioRollbackLatest(c minioStorage, buildType string, markBad bool) {
+	// version info files for release builds are not created by us
+	panicIf(!isValidBuildType(buildType) || buildType == buildTypeRel, "invalid build type: '%s'", buildType)
+	badVer, err := minioReadLatestVersion(c, buildType)
+	panicIfErr(err)
+	fatalIf(badVer == 0, "'%s' doesn't exist, nothing to roll back\n", getRemotePaths(buildType)[1])
+
+	byVer, _ := minioListBuildsMust(c, buildType)
+	bad := minioReadBadVersionsMust(c, buildType)
+	ver := 0
+	for _, v := range byVer {
+		if v.ver < badVer && !bad[v.ver] && detectBuildArchs(v.files) != (buildArchs{}) {
+			ver = v.ver
+			break
+		}
+	}
+	fatalIf(ver == 0, "no version of '%s' older than %d to roll back to\n", buildType, badVer)
+
+	if markBad && !bad[badVer] {
+		badPath := getBadVersionsPath(buildType)
+		s := ""
+		if minioExists(c, badPath) {
+			d, err := c.DownloadFileAsData(badPath)
+			panicIfErr(err)
+			s = string(d)
+		}
+		if s != "" && !strings.HasSuffix(s, "\n") {
+			s += "\n"
+		}
+		s += strconv.Itoa(badVer) + "\n"
+		err = minioUploadData(c, badPath, []byte(s), true)
+		panicIfErr(err)
+		logf("Added %d to '%s'\n", badVer, badPath)
+	}
+	minioUploadVersionInfoForVer(c, buildType, ver, byVer)
+	logf("Rolled back '%s' from version %d to %d\n", buildType, badVer, ver)
+}
+
+// legacy prefixes of file names of builds and their canonical replacement.
+// Old builds are renamed with -migrate-names
+var legacyNamePrefixes = [][]string{
+	{"SumatraPDF-prerelease-", "SumatraPDF-prerel-"},
+	{"SumatraPDF-prerelase-", "SumatraPDF-prerel-"},
+	{"RAMicro-prerelease-", "RAMicroPDFViewer-prerel-"},
+	{"RAMicro-prerel-", "RAMicroPDFViewer-prerel-"},
+}
+
+// returns canonical name for a file name using legacy naming
+func getCanonicalName(name string) (string, bool) {
+	for _, a := range legacyNamePrefixes {
+		if strings.HasPrefix(name, a[0]) {
+			return a[1] + strings.TrimPrefix(name, a[0]), true
+		}
+	}
+	return name, false
+}
+
+// renames files of builds of buildType that use legacy naming with a
+// server-side copy followed by delete. If dryRun is true only logs what
+// would be renamed. Files of the version in *-latest.txt are referenced by
+// version info files so we only rename them if renameLatest is true
+func minioMigrateLegacyNames(c minioStorage, buildType string, dryRun bool, renameLatest bool) error {
+	remoteDir := getRemoteDir(buildType)
+	files, err := c.ListRemoteFiles(remoteDir)
+	if err != nil {
+		return err
+	}
+	latestPath := getRemotePaths(buildType)[1]
+	latestVer, err := minioReadLatestVersion(c, buildType)
+	if err != nil {
+		return err
+	}
+	existing := map[string]bool{}
+	for _, f := range files {
+		existing[f.Key] = true
+	}
+
+	nRenamed := 0
+	for _, f := range files {
+		dir, name := path.Split(f.Key)
+		newName, ok := getCanonicalName(name)
+		if !ok {
+			continue
+		}
+		newKey := remoteJoin(dir, newName)
+		if existing[newKey] {
+			logf("Not renaming '%s' because '%s' already exists\n", f.Key, newKey)
+			continue
+		}
+		if ver := extractVersionFromName(f.Key); ver == latestVer && !renameLatest {
+			logf("Not renaming '%s' because %d is the version in '%s'. Use -migrate-latest to rename it\n", f.Key, ver, latestPath)
+			continue
+		}
+		if dryRun {
+			logf("Would rename '%s' => '%s'\n", f.Key, newKey)
+			nRenamed++
+			continue
+		}
+		opts := getUploadPolicy(newKey).putObjectOptions()
+		err = c.Copy(newKey, f.Key, opts)
+		if err != nil {
+			return fmt.Errorf("failed to copy '%s' to '%s', err: %s", f.Key, newKey, err)
+		}
+		oi, err := c.StatObject(newKey)
+		if err != nil || oi.Size != f.Size {
+			return fmt.Errorf("copy of '%s' to '%s' failed verification, err: %v", f.Key, newKey, err)
+		}
+		err = c.Delete(f.Key)
+		if err != nil {
+			return fmt.Errorf("failed to delete '%s', err: %s", f.Key, err)
+		}
+		logf("Renamed '%s' => '%s'\n", f.Key, newKey)
+		existing[newKey] = true
+		nRenamed++
+	}
+	if dryRun {
+		logf("Would rename %d files under '%s'\n", nRenamed, remoteDir)
+	} else {
+		logf("Renamed %d files under '%s'\n", nRenamed, remoteDir)
+	}
+	return nil
+}
+
+// returns an error if file at path doesn't have size and hashes recorded
+// in manifest. All recorded algorithms are checked
+func verifyFileMatchesManifest(path string, exp manifestFileHash) error {
+	if size := fileSizeMust(path); size != exp.size {
+		return fmt.Errorf("size is %d, expected %d", size, exp.size)
+	}
+	var algos []crypto.Hash
+	for algo := range exp.sums {
+		algos = append(algos, algo)
+	}
+	sort.Slice(algos, func(i, j int) bool {
+		return algos[i] < algos[j]
+	})
+	sums, err := computeChecksums(path, algos)
+	if err != nil {
+		return err
+	}
+	for _, algo := range algos {
+		if sums[algo] != exp.sums[algo] {
+			return fmt.Errorf("%s is %s, expected %s", checksumAlgoName(algo), sums[algo], exp.sums[algo])
+		}
+	}
+	return nil
+}
+
+// checks that files of version ver of buildType in storage have the size
+// and hashes recorded in its manifest i.e. that they weren't replaced or
+// corrupted after upload. Prints a report and returns an error if any
+// file doesn't match
+func verifyBuildIntegrity(c minioStorage, buildType string, ver int) error {
+	byVer, _ := minioListBuildsMust(c, buildType)
+	var files []string
+	for _, v := range byVer {
+		if v.ver == ver {
+			files = v.files
+		}
+	}
+	manifestPath := ""
+	for _, remotePath := range files {
+		if isManifestFile(remotePath) {
+			manifestPath = remotePath
+		}
+	}
+	if manifestPath == "" {
+		return fmt.Errorf("no manifest for version %d of '%s'", ver, buildType)
+	}
+	d, err := c.DownloadFileAsData(manifestPath)
+	if err != nil {
+		return err
+	}
+	hashes, err := parseManifestHashes(d)
+	if err != nil {
+		return fmt.Errorf("'%s': %s", manifestPath, err)
+	}
+	if len(hashes) == 0 {
+		return fmt.Errorf("'%s' has no hashes, the build was uploaded before they were recorded", manifestPath)
+	}
+
+	dir := path.Dir(manifestPath)
+	var names []string
+	for name := range hashes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	// files that don't match are kept for inspection
+	workDirName := fmt.Sprintf("verify-%s-%d", buildType, ver)
+	localDir := workDir(workDirName)
+	nFailed := 0
+	for _, name := range names {
+		remotePath := remoteJoin(dir, name)
+		pathLocal := filepath.Join(localDir, name)
+		exp := hashes[name]
+		err := c.DownloadFileAtomically(pathLocal, remotePath)
+		if err == nil {
+			err = verifyFileMatchesManifest(pathLocal, exp)
+		}
+		if err == nil {
+			os.Remove(pathLocal)
+		}
+		if err != nil {
+			nFailed++
+			fmt.Printf("FAIL: %s: %s\n", remotePath, err)
+			continue
+		}
+		fmt.Printf("ok:   %s\n", remotePath)
+	}
+	if nFailed > 0 {
+		return fmt.Errorf("%d out of %d files of version %d of '%s' don't match '%s'. Downloaded files are in '%s'", nFailed, len(names), ver, buildType, manifestPath, localDir)
+	}
+	removeWorkDir(workDirName)
+	return nil
+}
+
+// checksum and manifest files only describe other files of a build. They
+// have the version in the name so groupFilesByVersion() puts them in the
+// same group as the files they describe and deleting old builds deletes
+// them together
+func isBuildMetadataFile(remotePath string) bool {
+	name := path.Base(remotePath)
+	_, isChecksum := checksumFileAlgo(name)
+	return isChecksum || isManifestFile(name) || strings.HasPrefix(name, "manifest")
+}
+
+// returns checksum and manifest files that no longer describe anything
+// i.e. ${name}.sha256 (etc.) without ${name} and metadata files of a version
+// that has no other files (e.g. left by a partial delete)
+func findOrphanedFiles(byVer []*filesByVer) []string {
+	var res []string
+	for _, v := range byVer {
+		existing := map[string]bool{}
+		hasArtifacts := false
+		for _, remotePath := range v.files {
+			existing[remotePath] = true
+			if !isBuildMetadataFile(remotePath) {
+				hasArtifacts = true
+			}
+		}
+		for _, remotePath := range v.files {
+			if !isBuildMetadataFile(remotePath) {
+				continue
+			}
+			isOrphan := !hasArtifacts
+			if algo, ok := checksumFileAlgo(remotePath); ok && !existing[strings.TrimSuffix(remotePath, "."+algo)] {
+				isOrphan = true
+			}
+			if isOrphan {
+				res = append(res, remotePath)
+			}
+		}
+	}
+	return res
+}
+
+// deletes orphaned checksum and manifest files (see findOrphanedFiles())
+// of builds of buildType. With dryRun only lists them
+func minioDeleteOrphanedFiles(c minioStorage, buildType string, dryRun bool) error {
+	if !dryRun {
+		// don't mistake files of a build being uploaded for orphans
+		releaseLock := minioAcquireUploadLockMust(c, buildType)
+		defer releaseLock()
+	}
+	byVer, _ := minioListBuildsMust(c, buildType)
+	orphans := findOrphanedFiles(byVer)
+	for _, remotePath := range orphans {
+		fmt.Printf("orphan: %s\n", remotePath)
+	}
+	if dryRun {
+		logf("Would delete %d orphaned files of '%s'\n", len(orphans), buildType)
+		return nil
+	}
+	err := minioDeleteFiles(context.Background(), c, orphans)
+	if err != nil {
+		return err
+	}
+	logf("Deleted %d orphaned files of '%s'\n", len(orphans), buildType)
+	return nil
+}
+
+// returns remote paths of version info and other files of buildType that
+// are outside of getRemoteDir()
+func getChannelFilesOutsideDir(buildType string) []string {
+	res := append([]string(nil), getRemotePaths(buildType)...)
+	res = append(res, getPinnedPath(buildType), getBadVersionsPath(buildType))
+	if hasBuildFeed(buildType) {
+		res = append(res, getBuildFeedRemotePath(buildType))
+	}
+	return res
+}
+
+// deletes all files of a channel we no longer publish, including version
+// info files. Release builds can't be purged. Unless confirmed (or with
+// dryRun) only prints what would be deleted.
+// Like deleting old builds, deleting more than -max-delete-fraction of
+// files (which purging always does) needs -allow-large-delete
+func minioPurgeChannel(c minioStorage, buildType string, confirmed bool, dryRun bool) error {
+	panicIf(!isValidBuildType(buildType) || buildType == buildTypeRel, "can't purge build type '%s'", buildType)
+	remoteDir := getRemoteDir(buildType)
+	// guard against deleting everything if remoteDir was mis-configured
+	panicIf(remoteDir == remoteJoin(remoteRoot)+"/" || !strings.HasPrefix(remoteDir, remoteRoot), "invalid remote dir '%s' of '%s'", remoteDir, buildType)
+
+	if !dryRun && confirmed {
+		releaseLock := minioAcquireUploadLockMust(c, buildType)
+		defer releaseLock()
+	}
+	files, err := c.ListRemoteFiles(remoteDir)
+	if err != nil {
+		return err
+	}
+	for _, remotePath := range getChannelFilesOutsideDir(buildType) {
+		oi, err := c.StatObject(remotePath)
+		if err == nil {
+			oic := oi
+			files = append(files, &oic)
+		}
+	}
+	var keys []string
+	var totalSize int64
+	for _, oi := range files {
+		fmt.Printf("%s %d %s\n", oi.Key, oi.Size, oi.LastModified.Format(time.RFC3339))
+		keys = append(keys, oi.Key)
+		totalSize += oi.Size
+	}
+	outside := getChannelFilesOutsideDir(buildType)
+	for _, key := range keys {
+		ok := strings.HasPrefix(key, remoteDir)
+		for _, remotePath := range outside {
+			ok = ok || key == remotePath
+		}
+		panicIf(!ok, "'%s' is not a file of '%s'", key, buildType)
+	}
+	if dryRun || !confirmed {
+		logf("Would delete %d files (%s) of '%s'. To delete them use -purge-confirm %s\n", len(keys), u.FmtSizeHuman(totalSize), buildType, buildType)
+		return nil
+	}
+	err = verifyDeleteFraction(len(keys), len(keys))
+	if err != nil {
+		return err
+	}
+	err = minioDeleteFiles(context.Background(), c, keys)
+	if err != nil {
+		return err
+	}
+	logf("Deleted %d files (%s) of '%s'\n", len(keys), u.FmtSizeHuman(totalSize), buildType)
+	return nil
 }
@@ -0,0 +1,996 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/minio/minio-go/v6"
+)
+
+// operations of fakeStorage recorded in fakeStorage.calls
+const (
+	fakeOpUpload = "upload"
+	fakeOpCopy   = "copy"
+	fakeOpDelete = "delete"
+)
+
+type fakeStorageCall struct {
+	op         string
+	remotePath string
+	// options of uploads and copies
+	opts minio.PutObjectOptions
+}
+
+// fakeStorage is an in-memory minioStorage that records every call
+// that changes it so that tests can check the order of uploads. Like
+// the real storage, it can be used from multiple goroutines
+type fakeStorage struct {
+	mu    sync.Mutex
+	files map[string][]byte
+	infos map[string]*minio.ObjectInfo
+	calls []fakeStorageCall
+	// modification time of the next written file. Advances with
+	// every write so that files have distinct times
+	now time.Time
+}
+
+func newFakeStorage() *fakeStorage {
+	return &fakeStorage{
+		files: map[string][]byte{},
+		infos: map[string]*minio.ObjectInfo{},
+		now:   time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+}
+
+func (s *fakeStorage) put(remotePath string, d []byte) {
+	s.now = s.now.Add(time.Second)
+	s.files[remotePath] = d
+	s.infos[remotePath] = &minio.ObjectInfo{
+		Key:          remotePath,
+		Size:         int64(len(d)),
+		ETag:         fmt.Sprintf(`"%x"`, md5.Sum(d)),
+		LastModified: s.now,
+	}
+}
+
+func (s *fakeStorage) record(op string, remotePath string, opts minio.PutObjectOptions) {
+	s.calls = append(s.calls, fakeStorageCall{op, remotePath, opts})
+}
+
+func (s *fakeStorage) sortedKeys(prefix string) []string {
+	var keys []string
+	for key := range s.files {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (s *fakeStorage) ListRemoteFiles(prefix string) ([]*minio.ObjectInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var res []*minio.ObjectInfo
+	for _, key := range s.sortedKeys(prefix) {
+		oi := *s.infos[key]
+		res = append(res, &oi)
+	}
+	return res, nil
+}
+
+func (s *fakeStorage) ListRemoteFilesFunc(ctx context.Context, prefix string, fn func(oi *minio.ObjectInfo) error) error {
+	// fn is called without the lock so that it can use the storage
+	files, _ := s.ListRemoteFiles(prefix)
+	for _, oi := range files {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(oi); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func errFakeNotFound(remotePath string) error {
+	return minio.ErrorResponse{Code: "NoSuchKey", Key: remotePath, Message: "The specified key does not exist."}
+}
+
+func (s *fakeStorage) StatObject(remotePath string) (minio.ObjectInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	oi := s.infos[remotePath]
+	if oi == nil {
+		return minio.ObjectInfo{}, errFakeNotFound(remotePath)
+	}
+	return *oi, nil
+}
+
+func (s *fakeStorage) DownloadFileAsData(remotePath string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, ok := s.files[remotePath]
+	if !ok {
+		return nil, errFakeNotFound(remotePath)
+	}
+	return d, nil
+}
+
+func (s *fakeStorage) DownloadFileAtomically(dstPath string, remotePath string) error {
+	d, err := s.DownloadFileAsData(remotePath)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(dstPath, d, 0644)
+}
+
+func (s *fakeStorage) UploadData(remotePath string, d []byte, opts minio.PutObjectOptions) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.record(fakeOpUpload, remotePath, opts)
+	s.put(remotePath, append([]byte(nil), d...))
+	return nil
+}
+
+func (s *fakeStorage) UploadFileWithOptions(remotePath string, filePath string, opts minio.PutObjectOptions) error {
+	d, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.record(fakeOpUpload, remotePath, opts)
+	s.put(remotePath, d)
+	return nil
+}
+
+func (s *fakeStorage) Copy(dstPath string, srcPath string, opts minio.PutObjectOptions) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, ok := s.files[srcPath]
+	if !ok {
+		return errFakeNotFound(srcPath)
+	}
+	s.record(fakeOpCopy, dstPath, opts)
+	s.put(dstPath, d)
+	return nil
+}
+
+func (s *fakeStorage) PresignedURL(remotePath string, expiry time.Duration) (string, error) {
+	return "https://example.com/" + remotePath + "?expiry=" + expiry.String(), nil
+}
+
+func (s *fakeStorage) Delete(remotePath string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.files[remotePath]; !ok {
+		return errFakeNotFound(remotePath)
+	}
+	s.record(fakeOpDelete, remotePath, minio.PutObjectOptions{})
+	delete(s.files, remotePath)
+	delete(s.infos, remotePath)
+	return nil
+}
+
+// returns remote paths of uploads and copies (i.e. PutObject), in order
+func (s *fakeStorage) writes() []string {
+	var res []string
+	for _, c := range s.calls {
+		if c.op == fakeOpUpload || c.op == fakeOpCopy {
+			res = append(res, c.remotePath)
+		}
+	}
+	return res
+}
+
+// sets globals normally set by flags and version detection to their
+// defaults and restores them when the test ends
+func setTestGlobals(t *testing.T) {
+	strs := map[*string]string{
+		&flgChecksums:        "sha256",
+		&flgVersionFiles:     "js,latest,update",
+		&flgLatestJsURLs:     urlModeAbsolute,
+		&flgUploadIgnore:     defaultUploadIgnore,
+		&flgMinOSVersion:     "",
+		&preReleaseVerCached: "12345",
+		&gitSha1Cached:       "0123456789abcdef0123456789abcdef01234567",
+		&sumatraVersion:      "3.2",
+	}
+	for p, v := range strs {
+		p, prev := p, *p
+		*p = v
+		t.Cleanup(func() { *p = prev })
+	}
+	bools := []*bool{&flgForceUpload, &flgUploadSymbols, &flgZipSizes, &flgZstd, &flgVersionFromManifest}
+	for _, p := range bools {
+		p, prev := p, *p
+		*p = false
+		t.Cleanup(func() { *p = prev })
+	}
+	prevLockMaxAge := flgUploadLockMaxAge
+	flgUploadLockMaxAge = time.Hour
+	t.Cleanup(func() { flgUploadLockMaxAge = prevLockMaxAge })
+
+	notesPath := filepath.Join(t.TempDir(), "notes.txt")
+	must(ioutil.WriteFile(notesPath, []byte("notes for the build\n"), 0644))
+	prevNotesFile := flgNotesFile
+	flgNotesFile = notesPath
+	t.Cleanup(func() { flgNotesFile = prevNotesFile })
+}
+
+// creates a directory with files of a 32-bit and 64-bit build of
+// buildType, like the build step does
+func writeTestBuild(t *testing.T, buildType string) string {
+	dir := t.TempDir()
+	ver := getVerForBuildType(buildType)
+	prefix := getAppNameForBuildType(buildType) + "-" + ver
+	names := []string{prefix + ".exe", prefix + ".zip", prefix + "-64.exe", prefix + "-64.zip"}
+	for _, name := range names {
+		must(ioutil.WriteFile(filepath.Join(dir, name), []byte("content of "+name), 0644))
+	}
+	manifest := fmt.Sprintf("ver: %s\nsha1: %s\n", ver, getGitSha1())
+	must(ioutil.WriteFile(filepath.Join(dir, manifestName(buildType, ver)), []byte(manifest), 0644))
+	return dir
+}
+
+func indexOf(a []string, s string) int {
+	for i, el := range a {
+		if el == s {
+			return i
+		}
+	}
+	return -1
+}
+
+// the orchestration contract of minioUploadBuild: build files are
+// uploaded, the manifest after all of them, and then pointer (version
+// info) files of the build type are written
+func TestMinioUploadBuildOrchestration(t *testing.T) {
+	for _, buildType := range []string{buildTypePreRel, buildTypeDaily} {
+		t.Run(buildType, func(t *testing.T) {
+			setTestGlobals(t)
+			dir := writeTestBuild(t, buildType)
+			c := newFakeStorage()
+			minioUploadBuild(c, buildType, dir, nil)
+
+			writes := c.writes()
+			dirRemote := getRemoteDir(buildType)
+			manifestPath := remoteJoin(dirRemote, manifestName(buildType, getVerForBuildType(buildType)))
+			manifestIdx := indexOf(writes, manifestPath)
+			if manifestIdx == -1 {
+				t.Fatalf("manifest '%s' wasn't uploaded", manifestPath)
+			}
+			files, err := ioutil.ReadDir(dir)
+			must(err)
+			for _, f := range files {
+				remotePath := remoteJoin(dirRemote, f.Name())
+				idx := indexOf(writes, remotePath)
+				if idx == -1 {
+					t.Errorf("'%s' wasn't uploaded", remotePath)
+				} else if idx > manifestIdx {
+					t.Errorf("'%s' was uploaded after the manifest", remotePath)
+				}
+			}
+
+			for _, remotePath := range getRemotePaths(buildType) {
+				idx := indexOf(writes, remotePath)
+				if idx == -1 {
+					t.Errorf("pointer file '%s' wasn't written", remotePath)
+					continue
+				}
+				if idx < manifestIdx {
+					t.Errorf("pointer file '%s' was written before the manifest", remotePath)
+				}
+				if _, ok := c.files[remotePath]; !ok {
+					t.Errorf("pointer file '%s' is not in storage", remotePath)
+				}
+			}
+			latest := string(c.files[getRemotePaths(buildType)[1]])
+			if latest != getVerForBuildType(buildType) {
+				t.Errorf("'%s' is '%s', expected '%s'", getRemotePaths(buildType)[1], latest, getVerForBuildType(buildType))
+			}
+			if _, ok := c.files[getUploadLockPath(buildType)]; ok {
+				t.Errorf("upload lock wasn't released")
+			}
+		})
+	}
+}
+
+// manifest marks a build as complete so it must be the last file
+// written to the build's directory
+func TestMinioUploadBuildManifestIsLast(t *testing.T) {
+	for _, buildType := range []string{buildTypePreRel, buildTypeDaily, buildTypeRaMicro} {
+		t.Run(buildType, func(t *testing.T) {
+			setTestGlobals(t)
+			dir := writeTestBuild(t, buildType)
+			c := newFakeStorage()
+			minioUploadBuild(c, buildType, dir, nil)
+
+			dirRemote := getRemoteDir(buildType)
+			var last string
+			for _, remotePath := range c.writes() {
+				if strings.HasPrefix(remotePath, dirRemote) {
+					last = remotePath
+				}
+			}
+			exp := remoteJoin(getRemoteDir(buildType), manifestName(buildType, getVerForBuildType(buildType)))
+			if last != exp {
+				t.Errorf("last file written to '%s' is '%s', expected '%s'", dirRemote, last, exp)
+			}
+		})
+	}
+}
+
+func TestRemoteJoin(t *testing.T) {
+	tests := []struct {
+		elem []string
+		exp  string
+	}{
+		{[]string{"software/sumatrapdf/", "prerel"}, "software/sumatrapdf/prerel"},
+		{[]string{"software/sumatrapdf", "prerel"}, "software/sumatrapdf/prerel"},
+		{[]string{"/software/sumatrapdf/", "/prerel/", "a.exe"}, "software/sumatrapdf/prerel/a.exe"},
+		{[]string{"software//sumatrapdf/", "", "a.exe"}, "software/sumatrapdf/a.exe"},
+		{[]string{"software/sumatrapdf/prerel/", "12345", "notes.txt"}, "software/sumatrapdf/prerel/12345/notes.txt"},
+		{[]string{"a.exe"}, "a.exe"},
+	}
+	for _, test := range tests {
+		got := remoteJoin(test.elem...)
+		if got != test.exp {
+			t.Errorf("remoteJoin(%q) = '%s', expected '%s'", test.elem, got, test.exp)
+		}
+	}
+}
+
+func groupsToMap(byVer []*filesByVer) map[int][]string {
+	res := map[int][]string{}
+	for _, v := range byVer {
+		a := append([]string(nil), v.files...)
+		sort.Strings(a)
+		res[v.ver] = a
+	}
+	return res
+}
+
+// checksum and manifest files must be in the same group as the build
+// they describe so that deleting old builds deletes them together
+func TestGroupFilesByVersionMetadata(t *testing.T) {
+	files := []string{
+		"prerel/SumatraPDF-prerel-12345-64.exe",
+		"prerel/SumatraPDF-prerel-12345-64.exe.sha256",
+		"prerel/SumatraPDF-prerel-12345-64.exe.sha1",
+		"prerel/SumatraPDF-prerel-12345-manifest.txt",
+		"prerel/manifest-12345.txt",
+		"prerel/12345/notes.txt",
+		"prerel/SumatraPDF-prerel-12346.exe",
+		"prerel/SumatraPDF-prerel-12346.exe.sha256",
+		"prerel/SumatraPDF-prerelease-12346-manifest.txt",
+	}
+	exp := map[int][]string{
+		12345: {
+			"prerel/12345/notes.txt",
+			"prerel/SumatraPDF-prerel-12345-64.exe",
+			"prerel/SumatraPDF-prerel-12345-64.exe.sha1",
+			"prerel/SumatraPDF-prerel-12345-64.exe.sha256",
+			"prerel/SumatraPDF-prerel-12345-manifest.txt",
+			"prerel/manifest-12345.txt",
+		},
+		12346: {
+			"prerel/SumatraPDF-prerel-12346.exe",
+			"prerel/SumatraPDF-prerel-12346.exe.sha256",
+			"prerel/SumatraPDF-prerelease-12346-manifest.txt",
+		},
+	}
+	byVer := groupFilesByVersion(files)
+	got := groupsToMap(byVer)
+	if !reflect.DeepEqual(got, exp) {
+		t.Errorf("groupFilesByVersion() = %v, expected %v", got, exp)
+	}
+	if byVer[0].ver != 12346 {
+		t.Errorf("first group is %d, expected the most recent 12346", byVer[0].ver)
+	}
+}
+
+func TestFindOrphanedFiles(t *testing.T) {
+	tests := []struct {
+		name  string
+		files []string
+		exp   []string
+	}{
+		{
+			"complete build",
+			[]string{"p/S-prerel-1.exe", "p/S-prerel-1.exe.sha256", "p/S-prerel-1-manifest.txt"},
+			nil,
+		},
+		{
+			"checksum without its file",
+			[]string{"p/S-prerel-1.exe", "p/S-prerel-1.zip.sha256", "p/S-prerel-1-manifest.txt"},
+			[]string{"p/S-prerel-1.zip.sha256"},
+		},
+		{
+			"only metadata left",
+			[]string{"p/S-prerel-2.exe.sha256", "p/manifest-2.txt"},
+			[]string{"p/S-prerel-2.exe.sha256", "p/manifest-2.txt"},
+		},
+		{
+			"notes alone are not metadata",
+			[]string{"p/3/notes.txt"},
+			nil,
+		},
+	}
+	for _, test := range tests {
+		// all files are in one version group
+		got := findOrphanedFiles(groupFilesByVersionFunc(test.files, func(string) int { return 1 }))
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, test.exp) {
+			t.Errorf("%s: findOrphanedFiles() = %v, expected %v", test.name, got, test.exp)
+		}
+	}
+}
+
+func TestMinioDeleteOrphanedFiles(t *testing.T) {
+	setTestGlobals(t)
+	c := newFakeStorage()
+	dir := getRemoteDir(buildTypePreRel)
+	keep := []string{
+		remoteJoin(dir, "SumatraPDF-prerel-12345.exe"),
+		remoteJoin(dir, "SumatraPDF-prerel-12345.exe.sha256"),
+		remoteJoin(dir, "SumatraPDF-prerel-12345-manifest.txt"),
+	}
+	orphans := []string{
+		remoteJoin(dir, "SumatraPDF-prerel-12345.zip.sha256"),
+		remoteJoin(dir, "SumatraPDF-prerel-12300.exe.sha256"),
+		remoteJoin(dir, "SumatraPDF-prerel-12300-manifest.txt"),
+	}
+	for _, remotePath := range append(append([]string(nil), keep...), orphans...) {
+		c.put(remotePath, []byte(remotePath))
+	}
+
+	must(minioDeleteOrphanedFiles(c, buildTypePreRel, true))
+	if len(c.sortedKeys(dir)) != len(keep)+len(orphans) {
+		t.Fatalf("dry run deleted files")
+	}
+	must(minioDeleteOrphanedFiles(c, buildTypePreRel, false))
+	got := c.sortedKeys(dir)
+	exp := append([]string(nil), keep...)
+	sort.Strings(exp)
+	if !reflect.DeepEqual(got, exp) {
+		t.Errorf("after deleting orphans have %v, expected %v", got, exp)
+	}
+}
+
+func verifyBuildNotInStoragePanics(c minioStorage, buildType string) bool {
+	return panics(func() { verifyBuildNotInStorageShortMust(c, buildType) })
+}
+
+// checking if a build was already uploaded must look for exactly the
+// manifest that uploading it writes
+func TestVerifyBuildNotInStorageFindsUploadedManifest(t *testing.T) {
+	for _, buildType := range []string{buildTypePreRel, buildTypeDaily, buildTypeRaMicro} {
+		t.Run(buildType, func(t *testing.T) {
+			setTestGlobals(t)
+			c := newFakeStorage()
+			if verifyBuildNotInStoragePanics(c, buildType) {
+				t.Fatalf("build found in empty storage")
+			}
+			minioUploadBuild(c, buildType, writeTestBuild(t, buildType), nil)
+			manifestPath := getManifestRemotePath(buildType, getVerForBuildType(buildType))
+			if indexOf(c.writes(), manifestPath) == -1 {
+				t.Errorf("upload didn't write '%s'", manifestPath)
+			}
+			if !verifyBuildNotInStoragePanics(c, buildType) {
+				t.Errorf("uploaded build not found")
+			}
+		})
+	}
+}
+
+func TestManifestNameOfBuildTypes(t *testing.T) {
+	for buildType, info := range buildTypes {
+		name := manifestName(buildType, "12345")
+		if !isManifestFile(name) {
+			t.Errorf("manifest '%s' of '%s' is not recognized as manifest", name, buildType)
+		}
+		// upload checks names of files start with app name and version
+		if !strings.HasPrefix(name, info.appName+"-12345-") {
+			t.Errorf("manifest '%s' of '%s' doesn't start with '%s-12345-'", name, buildType, info.appName)
+		}
+		if ver, ok := parseVersionFromName(name); buildType != buildTypeRel && (!ok || ver != 12345) {
+			t.Errorf("version of manifest '%s' is %d, expected 12345", name, ver)
+		}
+	}
+}
+
+// fakeStorage that calls onDelete after each delete
+type fakeStorageDeleteHook struct {
+	*fakeStorage
+	onDelete func()
+}
+
+func (s *fakeStorageDeleteHook) Delete(remotePath string) error {
+	err := s.fakeStorage.Delete(remotePath)
+	s.onDelete()
+	return err
+}
+
+func putTestFiles(c *fakeStorage, n int) []string {
+	var keys []string
+	for i := 0; i < n; i++ {
+		key := fmt.Sprintf("software/sumatrapdf/prerel/SumatraPDF-prerel-%d.exe", 12000+i)
+		c.put(key, []byte(key))
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func nDeletes(c *fakeStorage) int {
+	n := 0
+	for _, call := range c.calls {
+		if call.op == fakeOpDelete {
+			n++
+		}
+	}
+	return n
+}
+
+func setDeleteParallel(t *testing.T, n int) {
+	prev := flgDeleteParallel
+	flgDeleteParallel = n
+	t.Cleanup(func() { flgDeleteParallel = prev })
+}
+
+func TestMinioDeleteFiles(t *testing.T) {
+	setDeleteParallel(t, 4)
+	c := newFakeStorage()
+	keys := putTestFiles(c, 20)
+	must(minioDeleteFiles(context.Background(), c, keys))
+	if n := len(c.sortedKeys("")); n != 0 {
+		t.Errorf("%d files left after deleting all", n)
+	}
+	err := minioDeleteFiles(context.Background(), c, keys)
+	if err == nil {
+		t.Errorf("deleting missing files didn't fail")
+	}
+}
+
+func TestMinioDeleteFilesCancelled(t *testing.T) {
+	setDeleteParallel(t, 4)
+	c := newFakeStorage()
+	keys := putTestFiles(c, 20)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := minioDeleteFiles(ctx, c, keys)
+	if err == nil {
+		t.Errorf("expected an error with cancelled context")
+	}
+	if n := nDeletes(c); n != 0 {
+		t.Errorf("deleted %d files with cancelled context", n)
+	}
+}
+
+// deletes in progress finish but no new deletes start after ctx is cancelled
+func TestMinioDeleteFilesCancelledWhileDeleting(t *testing.T) {
+	setDeleteParallel(t, 1)
+	c := newFakeStorage()
+	keys := putTestFiles(c, 20)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	hooked := &fakeStorageDeleteHook{c, cancel}
+	err := minioDeleteFiles(ctx, hooked, keys)
+	if err == nil {
+		t.Errorf("expected an error after cancelling")
+	}
+	if n := nDeletes(c); n != 1 {
+		t.Errorf("deleted %d files, expected 1", n)
+	}
+	if n := len(c.sortedKeys("")); n != len(keys)-1 {
+		t.Errorf("%d files left, expected %d", n, len(keys)-1)
+	}
+	// a key can be sent to the worker after cancelling but it's not deleted
+	if exp := fmt.Sprintf("after deleting 1 out of %d files", len(keys)); err != nil && !strings.Contains(err.Error(), exp) {
+		t.Errorf("error '%s' doesn't have '%s'", err, exp)
+	}
+}
+
+func TestMinioListBuildsCancelled(t *testing.T) {
+	c := newFakeStorage()
+	putTestFiles(c, 3)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	defer func() {
+		if recover() == nil {
+			t.Errorf("listing with cancelled context didn't fail")
+		}
+	}()
+	minioListBuildsCtxMust(ctx, c, buildTypePreRel)
+}
+
+func TestParseVersionFromName(t *testing.T) {
+	tests := []struct {
+		s        string
+		ver      int
+		ok       bool
+		stripped []string
+	}{
+		// version in the name
+		{"software/sumatrapdf/prerel/SumatraPDF-prerel-12345-64.exe", 12345, true, []string{"SumatraPDF-prerel-"}},
+		{"SumatraPDF-prerel-12345.exe", 12345, true, []string{"SumatraPDF-prerel-"}},
+		{"SumatraPDF-prerel-12345-manifest.txt", 12345, true, []string{"SumatraPDF-prerel-"}},
+		{"SumatraPDF-prerel-12345-64.exe.sha256", 12345, true, []string{"SumatraPDF-prerel-"}},
+		{"SumatraPDF-prerelease-11900.zip", 11900, true, []string{"SumatraPDF-prerelease-"}},
+		{"RAMicroPDFViewer-prerel-12345-64.exe", 12345, true, []string{"RAMicroPDFViewer-prerel-"}},
+		{"RAMicro-prerel-12345.exe", 12345, true, []string{"RAMicro-prerel-"}},
+		{"manifest-12345.txt", 12345, true, []string{"manifest-"}},
+		// version in the directory, names don't have it
+		{"software/sumatrapdf/prerel/12345/SumatraPDF-prerel-64.exe", 12345, true, nil},
+		{"software/sumatrapdf/prerel/12345/SumatraPDF-prerel.exe", 12345, true, nil},
+		{"software/sumatrapdf/prerel/12345/notes.txt", 12345, true, nil},
+		// no version
+		{"software/sumatrapdf/prerel/foo.exe", 1, false, nil},
+		{"SumatraPDF-prerel-", 0, false, []string{"SumatraPDF-prerel-"}},
+	}
+	for _, test := range tests {
+		ver, ok, stripped := parseVersionFromNameVerbose(test.s)
+		if ver != test.ver || ok != test.ok || !reflect.DeepEqual(stripped, test.stripped) {
+			t.Errorf("parseVersionFromNameVerbose('%s') = %d, %v, %q, expected %d, %v, %q", test.s, ver, ok, stripped, test.ver, test.ok, test.stripped)
+		}
+		if got := extractVersionFromName(test.s); got != test.ver {
+			t.Errorf("extractVersionFromName('%s') = %d, expected %d", test.s, got, test.ver)
+		}
+	}
+}
+
+// builds with version in the name and in the directory must not be
+// put in the same group
+func TestGroupFilesByVersionLayouts(t *testing.T) {
+	files := []string{
+		"prerel/SumatraPDF-prerel-12300-64.exe",
+		"prerel/SumatraPDF-prerel-12300.exe",
+		"prerel/12301/SumatraPDF-prerel-64.exe",
+		"prerel/12301/SumatraPDF-prerel.exe",
+		"prerel/12302/SumatraPDF-prerel-64.exe",
+	}
+	exp := map[int][]string{
+		12300: {"prerel/SumatraPDF-prerel-12300-64.exe", "prerel/SumatraPDF-prerel-12300.exe"},
+		12301: {"prerel/12301/SumatraPDF-prerel-64.exe", "prerel/12301/SumatraPDF-prerel.exe"},
+		12302: {"prerel/12302/SumatraPDF-prerel-64.exe"},
+	}
+	got := groupsToMap(groupFilesByVersion(files))
+	if !reflect.DeepEqual(got, exp) {
+		t.Errorf("groupFilesByVersion() = %v, expected %v", got, exp)
+	}
+}
+
+func TestIsMd5ETag(t *testing.T) {
+	tests := []struct {
+		etag string
+		exp  bool
+	}{
+		{"d41d8cd98f00b204e9800998ecf8427e", true},
+		{"d41d8cd98f00b204e9800998ecf8427e-2", false},
+		{"d41d8cd98f00b204e9800998ecf8-427", false},
+		{"", false},
+	}
+	for _, test := range tests {
+		if got := isMd5ETag(test.etag); got != test.exp {
+			t.Errorf("isMd5ETag('%s') = %v, expected %v", test.etag, got, test.exp)
+		}
+	}
+}
+
+func TestVerifyReleaseParity(t *testing.T) {
+	prefix := "software/sumatrapdf/prerel/SumatraPDF-prerel-12345"
+	tests := []struct {
+		name string
+		// changes storage after uploading the build
+		change func(c *fakeStorage)
+		ok     bool
+	}{
+		{"same", func(c *fakeStorage) {}, true},
+		{"missing remotely", func(c *fakeStorage) {
+			must(c.Delete(prefix + "-64.zip"))
+		}, false},
+		{"unexpected remotely", func(c *fakeStorage) {
+			c.put(prefix+"-64-install.exe", []byte("left from previous upload"))
+		}, false},
+		{"other version is not unexpected", func(c *fakeStorage) {
+			c.put("software/sumatrapdf/prerel/SumatraPDF-prerel-12344.exe", []byte("previous build"))
+		}, true},
+		{"different size", func(c *fakeStorage) {
+			c.put(prefix+".exe", []byte("different content"))
+		}, false},
+		{"different md5", func(c *fakeStorage) {
+			d := c.files[prefix+".exe"]
+			c.put(prefix+".exe", []byte(strings.ToUpper(string(d))))
+		}, false},
+		// etag of multipart uploads is not md5 so we can only compare sizes
+		{"different content of multipart upload", func(c *fakeStorage) {
+			remotePath := prefix + ".exe"
+			d := c.files[remotePath]
+			c.put(remotePath, []byte(strings.ToUpper(string(d))))
+			c.infos[remotePath].ETag = `"0123456789abcdef0123456789abcdef-2"`
+		}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setTestGlobals(t)
+			dir := writeTestBuild(t, buildTypePreRel)
+			c := newFakeStorage()
+			minioUploadBuild(c, buildTypePreRel, dir, nil)
+			test.change(c)
+			err := verifyReleaseParity(c, buildTypePreRel, dir)
+			if test.ok && err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+			if !test.ok && err == nil {
+				t.Errorf("expected an error")
+			}
+		})
+	}
+}
+
+func panics(fn func()) (panicked bool) {
+	defer func() {
+		panicked = recover() != nil
+	}()
+	fn()
+	return false
+}
+
+func TestMinioVerifyLatestExists(t *testing.T) {
+	c := newFakeStorage()
+	verify := func() bool {
+		return !panics(func() { minioVerifyLatestExistsMust(c, buildTypePreRel) })
+	}
+	if verify() {
+		t.Errorf("no error when *-latest.txt doesn't exist")
+	}
+	c.put(getRemotePaths(buildTypePreRel)[1], []byte("not a version"))
+	if verify() {
+		t.Errorf("no error when *-latest.txt is invalid")
+	}
+	c.put(getRemotePaths(buildTypePreRel)[1], []byte("12345\n"))
+	if verify() {
+		t.Errorf("no error when files of the latest version don't exist")
+	}
+	for _, remotePath := range getLatestArtifactRemotePaths(buildTypePreRel, "12345") {
+		c.put(remotePath, []byte("x"))
+	}
+	if !verify() {
+		t.Errorf("error when files of the latest version exist")
+	}
+}
+
+func TestGetPruneCandidates(t *testing.T) {
+	byVer := []*filesByVer{{ver: 12350}, {ver: 12340}, {ver: 12345}, {ver: 12330}}
+	tests := []struct {
+		latest int
+		pinned map[int]bool
+		exp    []int
+	}{
+		{12350, nil, []int{12330, 12340, 12345}},
+		{12345, nil, []int{12330, 12340, 12350}},
+		{12350, map[int]bool{12330: true, 12345: true}, []int{12340}},
+		// latest not known or not in storage, nothing is kept for it
+		{0, nil, []int{12330, 12340, 12345, 12350}},
+		{12360, map[int]bool{12320: true}, []int{12330, 12340, 12345, 12350}},
+		{12350, map[int]bool{12330: true, 12340: true, 12345: true}, nil},
+	}
+	for _, test := range tests {
+		got := getPruneCandidates(byVer, test.latest, test.pinned)
+		if !reflect.DeepEqual(got, test.exp) {
+			t.Errorf("getPruneCandidates(latest: %d, pinned: %v) = %v, expected %v", test.latest, test.pinned, got, test.exp)
+		}
+	}
+	if got := getPruneCandidates(nil, 12350, nil); got != nil {
+		t.Errorf("getPruneCandidates() of no builds = %v, expected nil", got)
+	}
+}
+
+func TestLatestVersionFromKeys(t *testing.T) {
+	tests := []struct {
+		keys []string
+		exp  int
+	}{
+		{[]string{"SumatraPDF-prerel-12340.exe", "SumatraPDF-prerel-12345-64.exe", "SumatraPDF-prerel-12342.zip"}, 12345},
+		{[]string{"SumatraPDF-prerel-abc.exe", "SumatraPDF-prerel-12340-64.zip", "sumatralatest.js", "junk.txt"}, 12340},
+		{[]string{"SumatraPDF-prerelease-11000.exe", "manifest-12000.txt"}, 12000},
+	}
+	for _, test := range tests {
+		got, err := latestVersionFromKeys(test.keys)
+		if err != nil || got != test.exp {
+			t.Errorf("latestVersionFromKeys(%v) = %d, %v, expected %d", test.keys, got, err, test.exp)
+		}
+	}
+	for _, keys := range [][]string{nil, {"SumatraPDF-prerel-abc.exe", "sumatralatest.js", "junk.txt"}} {
+		if _, err := latestVersionFromKeys(keys); !errors.Is(err, errNoBuilds) {
+			t.Errorf("latestVersionFromKeys(%v) returned %v, expected errNoBuilds", keys, err)
+		}
+	}
+}
+
+func TestMinioLatestVersion(t *testing.T) {
+	c := newFakeStorage()
+	if _, err := minioLatestVersion(c, buildTypePreRel); !errors.Is(err, errNoBuilds) {
+		t.Fatalf("empty storage: got %v, expected errNoBuilds", err)
+	}
+
+	dir := getRemoteDir(buildTypePreRel)
+	for _, name := range []string{"SumatraPDF-prerel-abc.exe", "sumatralatest.js", "junk.txt"} {
+		c.put(dir+name, []byte("x"))
+	}
+	// newer version of a different build type must not be picked up
+	c.put(getRemoteDir(buildTypeDaily)+"SumatraPDF-prerel-99999.exe", []byte("x"))
+	if _, err := minioLatestVersion(c, buildTypePreRel); !errors.Is(err, errNoBuilds) {
+		t.Fatalf("only invalid names: got %v, expected errNoBuilds", err)
+	}
+
+	for _, name := range []string{"SumatraPDF-prerel-12340.exe", "SumatraPDF-prerel-12345-64.exe", "SumatraPDF-prerel-12342-64.zip", "12343/notes.txt"} {
+		c.put(dir+name, []byte("x"))
+	}
+	ver, err := minioLatestVersion(c, buildTypePreRel)
+	if err != nil || ver != 12345 {
+		t.Errorf("minioLatestVersion() = %d, %v, expected 12345", ver, err)
+	}
+}
+
+func TestMinioGetManifestInfo(t *testing.T) {
+	c := newFakeStorage()
+	c.put(getManifestRemotePath(buildTypePreRel, "12340"), []byte("ver: 12340\nsha1: abc\ndate: 2019-05-06\nmin-os-version: 32=6.1,64=10.0\n"))
+	// older manifests don't have the date so the upload time is used
+	c.put(getManifestRemotePath(buildTypePreRel, "12341"), []byte("ver: 12341\nsha1: abc\n"))
+	tests := []struct {
+		ver string
+		exp manifestInfo
+	}{
+		{"12340", manifestInfo{date: "2019-05-06", minOSVersion: "32=6.1,64=10.0"}},
+		{"12341", manifestInfo{date: c.now.Format(buildDateFormat)}},
+	}
+	for _, test := range tests {
+		got := minioGetManifestInfo(c, buildTypePreRel, test.ver)
+		if got != test.exp {
+			t.Errorf("minioGetManifestInfo('%s') = %+v, expected %+v", test.ver, got, test.exp)
+		}
+	}
+}
+
+// -regen-latest-info for an older build must use its minimum OS version
+// and not -min-os-version of the current invocation
+func TestRegenerateLatestInfoUsesBuildMinOSVersion(t *testing.T) {
+	setTestGlobals(t)
+	flgMinOSVersion = "10.0"
+	c := newFakeStorage()
+	dir := getRemoteDir(buildTypePreRel)
+	for _, ver := range []string{"12340", "12341"} {
+		c.put(dir+"SumatraPDF-prerel-"+ver+"-64.exe", []byte("x"))
+	}
+	c.put(getManifestRemotePath(buildTypePreRel, "12340"), []byte("ver: 12340\nsha1: abc\nmin-os-version: 6.1\n"))
+	c.put(getManifestRemotePath(buildTypePreRel, "12341"), []byte("ver: 12341\nsha1: abc\n"))
+
+	updatePath := getRemotePaths(buildTypePreRel)[2]
+	tests := []struct {
+		ver int
+		exp UpdateInfo
+	}{
+		{12340, UpdateInfo{Latest: "12340", MinOSVersion: "6.1"}},
+		{12341, UpdateInfo{Latest: "12341"}},
+	}
+	for _, test := range tests {
+		byVer, _ := minioListBuildsMust(c, buildTypePreRel)
+		minioUploadVersionInfoForVer(c, buildTypePreRel, test.ver, byVer)
+		got, err := parseUpdateTxt(c.files[updatePath])
+		if err != nil {
+			t.Fatalf("parseUpdateTxt() failed with %s", err)
+		}
+		exp := test.exp
+		exp.Other = map[string]string{}
+		if !reflect.DeepEqual(*got, exp) {
+			t.Errorf("version %d: update.txt is %+v, expected %+v", test.ver, *got, exp)
+		}
+	}
+}
+
+// version info files of a build must be the same no matter when
+// they are generated, so that re-running an upload is a no-op
+func TestVersionFilesUseBuildDate(t *testing.T) {
+	setTestGlobals(t)
+	dir := writeTestBuild(t, buildTypePreRel)
+	manifestPath := filepath.Join(dir, manifestName(buildTypePreRel, "12345"))
+	d := fmt.Sprintf("ver: 12345\nsha1: %s\ndate: 2019-05-06\n", getGitSha1())
+	must(ioutil.WriteFile(manifestPath, []byte(d), 0644))
+	for _, f := range getVersionFilesForLatestInfo(buildTypePreRel, dir) {
+		if f[0] == getRemotePaths(buildTypePreRel)[0] && !strings.Contains(f[1], `var sumBuiltOn = "2019-05-06";`) {
+			t.Errorf("'%s' doesn't have the date from the manifest:\n%s", f[0], f[1])
+		}
+	}
+}
+
+func TestMinioMigrateLegacyNames(t *testing.T) {
+	dir := getRemoteDir(buildTypePreRel)
+	latestPath := getRemotePaths(buildTypePreRel)[1]
+	legacy := []string{dir + "SumatraPDF-prerelease-12340.exe", dir + "SumatraPDF-prerelease-12345.exe"}
+
+	c := newFakeStorage()
+	for _, remotePath := range legacy {
+		c.put(remotePath, []byte("x"))
+	}
+	c.put(latestPath, []byte("not a version"))
+	if err := minioMigrateLegacyNames(c, buildTypePreRel, false, false); err == nil {
+		t.Errorf("no error when '%s' is invalid", latestPath)
+	}
+	if n := len(c.writes()); n != 0 {
+		t.Errorf("%d files written when '%s' is invalid", n, latestPath)
+	}
+
+	// files of the latest version are only renamed with renameLatest
+	c.put(latestPath, []byte("12345\n"))
+	must(minioMigrateLegacyNames(c, buildTypePreRel, false, false))
+	exp := []string{dir + "SumatraPDF-prerel-12340.exe", dir + "SumatraPDF-prerelease-12345.exe"}
+	if got := c.sortedKeys(dir); !reflect.DeepEqual(got, exp) {
+		t.Errorf("after migration files are %v, expected %v", got, exp)
+	}
+
+	// without *-latest.txt there's no latest version to keep
+	c = newFakeStorage()
+	for _, remotePath := range legacy {
+		c.put(remotePath, []byte("x"))
+	}
+	must(minioMigrateLegacyNames(c, buildTypePreRel, false, false))
+	exp = []string{dir + "SumatraPDF-prerel-12340.exe", dir + "SumatraPDF-prerel-12345.exe"}
+	if got := c.sortedKeys(dir); !reflect.DeepEqual(got, exp) {
+		t.Errorf("after migration files are %v, expected %v", got, exp)
+	}
+}
+
+// retention only needs names of files and the newest modification time
+// of each version, which are aggregated while the listing is streamed
+func TestRetentionFromGroupedListing(t *testing.T) {
+	c := newFakeStorage()
+	start := c.now
+	day := 24 * time.Hour
+	dir := getRemoteDir(buildTypePreRel)
+	for i := 0; i < 5; i++ {
+		c.now = start.Add(time.Duration(i) * day)
+		c.put(fmt.Sprintf("%sSumatraPDF-prerel-%d.exe", dir, 12000+i), []byte("x"))
+		c.put(fmt.Sprintf("%sSumatraPDF-prerel-%d-64.exe", dir, 12000+i), []byte("x"))
+	}
+	// a file of version 12002 re-uploaded a day later makes the version recent
+	c.now = start.Add(3 * day)
+	c.put(dir+"SumatraPDF-prerel-12002.zip", []byte("x"))
+
+	byVer := minioGroupBuildsCtxMust(context.Background(), c, buildTypePreRel, nil)
+	if n := countFiles(byVer); n != 11 {
+		t.Errorf("countFiles() = %d, expected 11", n)
+	}
+	for _, v := range byVer {
+		var exp time.Time
+		for _, remotePath := range v.files {
+			if lm := c.infos[remotePath].LastModified; lm.After(exp) {
+				exp = lm
+			}
+		}
+		if !v.lastModified.Equal(exp) {
+			t.Errorf("version %d: lastModified is %s, expected %s", v.ver, v.lastModified, exp)
+		}
+	}
+
+	var candidates []int
+	for _, v := range byVer {
+		candidates = append(candidates, v.ver)
+	}
+	policy := retentionPolicy{nRetain: 1, minAge: 36 * time.Hour}
+	_, vers := getFilesToDelete(byVer, policy, candidates, start.Add(4*day))
+	if exp := []int{12001, 12000}; !reflect.DeepEqual(vers, exp) {
+		t.Errorf("deleted versions %v, expected %v", vers, exp)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/minio/minio-go/v6"
+	"github.com/minio/minio-go/v6/pkg/encrypt"
+)
+
+const (
+	// storage encrypts with keys it manages
+	sseS3 = "s3"
+	// storage encrypts with a key from key management service
+	sseKMS = "kms"
+)
+
+// server-side encryption of uploaded files, set with -sse
+type sseConfig struct {
+	kind     string
+	kmsKeyID string
+}
+
+// nil means files are not encrypted (the default)
+var uploadSSE *sseConfig
+
+// parses -sse which is "", "s3" or "kms:${keyID}"
+func parseSSE(s string) (*sseConfig, error) {
+	if s == "" {
+		return nil, nil
+	}
+	parts := strings.SplitN(s, ":", 2)
+	switch {
+	case parts[0] == sseS3 && len(parts) == 1:
+		return &sseConfig{kind: sseS3}, nil
+	case parts[0] == sseKMS && len(parts) == 2 && parts[1] != "":
+		return &sseConfig{kind: sseKMS, kmsKeyID: parts[1]}, nil
+	}
+	return nil, fmt.Errorf("invalid server-side encryption '%s', must be s3 or kms:${keyID}", s)
+}
+
+// returns an error if c is set and storages include s3. Only uploads to
+// spaces are encrypted: goamz, which we use for s3, can't send a kms key
+// and we don't want -sse to leave a copy of the build unencrypted
+func checkSSEStorages(c *sseConfig, storages []string) error {
+	if c != nil && hasStorage(storages, storageS3) {
+		return fmt.Errorf("-sse is only supported for spaces but the build is also uploaded to s3 (see -upload-storages)")
+	}
+	return nil
+}
+
+func (c *sseConfig) serverSide() encrypt.ServerSide {
+	if c == nil {
+		return nil
+	}
+	if c.kind == sseS3 {
+		return encrypt.NewSSE()
+	}
+	sse, err := encrypt.NewSSEKMS(c.kmsKeyID, nil)
+	// only fails for invalid context and we don't use one
+	must(err)
+	return sse
+}
+
+// returns an error if oi (from StatObject()) wasn't stored with
+// the encryption we asked for. Does nothing if c is nil
+func (c *sseConfig) verify(oi minio.ObjectInfo) error {
+	if c == nil {
+		return nil
+	}
+	algo := oi.Metadata.Get("X-Amz-Server-Side-Encryption")
+	expAlgo := "AES256"
+	if c.kind == sseKMS {
+		expAlgo = "aws:kms"
+	}
+	if algo != expAlgo {
+		return fmt.Errorf("'%s' is encrypted with '%s', expected '%s'", oi.Key, algo, expAlgo)
+	}
+	if c.kind == sseKMS {
+		keyID := oi.Metadata.Get("X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id")
+		// s3 returns key arn even if we gave key id
+		if keyID != c.kmsKeyID && !strings.HasSuffix(keyID, "/"+c.kmsKeyID) {
+			return fmt.Errorf("'%s' is encrypted with kms key '%s', expected '%s'", oi.Key, keyID, c.kmsKeyID)
+		}
+	}
+	return nil
+}
+
+func minioVerifyEncryption(c minioStorage, remotePath string) error {
+	if uploadSSE == nil {
+		return nil
+	}
+	oi, err := c.StatObject(remotePath)
+	if err != nil {
+		return err
+	}
+	return uploadSSE.verify(oi)
+}
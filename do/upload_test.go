@@ -0,0 +1,247 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/minio/minio-go/v6"
+)
+
+var publicRead = map[string]string{"x-amz-acl": "public-read"}
+
+func TestGetUploadPolicy(t *testing.T) {
+	tests := []struct {
+		remotePath   string
+		contentType  string
+		cacheControl string
+		public       bool
+	}{
+		{"prerel/SumatraPDF-prerel-12345-64.exe", "application/octet-stream", cacheImmutable, true},
+		{"prerel/SumatraPDF-prerel-12345-64.zip", "application/zip", cacheImmutable, true},
+		{"prerel/SumatraPDF-prerel-12345-64.pdb.zip", "application/zip", cacheImmutable, true},
+		{"symbols/SumatraPDF.pdb/ABC1/SumatraPDF.pdb", "application/octet-stream", cacheImmutable, true},
+		{"prerel/SumatraPDF-prerel-12345-64.pdb.lzsa", "application/octet-stream", cacheImmutable, true},
+		{"prerel/SumatraPDF-prerel-12345-64.tar.zst", "application/zstd", cacheImmutable, true},
+		{"prerel/SumatraPDF-prerel-12345-64.exe.sha1", "text/plain; charset=utf-8", cacheImmutable, true},
+		{"prerel/SumatraPDF-prerel-12345-64.exe.sha256", "text/plain; charset=utf-8", cacheImmutable, true},
+		{"prerel/SumatraPDF-prerel-12345-64.exe.sha512", "text/plain; charset=utf-8", cacheImmutable, true},
+		{"prerel/SumatraPDF-prerel-12345-manifest.txt.gz", "application/gzip", cacheImmutable, true},
+		{"sumatralatest.js", "application/javascript; charset=utf-8", cacheNone, true},
+		{"sumpdf-prerelease-latest.txt", "text/plain; charset=utf-8", cacheNone, true},
+		{"prerel/SumatraPDF-prerel-12345-manifest.txt", "text/plain; charset=utf-8", cacheNone, true},
+		{"prerel/12345/build.json", "application/json", cacheNone, true},
+		{"prerel-feed.xml", "application/atom+xml; charset=utf-8", cacheNone, true},
+		{"prerel.lock", "text/plain; charset=utf-8", cacheNone, false},
+		// extensions are matched case-insensitively
+		{"prerel/SUMATRAPDF.EXE", "application/octet-stream", cacheImmutable, true},
+		// unknown extensions get a safe default
+		{"prerel/foo.bin", "application/octet-stream", cacheNone, false},
+		{"prerel/noext", "application/octet-stream", cacheNone, false},
+	}
+	for _, test := range tests {
+		opts := getUploadPolicy(test.remotePath).putObjectOptions()
+		if opts.ContentType != test.contentType {
+			t.Errorf("'%s': content type is '%s', expected '%s'", test.remotePath, opts.ContentType, test.contentType)
+		}
+		if opts.CacheControl != test.cacheControl {
+			t.Errorf("'%s': cache control is '%s', expected '%s'", test.remotePath, opts.CacheControl, test.cacheControl)
+		}
+		var expMeta map[string]string
+		if test.public {
+			expMeta = publicRead
+		}
+		if !reflect.DeepEqual(opts.UserMetadata, expMeta) {
+			t.Errorf("'%s': metadata is %v, expected %v", test.remotePath, opts.UserMetadata, expMeta)
+		}
+		if opts.ServerSideEncryption != nil {
+			t.Errorf("'%s': encrypted without -sse", test.remotePath)
+		}
+	}
+}
+
+func TestPutObjectOptionsSSE(t *testing.T) {
+	prevSSE := uploadSSE
+	defer func() { uploadSSE = prevSSE }()
+	uploadSSE = &sseConfig{kind: sseS3}
+	opts := getUploadPolicy("a.exe").putObjectOptions()
+	if opts.ServerSideEncryption == nil {
+		t.Errorf("not encrypted with -sse s3")
+	}
+}
+
+func TestParseSSE(t *testing.T) {
+	tests := []struct {
+		s   string
+		exp *sseConfig
+	}{
+		{"", nil},
+		{"s3", &sseConfig{kind: sseS3}},
+		{"kms:key-1", &sseConfig{kind: sseKMS, kmsKeyID: "key-1"}},
+		// key arns have ':' in them
+		{"kms:arn:aws:kms:us-east-1:1234:key/key-1", &sseConfig{kind: sseKMS, kmsKeyID: "arn:aws:kms:us-east-1:1234:key/key-1"}},
+	}
+	for _, test := range tests {
+		got, err := parseSSE(test.s)
+		if err != nil {
+			t.Errorf("parseSSE('%s') failed with %s", test.s, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.exp) {
+			t.Errorf("parseSSE('%s') = %v, expected %v", test.s, got, test.exp)
+		}
+	}
+	for _, s := range []string{"kms", "kms:", "s3:key-1", "aes", "S3"} {
+		if _, err := parseSSE(s); err == nil {
+			t.Errorf("parseSSE('%s') didn't fail", s)
+		}
+	}
+}
+
+func TestSSEConfigVerify(t *testing.T) {
+	objectInfo := func(algo string, keyID string) minio.ObjectInfo {
+		oi := minio.ObjectInfo{Key: "a.exe", Metadata: http.Header{}}
+		if algo != "" {
+			oi.Metadata.Set("X-Amz-Server-Side-Encryption", algo)
+		}
+		if keyID != "" {
+			oi.Metadata.Set("X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id", keyID)
+		}
+		return oi
+	}
+	sseS3Config := &sseConfig{kind: sseS3}
+	sseKMSConfig := &sseConfig{kind: sseKMS, kmsKeyID: "key-1"}
+	tests := []struct {
+		c     *sseConfig
+		oi    minio.ObjectInfo
+		valid bool
+	}{
+		{nil, objectInfo("", ""), true},
+		{nil, objectInfo("AES256", ""), true},
+		{sseS3Config, objectInfo("AES256", ""), true},
+		{sseS3Config, objectInfo("", ""), false},
+		{sseS3Config, objectInfo("aws:kms", "key-1"), false},
+		{sseKMSConfig, objectInfo("aws:kms", "key-1"), true},
+		// s3 returns key arn
+		{sseKMSConfig, objectInfo("aws:kms", "arn:aws:kms:us-east-1:1234:key/key-1"), true},
+		{sseKMSConfig, objectInfo("aws:kms", "arn:aws:kms:us-east-1:1234:key/other-key-1"), false},
+		{sseKMSConfig, objectInfo("aws:kms", "key-2"), false},
+		{sseKMSConfig, objectInfo("aws:kms", ""), false},
+		{sseKMSConfig, objectInfo("AES256", ""), false},
+		{sseKMSConfig, objectInfo("", ""), false},
+	}
+	for i, test := range tests {
+		err := test.c.verify(test.oi)
+		if test.valid && err != nil {
+			t.Errorf("test %d: verify() failed with %s", i, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("test %d: verify() didn't fail", i)
+		}
+	}
+}
+
+// goamz can't encrypt uploads to s3 with a kms key so -sse is refused
+// rather than leaving the s3 copy unencrypted
+func TestCheckSSEStorages(t *testing.T) {
+	c := &sseConfig{kind: sseS3}
+	if err := checkSSEStorages(nil, []string{storageS3, storageSpaces}); err != nil {
+		t.Errorf("failed without -sse: %s", err)
+	}
+	if err := checkSSEStorages(c, []string{storageSpaces}); err != nil {
+		t.Errorf("failed for spaces: %s", err)
+	}
+	if err := checkSSEStorages(c, []string{storageS3, storageSpaces}); err == nil {
+		t.Errorf("didn't fail for s3")
+	}
+}
+
+// every file written by the upload must use options of its policy.
+// Version info files are first uploaded as ${name}.tmp with options
+// of ${name}
+func TestUploadsUseUploadPolicy(t *testing.T) {
+	setTestGlobals(t)
+	dir := writeTestBuild(t, buildTypePreRel)
+	c := newFakeStorage()
+	minioUploadBuild(c, buildTypePreRel, dir, nil)
+	for _, call := range c.calls {
+		if call.op == fakeOpDelete {
+			continue
+		}
+		exp := getUploadPolicy(strings.TrimSuffix(call.remotePath, ".tmp")).putObjectOptions()
+		got := call.opts
+		if got.ContentType != exp.ContentType || got.CacheControl != exp.CacheControl || !reflect.DeepEqual(got.UserMetadata, exp.UserMetadata) {
+			t.Errorf("'%s' was written with %s, expected %s", call.remotePath, fmtPutOptions(got), fmtPutOptions(exp))
+		}
+	}
+}
+
+func fmtPutOptions(opts minio.PutObjectOptions) string {
+	return opts.ContentType + ", " + opts.CacheControl + ", acl: " + opts.UserMetadata["x-amz-acl"]
+}
+
+func TestIsLatestBuildSameSha1(t *testing.T) {
+	setTestGlobals(t)
+	c := newFakeStorage()
+	if isLatestBuildSameSha1(c, buildTypePreRel) {
+		t.Errorf("same sha1 when there are no builds")
+	}
+
+	// a manifest of an older build with the same sha1 doesn't matter
+	c.put(getManifestRemotePath(buildTypePreRel, "12340"), []byte("ver: 12340\nsha1: "+getGitSha1()+"\n"))
+	c.put(getManifestRemotePath(buildTypePreRel, "12344"), []byte("ver: 12344\nsha1: 1111111111111111111111111111111111111111\n"))
+	if isLatestBuildSameSha1(c, buildTypePreRel) {
+		t.Errorf("same sha1 when the latest build is from a different sha1")
+	}
+
+	c.put(getManifestRemotePath(buildTypePreRel, "12344"), []byte("ver: 12344\nsha1: "+getGitSha1()+"\n"))
+	if !isLatestBuildSameSha1(c, buildTypePreRel) {
+		t.Errorf("not same sha1 when the latest build is from the same sha1")
+	}
+	flgForceUpload = true
+	if isLatestBuildSameSha1(c, buildTypePreRel) {
+		t.Errorf("same sha1 with -force")
+	}
+}
+
+func TestForEachStorage(t *testing.T) {
+	var called []string
+	err := forEachStorage("upload build", []string{storageS3, storageSpaces}, func(storage string) {
+		called = append(called, storage)
+		panicIf(storage == storageS3, "s3 is down")
+	})
+	if exp := []string{storageS3, storageSpaces}; !reflect.DeepEqual(called, exp) {
+		t.Errorf("called for %v, expected %v", called, exp)
+	}
+	me, ok := err.(*MultiError)
+	if !ok {
+		t.Fatalf("expected *MultiError, got %v", err)
+	}
+	errs := me.Errors()
+	if len(errs) != 1 {
+		t.Fatalf("got %d errors, expected 1: %s", len(errs), err)
+	}
+	ie := errs[0].(*itemError)
+	if ie.backend != storageS3 || !strings.Contains(ie.Error(), "s3 is down") {
+		t.Errorf("error is '%s' for backend '%s', expected s3 failure", ie, ie.backend)
+	}
+
+	err = forEachStorage("upload build", []string{storageS3, storageSpaces}, func(storage string) {})
+	if err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
+
+func TestItemErrorHasBackend(t *testing.T) {
+	errs := newMultiError("delete files", 2)
+	errs.AddForBackend(storageSpaces, "software/sumatrapdf/prerel/a.exe", errors.New("access denied"))
+	errs.Add("local.exe", errors.New("not found"))
+	s := errs.Error()
+	for _, exp := range []string{"spaces: 'software/sumatrapdf/prerel/a.exe': access denied", "'local.exe': not found", "(2 out of 2)"} {
+		if !strings.Contains(s, exp) {
+			t.Errorf("'%s' doesn't have '%s'", s, exp)
+		}
+	}
+}
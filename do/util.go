@@ -2,14 +2,21 @@ package main
 
 import (
 	"bytes"
+	"crypto"
 	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
 	"fmt"
+	"hash"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"sync"
 	"text/template"
 	"time"
 
@@ -81,6 +88,37 @@ func fileSizeMust(path string) int64 {
 	return size
 }
 
+// returns out/work/${name}, creating it if needed. Commands put intermediate
+// files there instead of in a system temp dir so that they can be inspected
+// if the command fails. Remove with removeWorkDir() on success
+func workDir(name string) string {
+	dir := filepath.Join("out", "work", name)
+	err := os.MkdirAll(dir, 0755)
+	must(err)
+	return dir
+}
+
+func removeWorkDir(name string) {
+	dir := filepath.Join("out", "work", name)
+	err := os.RemoveAll(dir)
+	if err != nil {
+		logf("Failed to remove '%s', err: %s\n", dir, err)
+	}
+}
+
+// returns total size of files in dir, not recursive
+func dirSizeMust(dir string) int64 {
+	files, err := ioutil.ReadDir(dir)
+	must(err)
+	var res int64
+	for _, f := range files {
+		if !f.IsDir() {
+			res += f.Size()
+		}
+	}
+	return res
+}
+
 func pathExists(path string) bool {
 	_, err := os.Stat(path)
 	return err == nil
@@ -188,8 +226,131 @@ func fileSha1Hex(path string) (string, error) {
 	return fmt.Sprintf("%x", sha1[:]), nil
 }
 
+// hash algorithms we can create checksums with. The name is also the
+// extension of checksum files (e.g. foo.exe.sha256) and the prefix of
+// lines in manifest (see copyBuiltManifest())
+var checksumAlgos = map[string]crypto.Hash{
+	"sha1":   crypto.SHA1,
+	"sha256": crypto.SHA256,
+	"sha512": crypto.SHA512,
+}
+
+// crypto.Hash.New() panics unless the package implementing
+// the algorithm is linked in
+var _ = sha256.New
+var _ = sha512.New
+
+func checksumAlgoName(algo crypto.Hash) string {
+	for name, h := range checksumAlgos {
+		if h == algo {
+			return name
+		}
+	}
+	panic(fmt.Sprintf("unsupported checksum algorithm %d", algo))
+}
+
+// parses comma-separated names of hash algorithms e.g. "sha256,sha512"
+func parseChecksumAlgos(s string) ([]crypto.Hash, error) {
+	var res []crypto.Hash
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		algo, ok := checksumAlgos[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown checksum algorithm '%s' in '%s', must be sha1, sha256 or sha512", name, s)
+		}
+		res = append(res, algo)
+	}
+	return res, nil
+}
+
+// returns name of hash algorithm if path is a checksum file
+// e.g. foo.exe.sha256
+func checksumFileAlgo(path string) (string, bool) {
+	name := strings.TrimPrefix(filepath.Ext(path), ".")
+	_, ok := checksumAlgos[name]
+	return name, ok
+}
+
+// returns hex digests of file at path for each of algos. The file is
+// read once and each hasher runs in its own goroutine, fed by a pipe,
+// so that hashing big files with several algorithms uses multiple cores
+func computeChecksums(path string, algos []crypto.Hash) (map[crypto.Hash]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	hashers := map[crypto.Hash]hash.Hash{}
+	var writers []io.Writer
+	var pipes []*io.PipeWriter
+	var wg sync.WaitGroup
+	for _, algo := range algos {
+		h := algo.New()
+		hashers[algo] = h
+		pr, pw := io.Pipe()
+		writers = append(writers, pw)
+		pipes = append(pipes, pw)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// writing to hash.Hash never fails so this only ends when
+			// the pipe is closed
+			_, _ = io.Copy(h, pr)
+		}()
+	}
+	_, err = io.Copy(io.MultiWriter(writers...), f)
+	for _, pw := range pipes {
+		pw.CloseWithError(err)
+	}
+	wg.Wait()
+	if err != nil {
+		return nil, err
+	}
+	res := map[crypto.Hash]string{}
+	for algo, h := range hashers {
+		res[algo] = fmt.Sprintf("%x", h.Sum(nil))
+	}
+	return res, nil
+}
+
+// like httpDlMust() but returns an error, also for non-200 responses
+func httpDl(uri string) ([]byte, error) {
+	rsp, err := getHTTPClient().Get(uri)
+	if err != nil {
+		return nil, err
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET '%s' returned %s", uri, rsp.Status)
+	}
+	return ioutil.ReadAll(rsp.Body)
+}
+
+// calls fn up to nAttempts times until it succeeds, waiting longer
+// after each failure. Returns the last error. fn is always called
+// at least once
+func retry(what string, nAttempts int, fn func() error) error {
+	if nAttempts < 1 {
+		nAttempts = 1
+	}
+	var err error
+	wait := time.Second
+	for i := 1; i <= nAttempts; i++ {
+		err = fn()
+		if err == nil {
+			return nil
+		}
+		if i < nAttempts {
+			logf("%s failed (attempt %d of %d), retrying in %s. err: %s\n", what, i, nAttempts, wait, err)
+			time.Sleep(wait)
+			wait *= 2
+		}
+	}
+	return fmt.Errorf("%s failed after %d attempts, err: %w", what, nAttempts, err)
+}
+
 func httpDlMust(uri string) []byte {
-	res, err := http.Get(uri)
+	res, err := getHTTPClient().Get(uri)
 	panicIfErr(err)
 	d, err := ioutil.ReadAll(res.Body)
 	res.Body.Close()
@@ -280,6 +441,15 @@ func urlify(title string) string {
 	return s
 }
 
+// escapes each segment of a '/'-separated path for use in a url
+func escapeURLPath(s string) string {
+	parts := strings.Split(s, "/")
+	for i, part := range parts {
+		parts[i] = url.PathEscape(part)
+	}
+	return strings.Join(parts, "/")
+}
+
 func dumpEnv() {
 	env := os.Environ()
 	logf("\nEnv:\n")
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"crypto"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEscapeURLPath(t *testing.T) {
+	tests := []struct {
+		s   string
+		exp string
+	}{
+		{"software/sumatrapdf/rel/3.2", "software/sumatrapdf/rel/3.2"},
+		{"software/sumatrapdf/prerel/SumatraPDF-prerel-12345-64.exe", "software/sumatrapdf/prerel/SumatraPDF-prerel-12345-64.exe"},
+		{"software/sumatrapdf/rel/3.2 beta", "software/sumatrapdf/rel/3.2%20beta"},
+		{"software/sumatrapdf/rel/3.2?x=1#y", "software/sumatrapdf/rel/3.2%3Fx=1%23y"},
+		{"software/sumatrapdf/rel/100%", "software/sumatrapdf/rel/100%25"},
+	}
+	for _, test := range tests {
+		got := escapeURLPath(test.s)
+		if got != test.exp {
+			t.Errorf("escapeURLPath('%s') = '%s', expected '%s'", test.s, got, test.exp)
+		}
+	}
+}
+
+func TestCreateSumatraLatestJsEscapesVersion(t *testing.T) {
+	prevMode := flgLatestJsURLs
+	defer func() { flgLatestJsURLs = prevMode }()
+	prevZstd := flgZstd
+	defer func() { flgZstd = prevZstd }()
+	flgLatestJsURLs = urlModeAbsolute
+	flgZstd = false
+
+	host := spacesURLBase + "software/sumatrapdf/rel/"
+	tests := []struct {
+		ver string
+		exp string
+	}{
+		{"3.2", host + "SumatraPDF-3.2-64.exe"},
+		{"3.2.1", host + "SumatraPDF-3.2.1-64.exe"},
+		{"3.2 beta", host + "SumatraPDF-3.2%20beta-64.exe"},
+		{"3.2#1", host + "SumatraPDF-3.2%231-64.exe"},
+		{"3.2/../x", host + "SumatraPDF-3.2%2F..%2Fx-64.exe"},
+	}
+	for _, test := range tests {
+		js := createSumatraLatestJsForVer(buildTypeRel, test.ver, "abc", "2020-01-01", buildArchs{has64: true})
+		exp := `var sumLatestExe64       = "` + test.exp + `";`
+		if !strings.Contains(js, exp) {
+			t.Errorf("version '%s': sumatralatest.js doesn't have %s:\n%s", test.ver, exp, js)
+		}
+	}
+}
+
+func TestRetryCallsFnAtLeastOnce(t *testing.T) {
+	errFailed := errors.New("failed")
+	for _, nAttempts := range []int{-1, 0, 1} {
+		nCalls := 0
+		err := retry("test", nAttempts, func() error {
+			nCalls++
+			return errFailed
+		})
+		if nCalls != 1 {
+			t.Errorf("retry() with %d attempts called fn %d times, expected 1", nAttempts, nCalls)
+		}
+		if !errors.Is(err, errFailed) {
+			t.Errorf("retry() with %d attempts returned %v, expected %v", nAttempts, err, errFailed)
+		}
+	}
+	nCalls := 0
+	err := retry("test", 0, func() error {
+		nCalls++
+		return nil
+	})
+	if err != nil || nCalls != 1 {
+		t.Errorf("retry() of successful fn returned %v after %d calls", err, nCalls)
+	}
+}
+
+func TestComputeChecksums(t *testing.T) {
+	// bigger than io.Copy buffer so that hashers get several writes
+	d := []byte(strings.Repeat("SumatraPDF ", 10000))
+	path := filepath.Join(t.TempDir(), "SumatraPDF.exe")
+	must(ioutil.WriteFile(path, d, 0644))
+	exp := map[crypto.Hash]string{
+		crypto.MD5:    fmt.Sprintf("%x", md5.Sum(d)),
+		crypto.SHA1:   fmt.Sprintf("%x", sha1.Sum(d)),
+		crypto.SHA256: fmt.Sprintf("%x", sha256.Sum256(d)),
+	}
+	for _, algos := range [][]crypto.Hash{{crypto.SHA256}, {crypto.MD5, crypto.SHA1, crypto.SHA256}} {
+		got, err := computeChecksums(path, algos)
+		if err != nil {
+			t.Fatalf("computeChecksums() failed with %s", err)
+		}
+		if len(got) != len(algos) {
+			t.Errorf("got %d checksums, expected %d", len(got), len(algos))
+		}
+		for _, algo := range algos {
+			if got[algo] != exp[algo] {
+				t.Errorf("%s is %s, expected %s", algo, got[algo], exp[algo])
+			}
+		}
+	}
+	if _, err := computeChecksums(path+".missing", []crypto.Hash{crypto.SHA256}); err == nil {
+		t.Errorf("no error for missing file")
+	}
+}